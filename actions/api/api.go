@@ -0,0 +1,434 @@
+// Package api is a JSON-RPC 2.0 control API for a connected core, for test
+// harnesses driving the target programmatically.  Requests are POSTed to the
+// server over HTTP on a TCP port or a Unix socket, the probe stays connected
+// and configured between them, so one server is one persistent session with
+// one probe.  A failed request gets an error object with a code and message,
+// and for errors from the target the method that failed in its data.  Only
+// requests with a Content-Type of application/json and without an Origin are
+// served, so a web page in a browser on the same host can't drive the target.
+//
+// The methods with their params and results, addresses are numbers and
+// memory contents hex strings, params in brackets are optional.  Files are
+// opened in the server's Dir, without one flash and verify take only data:
+//
+//	connect -> {"cpuid": "...", "state": "halted", "halted": true, "pc": 1024}
+//	status, halt, resume -> {"state": "halted", "halted": true, "pc": 1024}
+//	reset [{"strategy": "sys", "halt": true}] -> the state
+//	readMemory {"address": 536870912, "length": 16} -> {"data": "efbeadde..."}, 64KB at most
+//	writeMemory {"address": 536870912, "data": "efbeadde"}
+//	flash {"file": "fw.elf"} or {"address": 0, "data": "..."} -> [{"address": 0, "length": 1024}], programs then verifies
+//	verify {"file": "fw.elf"} or {"address": 0, "data": "..."} -> [{"address": 0, "length": 1024}]
+//	measureCycles {"start": 1042, "end": 1072, ["iterations": 10, "timeoutMs": 10000, "clockHz": 48e6]}
+//	    -> {"cycles": [...], "stats": {"n": 10, "min": ..., "max": ..., "mean": ..., "stddev": ...}, "seconds": [...]}
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goocd/actions/cycles"
+	"goocd/core"
+	"goocd/core/cortexm"
+	"goocd/fileformats/autoparser"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The JSON-RPC 2.0 error codes, CodeTarget is for every error the target or the probe returns
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeTarget         = -32000
+	CodeVerify         = -32001
+)
+
+// Version is the JSON-RPC version of every request and response.
+const Version = "2.0"
+
+// MaxReadLength is the most bytes one readMemory returns.
+const MaxReadLength = 0x10000
+
+// Request is a JSON-RPC request.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// Response is a JSON-RPC response, with either a Result or an Error.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Error is a JSON-RPC error object.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("error: api %d %s", e.Code, e.Message)
+}
+
+// State is the result of the methods that change or report the core's state.
+type State struct {
+	CPUID string `json:"cpuid,omitempty"`
+	// State is running, halted, sleeping, lockup or reset
+	State  string `json:"state"`
+	Halted bool   `json:"halted"`
+	// PC is only reported when halted
+	PC uint32 `json:"pc,omitempty"`
+}
+
+// Server serves the API for a core, one request at a time.
+type Server struct {
+	Core core.Core
+	// Program writes data to the flash at addr for flash, without it flash fails
+	Program func(addr uint32, data []byte) error
+	// ResetStrategy is what reset uses unless told otherwise
+	ResetStrategy cortexm.ResetStrategy
+	// Dir is the directory flash and verify open files in, names can't climb out of it.  Without it they take
+	// only data.
+	Dir string
+
+	mu sync.Mutex
+}
+
+// methods are the API's methods, by name.
+var methods = map[string]func(s *Server, params json.RawMessage) (interface{}, error){
+	"connect":       (*Server).connect,
+	"status":        (*Server).status,
+	"halt":          (*Server).halt,
+	"resume":        (*Server).resume,
+	"reset":         (*Server).reset,
+	"readMemory":    (*Server).readMemory,
+	"writeMemory":   (*Server).writeMemory,
+	"flash":         (*Server).flash,
+	"verify":        (*Server).verify,
+	"measureCycles": (*Server).measureCycles,
+}
+
+// ListenAndServe serves the API over HTTP on addr, network is "tcp" or "unix" for a socket path.
+func (s *Server) ListenAndServe(network, addr string) error {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return http.Serve(l, s)
+}
+
+// ServeHTTP answers a JSON-RPC request POSTed to any path.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests are POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Origin") != "" {
+		http.Error(w, "cross-origin requests are not served", http.StatusForbidden)
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		http.Error(w, "JSON-RPC requests are application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.Handle(body))
+}
+
+// Handle runs the JSON-RPC request in body and returns its response.
+func (s *Server) Handle(body []byte) Response {
+	var req Request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return Response{JSONRPC: Version, Error: &Error{Code: CodeParseError, Message: err.Error()}, ID: json.RawMessage("null")}
+	}
+	resp := Response{JSONRPC: Version, ID: req.ID}
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+	if req.JSONRPC != Version || req.Method == "" {
+		resp.Error = &Error{Code: CodeInvalidRequest, Message: "expected a JSON-RPC 2.0 request with a method"}
+		return resp
+	}
+	method, ok := methods[req.Method]
+	if !ok {
+		resp.Error = &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)}
+		return resp
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result, err := method(s, req.Params)
+	if err != nil {
+		var apiErr *Error
+		if !errors.As(err, &apiErr) {
+			apiErr = &Error{Code: CodeTarget, Message: err.Error(), Data: map[string]string{"method": req.Method}}
+		}
+		resp.Error = apiErr
+		return resp
+	}
+	if result == nil {
+		result = struct{}{}
+	}
+	resp.Result = result
+	return resp
+}
+
+// params decodes the request's params into v, missing params leave v as is.
+func params(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// state reports whether the core is halted and where.
+func (s *Server) state() (State, error) {
+	state, err := s.Core.Status()
+	if err != nil {
+		return State{}, err
+	}
+	st := State{State: state.String(), Halted: state == cortexm.StateHalted}
+	if st.Halted {
+		st.PC, err = s.Core.ReadCoreRegister(cortexm.PC)
+		if err != nil {
+			return State{}, err
+		}
+	}
+	return st, nil
+}
+
+func (s *Server) connect(raw json.RawMessage) (interface{}, error) {
+	id, err := s.Core.ReadCPUID()
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.state()
+	if err != nil {
+		return nil, err
+	}
+	st.CPUID = id.String()
+	return st, nil
+}
+
+func (s *Server) status(raw json.RawMessage) (interface{}, error) {
+	return s.state()
+}
+
+func (s *Server) halt(raw json.RawMessage) (interface{}, error) {
+	err := s.Core.Halt()
+	if err != nil {
+		return nil, err
+	}
+	return s.state()
+}
+
+func (s *Server) resume(raw json.RawMessage) (interface{}, error) {
+	err := s.Core.Resume()
+	if err != nil {
+		return nil, err
+	}
+	return s.state()
+}
+
+func (s *Server) reset(raw json.RawMessage) (interface{}, error) {
+	var p struct {
+		Strategy string `json:"strategy"`
+		Halt     bool   `json:"halt"`
+	}
+	if err := params(raw, &p); err != nil {
+		return nil, err
+	}
+	strategy := s.ResetStrategy
+	if p.Strategy != "" {
+		var err error
+		strategy, err = cortexm.ParseResetStrategy(p.Strategy)
+		if err != nil {
+			return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+		}
+	}
+	err := s.Core.ResetTarget(strategy, p.Halt)
+	if err != nil {
+		return nil, err
+	}
+	return s.state()
+}
+
+func (s *Server) readMemory(raw json.RawMessage) (interface{}, error) {
+	var p struct {
+		Address *uint32 `json:"address"`
+		Length  int     `json:"length"`
+	}
+	if err := params(raw, &p); err != nil {
+		return nil, err
+	}
+	if p.Address == nil || p.Length <= 0 || p.Length > MaxReadLength {
+		return nil, &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("readMemory takes an address and a length from 1 to %d", MaxReadLength)}
+	}
+	b, err := s.Core.ReadMem(*p.Address, p.Length)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"data": hex.EncodeToString(b)}, nil
+}
+
+func (s *Server) writeMemory(raw json.RawMessage) (interface{}, error) {
+	addr, data, err := addressData(raw, "writeMemory")
+	if err != nil {
+		return nil, err
+	}
+	return nil, s.Core.WriteMem(addr, data)
+}
+
+// image is where flash and verify take their data from, a file or an address and hex data.
+type image struct {
+	File    string  `json:"file"`
+	Address *uint32 `json:"address"`
+	Data    string  `json:"data"`
+}
+
+// forPrograms calls fn with every program of the image in raw.  The file's contents stay out of the errors.
+func (s *Server) forPrograms(raw json.RawMessage, method string, fn func(addr uint32, data []byte) error) error {
+	var p image
+	if err := params(raw, &p); err != nil {
+		return err
+	}
+	if p.File == "" {
+		addr, data, err := addressData(raw, method)
+		if err != nil {
+			return err
+		}
+		return fn(addr, data)
+	}
+
+	if s.Dir == "" {
+		return &Error{Code: CodeInvalidParams, Message: method + " takes only an address and hex data on this server"}
+	}
+	reader, err := autoparser.ParseFromPath(filepath.Join(s.Dir, filepath.FromSlash(path.Clean("/"+p.File))), 0)
+	if err != nil {
+		return &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("%s can't open %q as an .elf, .hex or .bin file", method, p.File)}
+	}
+	for {
+		program, err := reader.NextProgram()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("%s can't parse %q", method, p.File)}
+		}
+		err = fn(uint32(program.StartAddr()), program.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// addressData decodes the address and hex data params of method.
+func addressData(raw json.RawMessage, method string) (uint32, []byte, error) {
+	var p image
+	if err := params(raw, &p); err != nil {
+		return 0, nil, err
+	}
+	data, err := hex.DecodeString(p.Data)
+	if err != nil || p.Address == nil || len(data) == 0 {
+		return 0, nil, &Error{Code: CodeInvalidParams, Message: method + " takes an address and hex data"}
+	}
+	return *p.Address, data, nil
+}
+
+// Programmed is the result of flash and verify, the address and length of every program.
+type Programmed struct {
+	Address uint32 `json:"address"`
+	Length  int    `json:"length"`
+}
+
+func (s *Server) flash(raw json.RawMessage) (interface{}, error) {
+	if s.Program == nil {
+		return nil, errors.New("error: this target can't program its flash")
+	}
+	err := s.forPrograms(raw, "flash", s.Program)
+	if err != nil {
+		return nil, err
+	}
+	return s.verify(raw)
+}
+
+func (s *Server) verify(raw json.RawMessage) (interface{}, error) {
+	result := []Programmed{}
+	err := s.forPrograms(raw, "verify", func(addr uint32, data []byte) error {
+		mem, err := s.Core.ReadMem(addr, len(data))
+		if err != nil {
+			return err
+		}
+		for i := range data {
+			if mem[i] != data[i] {
+				return &Error{
+					Code:    CodeVerify,
+					Message: fmt.Sprintf("verify failed at 0x%08x", addr+uint32(i)),
+					Data:    map[string]uint32{"address": addr + uint32(i)},
+				}
+			}
+		}
+		result = append(result, Programmed{Address: addr, Length: len(data)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *Server) measureCycles(raw json.RawMessage) (interface{}, error) {
+	p := struct {
+		Start      *uint32 `json:"start"`
+		End        *uint32 `json:"end"`
+		Iterations int     `json:"iterations"`
+		TimeoutMs  int     `json:"timeoutMs"`
+		ClockHz    float64 `json:"clockHz"`
+	}{Iterations: 1, TimeoutMs: 10000}
+	if err := params(raw, &p); err != nil {
+		return nil, err
+	}
+	if p.Start == nil || p.End == nil || p.Iterations < 1 || p.TimeoutMs < 1 {
+		return nil, &Error{Code: CodeInvalidParams, Message: "measureCycles takes a start and end address, and a positive iterations and timeoutMs"}
+	}
+	counts, err := cycles.Measure(s.Core, *p.Start, *p.End, p.Iterations, time.Duration(p.TimeoutMs)*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+
+	result := struct {
+		Cycles  []uint32     `json:"cycles"`
+		Stats   cycles.Stats `json:"stats"`
+		Seconds []float64    `json:"seconds,omitempty"`
+	}{Cycles: counts, Stats: cycles.Summarize(counts)}
+	if p.ClockHz > 0 {
+		for _, c := range counts {
+			result.Seconds = append(result.Seconds, float64(c)/p.ClockHz)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goocd/core/cortexm"
+	"goocd/core/cortexm4"
+	"goocd/probes/simprobe"
+)
+
+func TestServer(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.Core.Registers[cortexm.PC] = 0x00000400
+	core := cortexm4.New(sim)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "fw.bin"), []byte{1, 2, 3, 4}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		Core:          core,
+		Dir:           dir,
+		ResetStrategy: cortexm.ResetSystem,
+		Program: func(addr uint32, data []byte) error {
+			return core.WriteMem(addr, data)
+		},
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	call := func(request string) (result map[string]interface{}, rpcErr *Error) {
+		t.Helper()
+		resp, err := http.Post(ts.URL, "application/json", strings.NewReader(request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var r struct {
+			Result json.RawMessage `json:"result"`
+			Error  *Error          `json:"error"`
+			ID     json.RawMessage `json:"id"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		if string(r.ID) != "7" && r.Error == nil {
+			t.Fatalf("expected the request's id back, got %s", r.ID)
+		}
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		if len(r.Result) > 0 && r.Result[0] == '{' {
+			if err := json.Unmarshal(r.Result, &result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return result, nil
+	}
+	ok := func(method, params string) map[string]interface{} {
+		t.Helper()
+		result, rpcErr := call(`{"jsonrpc": "2.0", "id": 7, "method": "` + method + `", "params": ` + params + `}`)
+		if rpcErr != nil {
+			t.Fatalf("%s: unexpected error %+v", method, rpcErr)
+		}
+		return result
+	}
+	fails := func(request string, code int) *Error {
+		t.Helper()
+		_, rpcErr := call(request)
+		if rpcErr == nil || rpcErr.Code != code {
+			t.Fatalf("%s: expected error code %d, got %+v", request, code, rpcErr)
+		}
+		return rpcErr
+	}
+
+	if r := ok("halt", "{}"); r["halted"] != true || r["pc"] != float64(0x400) || r["state"] != "halted" {
+		t.Fatalf("unexpected halt result %v", r)
+	}
+	ok("writeMemory", `{"address": 536870912, "data": "efbeadde01"}`)
+	if r := ok("readMemory", `{"address": 536870912, "length": 5}`); r["data"] != "efbeadde01" {
+		t.Fatalf("unexpected readMemory result %v", r)
+	}
+	ok("flash", `{"address": 0, "data": "0102030405060708"}`)
+	ok("writeMemory", `{"address": 5, "data": "00"}`)
+	verifyErr := fails(`{"jsonrpc": "2.0", "id": 7, "method": "verify", "params": {"address": 0, "data": "0102030405060708"}}`, CodeVerify)
+	if data, _ := verifyErr.Data.(map[string]interface{}); data["address"] != float64(5) || len(data) != 1 {
+		t.Fatalf("unexpected verify error data %+v", verifyErr.Data)
+	}
+	ok("flash", `{"file": "../fw.bin"}`)
+	fails(`{"jsonrpc": "2.0", "id": 7, "method": "verify", "params": {"file": "/etc/passwd"}}`, CodeInvalidParams)
+	s.Dir = ""
+	fails(`{"jsonrpc": "2.0", "id": 7, "method": "verify", "params": {"file": "fw.bin"}}`, CodeInvalidParams)
+	fails(`{"jsonrpc": "2.0", "id": 7, "method": "readMemory", "params": {"address": 0, "length": 65537}}`, CodeInvalidParams)
+	if r := ok("resume", "null"); r["halted"] != false || r["state"] != "running" {
+		t.Fatalf("unexpected resume result %v", r)
+	}
+
+	// The core runs to the start and end breakpoints in turn, 480 cycles apart
+	sim.WriteWord(cortexm.FlashPatchCTRLRegister, 2<<cortexm.FlashPatchCTRLNumCodeLowPos)
+	resumes := 0
+	sim.OnResume = func(c *simprobe.Core) {
+		c.Registers[cortexm.PC] = 0x412
+		if resumes%2 == 1 {
+			c.Registers[cortexm.PC] = 0x430
+			sim.WriteWord(cortexm.DataWatchpointCYCCNT, 480)
+		}
+		resumes++
+		c.Halted = true
+	}
+	ok("halt", "{}")
+	r := ok("measureCycles", `{"start": 1042, "end": 1072, "iterations": 2, "clockHz": 48e6}`)
+	if stats, _ := r["stats"].(map[string]interface{}); stats["n"] != float64(2) || stats["mean"] != float64(480) {
+		t.Fatalf("unexpected measureCycles result %v", r)
+	}
+	if seconds, _ := r["seconds"].([]interface{}); len(seconds) != 2 || seconds[0] != 1e-5 {
+		t.Fatalf("unexpected measureCycles result %v", r)
+	}
+	fails(`{"jsonrpc": "2.0", "id": 7, "method": "measureCycles", "params": {"start": 1042}}`, CodeInvalidParams)
+
+	fails(`{"jsonrpc": "2.0", "id": 7, "method": "readMemory", "params": {"length": 4}}`, CodeInvalidParams)
+	fails(`{"jsonrpc": "2.0", "id": 7, "method": "reset", "params": {"strategy": "bogus"}}`, CodeInvalidParams)
+	fails(`{"jsonrpc": "2.0", "id": 7, "method": "bogus"}`, CodeMethodNotFound)
+	fails(`{"id": 7, "method": "halt"}`, CodeInvalidRequest)
+	fails(`{"jsonrpc": "2.0",`, CodeParseError)
+
+	for _, tc := range []struct {
+		contentType, origin string
+		status              int
+	}{
+		{"text/plain", "", http.StatusUnsupportedMediaType},
+		{"application/json", "http://example.com", http.StatusForbidden},
+		{"application/json; charset=utf-8", "", http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(`{"jsonrpc": "2.0", "id": 7, "method": "status"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", tc.contentType)
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("%s from %q: expected status %d, got %d", tc.contentType, tc.origin, tc.status, resp.StatusCode)
+		}
+	}
+}
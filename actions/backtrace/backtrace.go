@@ -0,0 +1,195 @@
+// Package backtrace unwinds the call stack of a halted Cortex-M core using
+// the symbols and unwind information of the firmware's ELF file, the DWARF
+// call frame information of .debug_frame first and the ARM EHABI tables of
+// .ARM.exidx otherwise.  Exception frames are unwound through, so a backtrace
+// taken in a fault handler continues into the code that faulted.
+package backtrace
+
+import (
+	"debug/elf"
+	"fmt"
+	"goocd/core/cortexm"
+	"goocd/fileformats/elfparser"
+	"io"
+)
+
+// MaxFrames bounds a backtrace through a corrupted stack.
+const MaxFrames = 64
+
+// memReader is the memory access the unwinding needs.
+type memReader interface {
+	ReadAddr32(addr uint32, count int) ([]uint32, error)
+}
+
+// Unwinder holds what an ELF file tells about the firmware running on the core.
+type Unwinder struct {
+	Symbols        elfparser.SymbolTable
+	DebugFrame     *DebugFrame     // nil without a .debug_frame section
+	ExceptionIndex *ExceptionIndex // nil without an .ARM.exidx section
+}
+
+// Load reads the symbols and unwind information of the ELF file at path.
+func Load(path string) (*Unwinder, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	u := &Unwinder{}
+	u.Symbols, err = elfparser.ReadSymbols(f)
+	if err != nil {
+		return nil, err
+	}
+	if s := f.Section(".debug_frame"); s != nil {
+		data, err := s.Data()
+		if err != nil {
+			return nil, err
+		}
+		u.DebugFrame, err = ParseDebugFrame(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s := f.Section(".ARM.exidx"); s != nil {
+		exidx, err := s.Data()
+		if err != nil {
+			return nil, err
+		}
+		var extab []byte
+		var extabAddr uint32
+		if t := f.Section(".ARM.extab"); t != nil && t.Type != elf.SHT_NOBITS {
+			if extab, err = t.Data(); err != nil {
+				return nil, err
+			}
+			extabAddr = uint32(t.Addr)
+		}
+		u.ExceptionIndex, err = ParseExceptionIndex(exidx, uint32(s.Addr), extab, extabAddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// Frame is one function on the call stack.
+type Frame struct {
+	PC        uint32
+	SP        uint32
+	Function  string // symbol+offset, empty when no symbol covers PC
+	Exception uint32 // IPSR while the frame ran, 0 in Thread mode
+	// Interrupted is set on the frame an exception was taken from, PC is where it stopped rather than a return address
+	Interrupted *cortexm.ExceptionFrame
+}
+
+// Backtrace unwinds the call stack of a halted core.  Whatever was unwound is returned along with the reason when the
+// unwinding stops before reaching the outermost frame.
+func (u *Unwinder) Backtrace(core cortexm.CoreAccess) ([]Frame, error) {
+	var regs [numRegs]uint32
+	for r := 0; r < numRegs; r++ {
+		v, err := core.ReadCoreRegister(cortexm.R0 + cortexm.Register(r))
+		if err != nil {
+			return nil, err
+		}
+		regs[r] = v
+	}
+	xpsr, err := core.ReadCoreRegister(cortexm.XPSR)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := Frame{PC: regs[regPC], SP: regs[regSP], Exception: xpsr & cortexm.IPSRMask}
+	var frames []Frame
+	for len(frames) < MaxFrames {
+		// A return address is the instruction after the call, which can be past the end of a noreturn caller
+		lookup := frame.PC
+		if len(frames) > 0 && frame.Interrupted == nil {
+			lookup--
+		}
+		frame.Function = u.describe(frame.PC, lookup)
+		frames = append(frames, frame)
+
+		next, end, err := u.step(regs, lookup, len(frames) == 1, core)
+		if err != nil || end {
+			return frames, err
+		}
+
+		frame = Frame{Exception: frame.Exception}
+		if cortexm.IsExcReturn(next[regPC]) {
+			ef, err := cortexm.ReadExceptionFrame(core, next[regPC], next[regSP])
+			if err != nil {
+				return frames, err
+			}
+			next[0], next[1], next[2], next[3] = ef.R0, ef.R1, ef.R2, ef.R3
+			next[12], next[regLR], next[regPC], next[regSP] = ef.R12, ef.LR, ef.PC, ef.CallerSP
+			frame.Exception = ef.XPSR & cortexm.IPSRMask
+			frame.Interrupted = &ef
+		} else {
+			next[regPC] &^= 1
+		}
+		if next[regPC] == 0 || next[regPC] == regs[regPC] && next[regSP] == regs[regSP] {
+			// Reached the reset handler's zeroed LR, or no progress
+			return frames, nil
+		}
+		regs = next
+		frame.PC, frame.SP = regs[regPC], regs[regSP]
+	}
+	return frames, fmt.Errorf("error: Backtrace() stopped after %d frames", MaxFrames)
+}
+
+// step unwinds one frame, the innermost frame may be a leaf function without unwind information.
+func (u *Unwinder) step(regs [numRegs]uint32, pc uint32, innermost bool, mem memReader) ([numRegs]uint32, bool, error) {
+	if u.DebugFrame != nil {
+		if f := u.DebugFrame.find(pc); f != nil {
+			row, err := f.row(pc)
+			if err != nil {
+				return regs, false, err
+			}
+			return row.unwind(regs, f.cie.raReg, mem)
+		}
+	}
+	if u.ExceptionIndex != nil {
+		s, _ := u.Symbols.Lookup(pc)
+		ops, found, err := u.ExceptionIndex.instructions(pc, s.Addr)
+		if err != nil {
+			return regs, false, err
+		}
+		if found {
+			next, err := unwindEHABI(ops, regs, mem)
+			return next, false, err
+		}
+	}
+	if innermost {
+		// Leaf functions don't touch the stack, the return address is still in LR
+		next := regs
+		next[regPC] = regs[regLR]
+		return next, false, nil
+	}
+	return regs, false, fmt.Errorf("error: no unwind information for 0x%08x", pc)
+}
+
+// describe names the function lookup falls in, with the offset of pc into it.
+func (u *Unwinder) describe(pc, lookup uint32) string {
+	s, ok := u.Symbols.Lookup(lookup)
+	if !ok {
+		return ""
+	}
+	if pc == s.Addr {
+		return s.Name
+	}
+	return fmt.Sprintf("%s+0x%x", s.Name, pc-s.Addr)
+}
+
+// Print writes frames gdb style, marking where exceptions were taken.
+func Print(w io.Writer, frames []Frame) {
+	for i, f := range frames {
+		if f.Interrupted != nil {
+			fmt.Fprintf(w, "    <%s exception, frame on %s at 0x%08x>\n", cortexm.ExceptionName(frames[i-1].Exception), f.Interrupted.Stack, f.Interrupted.Addr)
+		}
+		function := f.Function
+		if function == "" {
+			function = "??"
+		}
+		fmt.Fprintf(w, "#%-2d 0x%08x in %s (sp 0x%08x)\n", i, f.PC, function, f.SP)
+	}
+}
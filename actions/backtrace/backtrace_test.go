@@ -0,0 +1,120 @@
+package backtrace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"goocd/core/cortexm"
+	"goocd/probes/simprobe"
+)
+
+func TestUnwinder_Backtrace(t *testing.T) {
+	u, err := Load("../../fileformats/testdata/example1.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Halted in HardFault_Handler, a leaf, which interrupted __libc_init_array after its push {r4, r5, r6, lr}
+	sim := &simprobe.Probe{}
+	sim.Core.Registers[cortexm.PC] = 0x080006D6
+	sim.Core.Registers[cortexm.LR] = 0xFFFFFFF9
+	sim.Core.Registers[cortexm.SP] = 0x20001000
+	sim.Core.Registers[cortexm.MSP] = 0x20001000
+	sim.Core.Registers[cortexm.XPSR] = 3
+	frame := []uint32{0, 0, 0, 0, 0, 0x08000223, 0x0800101C, 0x01000000}
+	for i, v := range frame {
+		sim.WriteWord(0x20001000+uint32(i*4), v)
+	}
+	// r4, r5, r6 and the return address into __aeabi_uldivmod, whose frame saves lr at cfa-12
+	sim.WriteWord(0x2000102C, 0x0800022B)
+	sim.WriteWord(0x20001034, 0)
+	core := cortexm.New(sim)
+	if err := core.Halt(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := u.Backtrace(core)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		pc, sp   uint32
+		function string
+	}{
+		{0x080006D6, 0x20001000, "HardFault_Handler"},
+		{0x0800101C, 0x20001020, "__libc_init_array+0x4"},
+		{0x0800022A, 0x20001030, ""},
+	}
+	if len(frames) != len(want) {
+		t.Fatalf("expected %d frames, got %+v", len(want), frames)
+	}
+	for i, w := range want {
+		f := frames[i]
+		if f.PC != w.pc || f.SP != w.sp || f.Function != w.function {
+			t.Fatalf("frame %d: expected %+v, got %+v", i, w, f)
+		}
+	}
+	if frames[1].Interrupted == nil || frames[1].Interrupted.Stack != cortexm.MSP || frames[0].Exception != 3 || frames[1].Exception != 0 {
+		t.Fatalf("expected frame 1 to be interrupted by the HardFault, got %+v", frames[1])
+	}
+
+	var out bytes.Buffer
+	Print(&out, frames)
+	if !strings.Contains(out.String(), "<HardFault exception, frame on msp at 0x20001000>") {
+		t.Fatalf("expected the exception to be marked, got\n%s", out.String())
+	}
+}
+
+func TestUnwindEHABI(t *testing.T) {
+	sim := &simprobe.Probe{}
+	for i, v := range []uint32{4, 5, 7, 0x08000101} {
+		sim.WriteWord(0x20000010+uint32(i*4), v)
+	}
+	var regs [numRegs]uint32
+	regs[regSP] = 0x20000008
+
+	// add sp, #8; pop {r4, r5, r7, lr}; finish
+	ops, found, err := compactInstructions(0x8001840B, nil)
+	if err != nil || !found {
+		t.Fatal(err)
+	}
+	next, err := unwindEHABI(ops, regs, cortexm.New(sim))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next[4] != 4 || next[5] != 5 || next[7] != 7 || next[regPC] != 0x08000101 || next[regSP] != 0x20000020 {
+		t.Fatalf("unexpected registers %x", next)
+	}
+
+	// pop {r4-r5}, the 10100nnn short form, then finish
+	regs[regSP] = 0x20000010
+	next, err = unwindEHABI([]byte{0xA1, 0xB0}, regs, cortexm.New(sim))
+	if err != nil || next[4] != 4 || next[5] != 5 || next[regSP] != 0x20000018 {
+		t.Fatalf("unexpected registers %x %v", next, err)
+	}
+
+	x, err := ParseExceptionIndex([]byte{0x00, 0x01, 0x00, 0x00, 0x01, 0, 0, 0}, 0x08001098, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := x.instructions(0x08001198, 0); err == nil {
+		t.Fatalf("expected EXIDX_CANTUNWIND to be reported")
+	}
+	if _, found, _ := x.instructions(0x08001300, 0x08001200); found {
+		t.Fatalf("expected the entry of an earlier function to be ignored")
+	}
+}
+
+func TestParseDebugFrame_Corrupt(t *testing.T) {
+	for _, data := range [][]byte{
+		// A length wrapping the end around in 32 bits
+		{0xF8, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+		// An FDE pointing forward to a CIE with such a length
+		{0x0C, 0, 0, 0, 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xF8, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+	} {
+		if _, err := ParseDebugFrame(data); err == nil {
+			t.Fatalf("expected an error for % x", data)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package backtrace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Core register numbers, the same in DWARF and the ARM EHABI
+const (
+	regSP   = 13
+	regLR   = 14
+	regPC   = 15
+	numRegs = 16
+)
+
+// Call frame instructions, DWARF 4 section 6.4.2
+const (
+	cfaAdvanceLoc        = 0x40 // high 2 bits, delta in the low 6
+	cfaOffset            = 0x80 // high 2 bits, register in the low 6
+	cfaRestore           = 0xC0 // high 2 bits, register in the low 6
+	cfaNop               = 0x00
+	cfaSetLoc            = 0x01
+	cfaAdvanceLoc1       = 0x02
+	cfaAdvanceLoc2       = 0x03
+	cfaAdvanceLoc4       = 0x04
+	cfaOffsetExtended    = 0x05
+	cfaRestoreExtended   = 0x06
+	cfaUndefined         = 0x07
+	cfaSameValue         = 0x08
+	cfaRegister          = 0x09
+	cfaRememberState     = 0x0A
+	cfaRestoreState      = 0x0B
+	cfaDefCFA            = 0x0C
+	cfaDefCFARegister    = 0x0D
+	cfaDefCFAOffset      = 0x0E
+	cfaDefCFAExpression  = 0x0F
+	cfaExpression        = 0x10
+	cfaOffsetExtendedSF  = 0x11
+	cfaDefCFASF          = 0x12
+	cfaDefCFAOffsetSF    = 0x13
+	cfaValOffset         = 0x14
+	cfaValOffsetSF       = 0x15
+	cfaValExpression     = 0x16
+	cfaGNUArgsSize       = 0x2E
+	cfaGNUNegOffsetExtSF = 0x2F
+
+	cieID = 0xFFFFFFFF // CIE_id of a CIE in .debug_frame
+)
+
+type ruleKind int
+
+const (
+	ruleSameValue = ruleKind(iota)
+	ruleUndefined
+	ruleOffset    // saved at CFA+offset
+	ruleValOffset // the value is CFA+offset
+	ruleRegister  // saved in another register
+)
+
+type rule struct {
+	kind   ruleKind
+	offset int32
+	reg    uint32
+}
+
+// cfaRow is a row of the call frame table, how to find the CFA and the caller's registers at one address.
+type cfaRow struct {
+	cfaReg    uint32
+	cfaOffset int32
+	regs      [numRegs]rule
+}
+
+type cie struct {
+	codeAlign uint32
+	dataAlign int32
+	raReg     uint32
+	initial   []byte
+}
+
+type fde struct {
+	cie          *cie
+	start        uint32
+	end          uint32
+	instructions []byte
+}
+
+// DebugFrame is the call frame information of a .debug_frame section.
+type DebugFrame struct {
+	fdes []fde // sorted by start
+}
+
+// ParseDebugFrame reads the CIEs and FDEs of a 32-bit little endian .debug_frame section.
+func ParseDebugFrame(data []byte) (*DebugFrame, error) {
+	cies := make(map[uint32]*cie)
+	d := &DebugFrame{}
+	for off := uint32(0); int(off)+4 <= len(data); {
+		length := binary.LittleEndian.Uint32(data[off:])
+		if length == 0xFFFFFFFF {
+			return nil, fmt.Errorf("error: ParseDebugFrame() 64-bit DWARF at 0x%x isn't supported", off)
+		}
+		// In 64 bits, a corrupt length mustn't wrap around
+		if length < 4 || uint64(off)+4+uint64(length) > uint64(len(data)) {
+			return nil, fmt.Errorf("error: ParseDebugFrame() entry at 0x%x overruns the section", off)
+		}
+		end := off + 4 + length
+		entry := data[off+8 : end]
+		id := binary.LittleEndian.Uint32(data[off+4:])
+		if id == cieID {
+			c, err := parseCIE(entry)
+			if err != nil {
+				return nil, fmt.Errorf("error: ParseDebugFrame() CIE at 0x%x: %v", off, err)
+			}
+			cies[off] = c
+		} else {
+			c, ok := cies[id]
+			if !ok {
+				var err error
+				if c, err = parseCIEAt(data, id); err != nil {
+					return nil, fmt.Errorf("error: ParseDebugFrame() FDE at 0x%x: %v", off, err)
+				}
+				cies[id] = c
+			}
+			if len(entry) < 8 {
+				return nil, fmt.Errorf("error: ParseDebugFrame() FDE at 0x%x is truncated", off)
+			}
+			start := binary.LittleEndian.Uint32(entry)
+			d.fdes = append(d.fdes, fde{
+				cie:          c,
+				start:        start,
+				end:          start + binary.LittleEndian.Uint32(entry[4:]),
+				instructions: entry[8:],
+			})
+		}
+		off = end
+	}
+	sort.Slice(d.fdes, func(i, j int) bool { return d.fdes[i].start < d.fdes[j].start })
+	return d, nil
+}
+
+// parseCIEAt parses the CIE an FDE points forward to.
+func parseCIEAt(data []byte, off uint32) (*cie, error) {
+	if int(off)+8 > len(data) || binary.LittleEndian.Uint32(data[off+4:]) != cieID {
+		return nil, fmt.Errorf("no CIE at 0x%x", off)
+	}
+	length := binary.LittleEndian.Uint32(data[off:])
+	if length < 4 || uint64(off)+4+uint64(length) > uint64(len(data)) {
+		return nil, fmt.Errorf("CIE at 0x%x overruns the section", off)
+	}
+	end := off + 4 + length
+	return parseCIE(data[off+8 : end])
+}
+
+func parseCIE(b []byte) (*cie, error) {
+	r := &reader{b: b}
+	version := r.u8()
+	augmentation := r.cstring()
+	if augmentation != "" {
+		return nil, fmt.Errorf("augmentation %q isn't supported", augmentation)
+	}
+	if version >= 4 {
+		r.u8() // address_size
+		r.u8() // segment_selector_size
+	}
+	c := &cie{codeAlign: uint32(r.uleb()), dataAlign: int32(r.sleb())}
+	if version == 1 {
+		c.raReg = uint32(r.u8())
+	} else {
+		c.raReg = uint32(r.uleb())
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	c.initial = r.b[r.off:]
+	return c, nil
+}
+
+// find returns the FDE covering pc, nil if none does.
+func (d *DebugFrame) find(pc uint32) *fde {
+	i := sort.Search(len(d.fdes), func(i int) bool { return d.fdes[i].start > pc })
+	if i == 0 || pc >= d.fdes[i-1].end {
+		return nil
+	}
+	return &d.fdes[i-1]
+}
+
+// row runs the CIE initial instructions and the FDE instructions up to pc.
+func (f *fde) row(pc uint32) (cfaRow, error) {
+	var initial cfaRow
+	err := f.run(&initial, nil, f.cie.initial, ^uint32(0))
+	if err != nil {
+		return initial, err
+	}
+	row := initial
+	err = f.run(&row, &initial, f.instructions, pc)
+	return row, err
+}
+
+// run executes call frame instructions on row until the location passes pc.
+func (f *fde) run(row, initial *cfaRow, ins []byte, pc uint32) error {
+	r := &reader{b: ins}
+	loc := f.start
+	var stack []cfaRow
+	set := func(reg uint32, rl rule) {
+		if reg < numRegs {
+			row.regs[reg] = rl
+		}
+	}
+	restore := func(reg uint32) {
+		if reg < numRegs && initial != nil {
+			row.regs[reg] = initial.regs[reg]
+		}
+	}
+	advance := func(delta uint32) bool {
+		loc += delta * f.cie.codeAlign
+		return loc > pc
+	}
+
+	for r.off < len(r.b) && r.err == nil {
+		op := r.u8()
+		switch op & 0xC0 {
+		case cfaAdvanceLoc:
+			if advance(uint32(op & 0x3F)) {
+				return nil
+			}
+			continue
+		case cfaOffset:
+			set(uint32(op&0x3F), rule{kind: ruleOffset, offset: int32(r.uleb()) * f.cie.dataAlign})
+			continue
+		case cfaRestore:
+			restore(uint32(op & 0x3F))
+			continue
+		}
+
+		switch op {
+		case cfaNop:
+		case cfaSetLoc:
+			loc = r.u32()
+			if loc > pc {
+				return nil
+			}
+		case cfaAdvanceLoc1:
+			if advance(uint32(r.u8())) {
+				return nil
+			}
+		case cfaAdvanceLoc2:
+			if advance(uint32(r.u16())) {
+				return nil
+			}
+		case cfaAdvanceLoc4:
+			if advance(r.u32()) {
+				return nil
+			}
+		case cfaOffsetExtended:
+			reg := uint32(r.uleb())
+			set(reg, rule{kind: ruleOffset, offset: int32(r.uleb()) * f.cie.dataAlign})
+		case cfaOffsetExtendedSF:
+			reg := uint32(r.uleb())
+			set(reg, rule{kind: ruleOffset, offset: int32(r.sleb()) * f.cie.dataAlign})
+		case cfaGNUNegOffsetExtSF:
+			reg := uint32(r.uleb())
+			set(reg, rule{kind: ruleOffset, offset: -int32(r.uleb()) * f.cie.dataAlign})
+		case cfaValOffset:
+			reg := uint32(r.uleb())
+			set(reg, rule{kind: ruleValOffset, offset: int32(r.uleb()) * f.cie.dataAlign})
+		case cfaValOffsetSF:
+			reg := uint32(r.uleb())
+			set(reg, rule{kind: ruleValOffset, offset: int32(r.sleb()) * f.cie.dataAlign})
+		case cfaRestoreExtended:
+			restore(uint32(r.uleb()))
+		case cfaUndefined:
+			set(uint32(r.uleb()), rule{kind: ruleUndefined})
+		case cfaSameValue:
+			set(uint32(r.uleb()), rule{kind: ruleSameValue})
+		case cfaRegister:
+			reg := uint32(r.uleb())
+			set(reg, rule{kind: ruleRegister, reg: uint32(r.uleb())})
+		case cfaRememberState:
+			stack = append(stack, *row)
+		case cfaRestoreState:
+			if len(stack) == 0 {
+				return fmt.Errorf("error: DW_CFA_restore_state without a remembered state")
+			}
+			*row = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		case cfaDefCFA:
+			row.cfaReg = uint32(r.uleb())
+			row.cfaOffset = int32(r.uleb())
+		case cfaDefCFASF:
+			row.cfaReg = uint32(r.uleb())
+			row.cfaOffset = int32(r.sleb()) * f.cie.dataAlign
+		case cfaDefCFARegister:
+			row.cfaReg = uint32(r.uleb())
+		case cfaDefCFAOffset:
+			row.cfaOffset = int32(r.uleb())
+		case cfaDefCFAOffsetSF:
+			row.cfaOffset = int32(r.sleb()) * f.cie.dataAlign
+		case cfaGNUArgsSize:
+			r.uleb()
+		case cfaDefCFAExpression, cfaExpression, cfaValExpression:
+			return fmt.Errorf("error: DWARF expressions in the call frame information at 0x%08x aren't supported", loc)
+		default:
+			return fmt.Errorf("error: unknown call frame instruction 0x%02x at 0x%08x", op, loc)
+		}
+	}
+	return r.err
+}
+
+// unwind computes the caller's registers from regs, end is set when the return address is undefined, the outermost frame.
+func (row cfaRow) unwind(regs [numRegs]uint32, raReg uint32, mem memReader) (next [numRegs]uint32, end bool, err error) {
+	if row.cfaReg >= numRegs {
+		return regs, false, fmt.Errorf("error: CFA based on register %d", row.cfaReg)
+	}
+	cfa := uint32(int32(regs[row.cfaReg]) + row.cfaOffset)
+	next = regs
+	for i, rl := range row.regs {
+		switch rl.kind {
+		case ruleOffset:
+			vals, err := mem.ReadAddr32(uint32(int32(cfa)+rl.offset), 1)
+			if err != nil {
+				return regs, false, err
+			}
+			next[i] = vals[0]
+		case ruleValOffset:
+			next[i] = uint32(int32(cfa) + rl.offset)
+		case ruleRegister:
+			if rl.reg < numRegs {
+				next[i] = regs[rl.reg]
+			}
+		case ruleUndefined:
+			if uint32(i) == raReg {
+				end = true
+			}
+		}
+	}
+	next[regSP] = cfa
+	if raReg < numRegs {
+		next[regPC] = next[raReg]
+	}
+	return next, end, nil
+}
+
+// reader decodes the DWARF encodings, remembering the first overrun.
+type reader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *reader) need(n int) bool {
+	if r.err == nil && r.off+n > len(r.b) {
+		r.err = fmt.Errorf("error: call frame information is truncated")
+	}
+	return r.err == nil
+}
+
+func (r *reader) u8() uint8 {
+	if !r.need(1) {
+		return 0
+	}
+	r.off++
+	return r.b[r.off-1]
+}
+
+func (r *reader) u16() uint16 {
+	if !r.need(2) {
+		return 0
+	}
+	r.off += 2
+	return binary.LittleEndian.Uint16(r.b[r.off-2:])
+}
+
+func (r *reader) u32() uint32 {
+	if !r.need(4) {
+		return 0
+	}
+	r.off += 4
+	return binary.LittleEndian.Uint32(r.b[r.off-4:])
+}
+
+func (r *reader) cstring() string {
+	start := r.off
+	for r.need(1) && r.b[r.off] != 0 {
+		r.off++
+	}
+	s := string(r.b[start:r.off])
+	r.u8()
+	return s
+}
+
+func (r *reader) uleb() uint64 {
+	var v uint64
+	for shift := uint(0); r.need(1); shift += 7 {
+		b := r.u8()
+		v |= uint64(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return v
+}
+
+func (r *reader) sleb() int64 {
+	var v int64
+	shift := uint(0)
+	for r.need(1) {
+		b := r.u8()
+		v |= int64(b&0x7F) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 > 0 {
+				v |= -1 << shift
+			}
+			break
+		}
+	}
+	return v
+}
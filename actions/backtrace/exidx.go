@@ -0,0 +1,252 @@
+package backtrace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// ARM EHABI exception index table entries
+const (
+	exidxCantUnwind      = 0x1
+	exidxCompact         = 0x80000000 // the entry, or the first extab word, holds the unwinding instructions itself
+	exidxPersonalityMask = 0x0F000000
+	exidxPersonalityPos  = 24
+)
+
+// ARM EHABI unwinding instructions, section 10.3
+const (
+	ehabiVSPAdd       = 0x00 // 00xxxxxx: vsp += (x << 2) + 4
+	ehabiVSPSub       = 0x40 // 01xxxxxx: vsp -= (x << 2) + 4
+	ehabiPopMask      = 0x80 // 1000iiii iiiiiiii: pop r4-r15 under mask
+	ehabiSetVSP       = 0x90 // 1001nnnn: vsp = r[n]
+	ehabiPopRange     = 0xA0 // 10100nnn: pop r4-r[4+nnn]
+	ehabiPopRangeLR   = 0xA8 // 10101nnn: pop r4-r[4+nnn], r14
+	ehabiFinish       = 0xB0
+	ehabiPopLowMask   = 0xB1 // 10110001 0000iiii: pop r0-r3 under mask
+	ehabiVSPAddLarge  = 0xB2 // 10110010 uleb128: vsp += 0x204 + (uleb128 << 2)
+	ehabiPopVFPX      = 0xB3 // 10110011 sssscccc: pop d[s]-d[s+c] saved by FSTMFDX
+	ehabiPopVFPXRange = 0xB8 // 10111nnn: pop d8-d[8+nnn] saved by FSTMFDX
+	ehabiPopVFPHigh   = 0xC8 // 11001000 sssscccc: pop d[16+s]-d[16+s+c] saved by VPUSH
+	ehabiPopVFP       = 0xC9 // 11001001 sssscccc: pop d[s]-d[s+c] saved by VPUSH
+	ehabiPopVFPRange  = 0xD0 // 11010nnn: pop d8-d[8+nnn] saved by VPUSH
+)
+
+type exidxEntry struct {
+	fn    uint32 // function start
+	place uint32 // address of the second word, what its prel31 is relative to
+	data  uint32
+}
+
+// ExceptionIndex is the ARM EHABI unwind table of the .ARM.exidx and .ARM.extab sections.
+type ExceptionIndex struct {
+	entries   []exidxEntry // sorted by fn
+	extab     []byte
+	extabAddr uint32
+}
+
+// prel31 resolves a place relative 31-bit offset.
+func prel31(place, value uint32) uint32 {
+	return place + uint32(int32(value<<1)>>1)
+}
+
+// ParseExceptionIndex reads the .ARM.exidx section loaded at exidxAddr, extab is the .ARM.extab section, if any.
+func ParseExceptionIndex(exidx []byte, exidxAddr uint32, extab []byte, extabAddr uint32) (*ExceptionIndex, error) {
+	if len(exidx)%8 != 0 {
+		return nil, fmt.Errorf("error: ParseExceptionIndex() .ARM.exidx size %d isn't a multiple of 8", len(exidx))
+	}
+	x := &ExceptionIndex{extab: extab, extabAddr: extabAddr}
+	for off := 0; off < len(exidx); off += 8 {
+		place := exidxAddr + uint32(off)
+		x.entries = append(x.entries, exidxEntry{
+			fn:    prel31(place, binary.LittleEndian.Uint32(exidx[off:])),
+			place: place + 4,
+			data:  binary.LittleEndian.Uint32(exidx[off+4:]),
+		})
+	}
+	sort.Slice(x.entries, func(i, j int) bool { return x.entries[i].fn < x.entries[j].fn })
+	return x, nil
+}
+
+// instructions returns the unwinding instructions of the function holding pc, found is false when no entry covers it.
+// An entry runs up to the next one, funcStart is where the symbol table says the function starts, so an entry of an
+// earlier function isn't taken for pc's when the functions in between have none.
+func (x *ExceptionIndex) instructions(pc, funcStart uint32) (ops []byte, found bool, err error) {
+	i := sort.Search(len(x.entries), func(i int) bool { return x.entries[i].fn > pc })
+	if i == 0 || x.entries[i-1].fn < funcStart {
+		return nil, false, nil
+	}
+	e := x.entries[i-1]
+	switch {
+	case e.data == exidxCantUnwind:
+		return nil, true, fmt.Errorf("error: the function at 0x%08x is marked as not unwindable", e.fn)
+	case e.data&exidxCompact > 0:
+		return compactInstructions(e.data, nil)
+	}
+
+	addr := prel31(e.place, e.data)
+	off := int(addr - x.extabAddr)
+	if addr < x.extabAddr || off+4 > len(x.extab) {
+		return nil, true, fmt.Errorf("error: .ARM.extab entry 0x%08x of the function at 0x%08x is outside the section", addr, e.fn)
+	}
+	words := x.extab[off:]
+	first := binary.LittleEndian.Uint32(words)
+	if first&exidxCompact > 0 {
+		return compactInstructions(first, words[4:])
+	}
+	// A generic personality routine, GCC's keep their instructions in the __aeabi_unwind_cpp_pr1 layout after it
+	if len(words) < 8 {
+		return nil, true, fmt.Errorf("error: .ARM.extab entry 0x%08x is truncated", addr)
+	}
+	second := binary.LittleEndian.Uint32(words[4:])
+	return wordInstructions(second, 3, int(second>>24), words[8:])
+}
+
+// compactInstructions unpacks the instructions of a compact model entry, more holds the words following it in .ARM.extab.
+func compactInstructions(word uint32, more []byte) ([]byte, bool, error) {
+	switch (word & exidxPersonalityMask) >> exidxPersonalityPos {
+	case 0:
+		// __aeabi_unwind_cpp_pr0, 3 instructions in the entry
+		return wordInstructions(word, 3, 0, nil)
+	case 1, 2:
+		// __aeabi_unwind_cpp_pr1 and pr2, 2 instructions then a count of words
+		return wordInstructions(word, 2, int((word>>16)&0xFF), more)
+	}
+	return nil, true, fmt.Errorf("error: unknown EHABI personality routine in 0x%08x", word)
+}
+
+// wordInstructions unpacks n instruction bytes from word, most significant first, followed by count more words.
+func wordInstructions(word uint32, n, count int, more []byte) ([]byte, bool, error) {
+	if len(more) < count*4 {
+		return nil, true, fmt.Errorf("error: .ARM.extab unwinding instructions are truncated")
+	}
+	var ops []byte
+	for i := n - 1; i >= 0; i-- {
+		ops = append(ops, byte(word>>(8*i)))
+	}
+	for i := 0; i < count; i++ {
+		w := binary.LittleEndian.Uint32(more[i*4:])
+		ops = append(ops, byte(w>>24), byte(w>>16), byte(w>>8), byte(w))
+	}
+	return ops, true, nil
+}
+
+// unwindEHABI runs the unwinding instructions on regs, popping from the stack through mem.
+func unwindEHABI(ops []byte, regs [numRegs]uint32, mem memReader) ([numRegs]uint32, error) {
+	next := regs
+	vsp := regs[regSP]
+	pcSet := false
+	pop := func(mask uint32) error {
+		n := 0
+		for m := mask; m != 0; m &= m - 1 {
+			n++
+		}
+		vals, err := mem.ReadAddr32(vsp, n)
+		if err != nil {
+			return err
+		}
+		spSet := false
+		for reg, i := 0, 0; reg < numRegs; reg++ {
+			if mask&(1<<reg) == 0 {
+				continue
+			}
+			next[reg] = vals[i]
+			i++
+			spSet = spSet || reg == regSP
+			pcSet = pcSet || reg == regPC
+		}
+		vsp += uint32(n * 4)
+		if spSet {
+			vsp = next[regSP]
+		}
+		return nil
+	}
+
+	for i := 0; i < len(ops); i++ {
+		op := ops[i]
+		arg := func() (byte, error) {
+			i++
+			if i >= len(ops) {
+				return 0, fmt.Errorf("error: EHABI instruction 0x%02x is truncated", op)
+			}
+			return ops[i], nil
+		}
+		var err error
+		switch {
+		case op&0xC0 == ehabiVSPAdd:
+			vsp += uint32(op&0x3F)<<2 + 4
+		case op&0xC0 == ehabiVSPSub:
+			vsp -= uint32(op&0x3F)<<2 + 4
+		case op&0xF0 == ehabiPopMask:
+			var low byte
+			if low, err = arg(); err == nil {
+				mask := (uint32(op&0x0F)<<8 | uint32(low)) << 4
+				if mask == 0 {
+					return next, fmt.Errorf("error: EHABI refuse to unwind instruction")
+				}
+				err = pop(mask)
+			}
+		case op&0xF0 == ehabiSetVSP:
+			n := op & 0x0F
+			if n == regSP || n == regPC {
+				return next, fmt.Errorf("error: reserved EHABI instruction 0x%02x", op)
+			}
+			vsp = next[n]
+		case op&0xF8 == ehabiPopRange, op&0xF8 == ehabiPopRangeLR:
+			mask := (uint32(1)<<(op&0x7+1) - 1) << 4
+			if op&0xF8 == ehabiPopRangeLR {
+				mask |= 1 << regLR
+			}
+			err = pop(mask)
+		case op == ehabiFinish:
+			i = len(ops)
+		case op == ehabiPopLowMask:
+			var mask byte
+			if mask, err = arg(); err == nil {
+				if mask == 0 || mask&0xF0 != 0 {
+					return next, fmt.Errorf("error: reserved EHABI instruction 0xb1 0x%02x", mask)
+				}
+				err = pop(uint32(mask))
+			}
+		case op == ehabiVSPAddLarge:
+			var v, shift uint32
+			for {
+				var b byte
+				if b, err = arg(); err != nil {
+					break
+				}
+				v |= uint32(b&0x7F) << shift
+				shift += 7
+				if b&0x80 == 0 {
+					break
+				}
+			}
+			vsp += 0x204 + v<<2
+		case op == ehabiPopVFPX:
+			var sc byte
+			if sc, err = arg(); err == nil {
+				vsp += 8*uint32(sc&0x0F+1) + 4
+			}
+		case op&0xF8 == ehabiPopVFPXRange:
+			vsp += 8*uint32(op&0x7+1) + 4
+		case op == ehabiPopVFPHigh, op == ehabiPopVFP:
+			var sc byte
+			if sc, err = arg(); err == nil {
+				vsp += 8 * uint32(sc&0x0F+1)
+			}
+		case op&0xF8 == ehabiPopVFPRange:
+			vsp += 8 * uint32(op&0x7+1)
+		default:
+			return next, fmt.Errorf("error: unsupported EHABI instruction 0x%02x", op)
+		}
+		if err != nil {
+			return next, err
+		}
+	}
+
+	next[regSP] = vsp
+	if !pcSet {
+		next[regPC] = next[regLR]
+	}
+	return next, nil
+}
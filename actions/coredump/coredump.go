@@ -0,0 +1,194 @@
+// Package coredump writes the state of a halted core as an ELF core file,
+// the core registers in an ARM NT_PRSTATUS note, the FP registers in an
+// NT_ARM_VFP note and each captured memory region as a PT_LOAD segment, the
+// layout arm-none-eabi-gdb reads with 'target core' next to the firmware ELF.
+package coredump
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"goocd/core/cortexm"
+	"goocd/mcus"
+	"io"
+)
+
+// ARM NT_PRSTATUS layout, struct elf_prstatus of the 32-bit ARM ABI
+const (
+	prstatusSize      = 148
+	prstatusCurSigOff = 12
+	prstatusPIDOff    = 24
+	prstatusRegOff    = 72 // 18 words: r0-r15, cpsr and orig_r0
+
+	noteARMVFP  = 0x400 // NT_ARM_VFP, d0-d31 then fpscr
+	vfpNoteSize = 32*8 + 4
+	sigTrap     = 5 // the core stopped for the debugger
+
+	chunkSize = 0x400 // read at once, a chunk that fails is read again a word at a time
+)
+
+// Core is the halted core access a dump needs, the NT_ARM_VFP note is only written when HasFPU reports an FPU.
+type Core interface {
+	ReadMem(addr uint32, length int) ([]byte, error)
+	ReadCoreRegister(reg cortexm.Register) (uint32, error)
+	HasFPU() (bool, error)
+}
+
+// Write captures the registers of a halted core and the memory of regions into w as an ELF core file.  A region is cut
+// short at the first word that can't be read, like the reserved addresses of the SCS or the end of a smaller RAM, and
+// left out when nothing can be read.  The regions are returned as captured.
+func Write(w io.Writer, core Core, regions []mcus.Region) ([]mcus.Region, error) {
+	prstatus := make([]byte, prstatusSize)
+	binary.LittleEndian.PutUint16(prstatus[prstatusCurSigOff:], sigTrap)
+	binary.LittleEndian.PutUint32(prstatus[prstatusPIDOff:], 1)
+	for i := 0; i < 16; i++ {
+		v, err := core.ReadCoreRegister(cortexm.R0 + cortexm.Register(i))
+		if err != nil {
+			return nil, err
+		}
+		binary.LittleEndian.PutUint32(prstatus[prstatusRegOff+i*4:], v)
+	}
+	xpsr, err := core.ReadCoreRegister(cortexm.XPSR)
+	if err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(prstatus[prstatusRegOff+16*4:], xpsr)
+
+	notes := &bytes.Buffer{}
+	writeNote(notes, "CORE", uint32(elf.NT_PRSTATUS), prstatus)
+
+	fpu, err := core.HasFPU()
+	if err != nil {
+		return nil, err
+	}
+	if fpu {
+		// The single precision registers pair up into d0-d15, d16-d31 don't exist on Cortex-M
+		vfp := make([]byte, vfpNoteSize)
+		for i := 0; i < 32; i++ {
+			v, err := core.ReadCoreRegister(cortexm.S0 + cortexm.Register(i))
+			if err != nil {
+				return nil, err
+			}
+			binary.LittleEndian.PutUint32(vfp[i*4:], v)
+		}
+		fpscr, err := core.ReadCoreRegister(cortexm.FPSCR)
+		if err != nil {
+			return nil, err
+		}
+		binary.LittleEndian.PutUint32(vfp[32*8:], fpscr)
+		writeNote(notes, "LINUX", noteARMVFP, vfp)
+	}
+
+	captured := make([]mcus.Region, len(regions))
+	var memory [][]byte
+	for i, r := range regions {
+		m := readRegion(core, r)
+		captured[i] = mcus.Region{Name: r.Name, Start: r.Start, Size: uint32(len(m))}
+		if len(m) > 0 {
+			memory = append(memory, m)
+		}
+	}
+
+	const ehdrSize, phdrSize = 52, 32
+	phnum := 1 + len(memory)
+	header := elf.Header32{
+		Type:      uint16(elf.ET_CORE),
+		Machine:   uint16(elf.EM_ARM),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     ehdrSize,
+		Ehsize:    ehdrSize,
+		Phentsize: phdrSize,
+		Phnum:     uint16(phnum),
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	off := uint32(ehdrSize + phdrSize*phnum)
+	progs := []elf.Prog32{{
+		Type:   uint32(elf.PT_NOTE),
+		Off:    off,
+		Filesz: uint32(notes.Len()),
+		Align:  4,
+	}}
+	off += uint32(notes.Len())
+	for _, r := range captured {
+		if r.Size == 0 {
+			continue
+		}
+		progs = append(progs, elf.Prog32{
+			Type:   uint32(elf.PT_LOAD),
+			Off:    off,
+			Vaddr:  r.Start,
+			Paddr:  r.Start,
+			Filesz: r.Size,
+			Memsz:  r.Size,
+			Flags:  uint32(elf.PF_R | elf.PF_W | elf.PF_X),
+			Align:  4,
+		})
+		off += r.Size
+	}
+
+	err = binary.Write(w, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(w, binary.LittleEndian, progs)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(notes.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range memory {
+		_, err = w.Write(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return captured, nil
+}
+
+// readRegion reads as much of r as core allows, up to the first word that can't be read.
+func readRegion(core Core, r mcus.Region) []byte {
+	var b []byte
+	for off := uint32(0); off < r.Size; off += chunkSize {
+		n := r.Size - off
+		if n > chunkSize {
+			n = chunkSize
+		}
+		chunk, err := core.ReadMem(r.Start+off, int(n))
+		if err == nil {
+			b = append(b, chunk...)
+			continue
+		}
+		for i := uint32(0); i < n; i += 4 {
+			size := n - i
+			if size > 4 {
+				size = 4
+			}
+			word, err := core.ReadMem(r.Start+off+i, int(size))
+			if err != nil {
+				return b
+			}
+			b = append(b, word...)
+		}
+	}
+	return b
+}
+
+// writeNote appends an ELF note, the name and descriptor padded to 4 bytes.
+func writeNote(b *bytes.Buffer, name string, typ uint32, desc []byte) {
+	namesz := len(name) + 1
+	_ = binary.Write(b, binary.LittleEndian, []uint32{uint32(namesz), uint32(len(desc)), typ})
+	b.WriteString(name)
+	b.Write(make([]byte, 1+pad4(namesz)))
+	b.Write(desc)
+	b.Write(make([]byte, pad4(len(desc))))
+}
+
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
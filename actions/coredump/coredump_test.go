@@ -0,0 +1,78 @@
+package coredump
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"goocd/core/cortexm"
+	"goocd/mcus"
+	"goocd/probes/simprobe"
+)
+
+func TestWrite(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.Core.Registers[cortexm.SP] = 0x20000FF0
+	sim.Core.Registers[cortexm.PC] = 0x00000412
+	sim.Core.Registers[cortexm.XPSR] = 0x01000003
+	sim.Core.Registers[cortexm.S0+3] = 0x40490FDB
+	sim.WriteWord(cortexm.MediaAndFPFeatureRegister0, 0x10110021)
+	sim.WriteWord(0x20000000, 0xDEADBEEF)
+	sim.Fault = func(addr uint32) bool {
+		return addr >= 0xE000ED30 && addr < 0xE000ED40 || addr>>28 == 0x3
+	}
+	core := cortexm.New(sim)
+	if err := core.Halt(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	regions := []mcus.Region{{Name: "HSRAM", Start: 0x20000000, Size: 0x100}, {Name: "SCB", Start: 0xE000ED00, Size: 0x40}, {Name: "Reserved", Start: 0x30000000, Size: 0x100}}
+	captured, err := Write(out, core, regions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if captured[0].Size != 0x100 || captured[1].Size != 0x30 || captured[2].Size != 0 {
+		t.Fatalf("expected the SCB cut short at the fault and nothing of the reserved region, got %+v", captured)
+	}
+
+	f, err := elf.NewFile(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Type != elf.ET_CORE || f.Machine != elf.EM_ARM || len(f.Progs) != 3 {
+		t.Fatalf("unexpected core file %+v with %d segments", f.FileHeader, len(f.Progs))
+	}
+
+	notes, err := io.ReadAll(f.Progs[0].Open())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(notes[12:16]) != "CORE" || binary.LittleEndian.Uint32(notes[8:]) != uint32(elf.NT_PRSTATUS) {
+		t.Fatalf("expected the NT_PRSTATUS note first, got % x", notes[:20])
+	}
+	regs := notes[20+prstatusRegOff:]
+	if binary.LittleEndian.Uint32(regs[13*4:]) != 0x20000FF0 || binary.LittleEndian.Uint32(regs[15*4:]) != 0x412 || binary.LittleEndian.Uint32(regs[16*4:]) != 0x01000003 {
+		t.Fatalf("unexpected registers % x", regs[:72])
+	}
+	vfp := notes[20+prstatusSize:]
+	if string(vfp[12:17]) != "LINUX" || binary.LittleEndian.Uint32(vfp[8:]) != noteARMVFP || binary.LittleEndian.Uint32(vfp[20+3*4:]) != 0x40490FDB {
+		t.Fatalf("unexpected NT_ARM_VFP note % x", vfp[:32])
+	}
+
+	for i, want := range []uint32{0xDEADBEEF, 0} {
+		p := f.Progs[i+1]
+		if p.Type != elf.PT_LOAD || p.Vaddr != uint64(captured[i].Start) || p.Filesz != uint64(captured[i].Size) {
+			t.Fatalf("unexpected segment %+v", p.ProgHeader)
+		}
+		data, err := io.ReadAll(p.Open())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if binary.LittleEndian.Uint32(data) != want {
+			t.Fatalf("segment %d: expected 0x%x, got % x", i, want, data[:4])
+		}
+	}
+}
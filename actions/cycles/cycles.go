@@ -0,0 +1,159 @@
+// Package cycles measures a section of code in core clock cycles without
+// changing the firmware.  The DWT cycle counter is zeroed with the core
+// halted on an FPB breakpoint at the start of the section and read when it
+// halts on one at the end, only one breakpoint is armed at a time so the core
+// never has to step off one, and the counter doesn't count while halted.
+// Cycles spent in interrupts taken during the section are counted.
+package cycles
+
+import (
+	"fmt"
+	"goocd/core/cortexm"
+	"io"
+	"math"
+	"time"
+)
+
+// Core is what Measure needs from the core.
+type Core interface {
+	cortexm.MemoryAccess
+	ReadCoreRegister(reg cortexm.Register) (uint32, error)
+	Resume() error
+	WaitForHalt(timeout time.Duration) error
+	DebugFaultStatus() (uint32, error)
+}
+
+// Measure runs the halted core from start to end iterations times and returns the cycles of each run.
+// Each run waits up to timeout for the core to get to start, then as long again to get to end.
+func Measure(core Core, start, end uint32, iterations int, timeout time.Duration) ([]uint32, error) {
+	fpb := &cortexm.FPB{MemoryAccess: core}
+	err := fpb.Configure()
+	if err != nil {
+		return nil, err
+	}
+	defer fpb.ClearAll()
+	dwt := &cortexm.DWT{MemoryAccess: core}
+	err = dwt.Configure()
+	if err != nil {
+		return nil, err
+	}
+	err = dwt.EnableCycleCounter()
+	if err != nil {
+		return nil, err
+	}
+
+	runTo := func(addr uint32) error {
+		err := fpb.SetBreakpoint(addr)
+		if err != nil {
+			return err
+		}
+		// Clear out the halt reasons so a stale one can't pass for the breakpoint
+		_, err = core.DebugFaultStatus()
+		if err != nil {
+			return err
+		}
+		err = core.Resume()
+		if err != nil {
+			return err
+		}
+		err = core.WaitForHalt(timeout)
+		if err != nil {
+			return err
+		}
+		err = fpb.ClearBreakpoint(addr)
+		if err != nil {
+			return err
+		}
+		pc, err := core.ReadCoreRegister(cortexm.PC)
+		if err != nil {
+			return err
+		}
+		if pc != addr {
+			return fmt.Errorf("error: cycles.Measure() expected to halt at 0x%08x, halted at 0x%08x", addr, pc)
+		}
+		return nil
+	}
+
+	var cycles []uint32
+	for i := 0; i < iterations; i++ {
+		err = runTo(start)
+		if err != nil {
+			return cycles, err
+		}
+		err = dwt.ResetCycleCount()
+		if err != nil {
+			return cycles, err
+		}
+		err = runTo(end)
+		if err != nil {
+			return cycles, err
+		}
+		n, err := dwt.CycleCount()
+		if err != nil {
+			return cycles, err
+		}
+		cycles = append(cycles, n)
+	}
+	return cycles, nil
+}
+
+// Stats summarizes the cycles of several runs.
+type Stats struct {
+	N      int     `json:"n"`
+	Min    uint32  `json:"min"`
+	Max    uint32  `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+}
+
+// Summarize computes the statistics of cycles, the standard deviation is the sample one.
+func Summarize(cycles []uint32) Stats {
+	s := Stats{N: len(cycles)}
+	if s.N == 0 {
+		return s
+	}
+	s.Min = cycles[0]
+	sum := 0.0
+	for _, c := range cycles {
+		if c < s.Min {
+			s.Min = c
+		}
+		if c > s.Max {
+			s.Max = c
+		}
+		sum += float64(c)
+	}
+	s.Mean = sum / float64(s.N)
+	if s.N > 1 {
+		squares := 0.0
+		for _, c := range cycles {
+			squares += (float64(c) - s.Mean) * (float64(c) - s.Mean)
+		}
+		s.StdDev = math.Sqrt(squares / float64(s.N-1))
+	}
+	return s
+}
+
+// Duration is how long cycles take at clockHz.
+func Duration(cycles float64, clockHz float64) time.Duration {
+	return time.Duration(cycles / clockHz * float64(time.Second))
+}
+
+// Print writes the cycles of every run and their statistics, along with the times at clockHz unless it's 0.
+func Print(w io.Writer, cycles []uint32, clockHz float64) {
+	at := func(c float64) string {
+		if clockHz == 0 {
+			return ""
+		}
+		return fmt.Sprintf(" (%v)", Duration(c, clockHz))
+	}
+	for i, c := range cycles {
+		fmt.Fprintf(w, "Run %d: %d cycles%s\n", i+1, c, at(float64(c)))
+	}
+	s := Summarize(cycles)
+	if s.N == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%d runs, min %d%s, max %d%s, mean %.1f%s, stddev %.1f%s\n",
+		s.N, s.Min, at(float64(s.Min)), s.Max, at(float64(s.Max)), s.Mean, at(s.Mean), s.StdDev, at(s.StdDev))
+}
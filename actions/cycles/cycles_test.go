@@ -0,0 +1,71 @@
+package cycles
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"goocd/core/cortexm"
+	"goocd/core/cortexm4"
+	"goocd/probes/simprobe"
+)
+
+func TestMeasure(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.WriteWord(cortexm.FlashPatchCTRLRegister, 2<<cortexm.FlashPatchCTRLNumCodeLowPos)
+	sim.Core.Registers[cortexm.PC] = 0x400
+	core := cortexm4.New(sim)
+	if err := core.Halt(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The core runs into whichever breakpoint is armed, taking 100, 101, 102... cycles from start to end
+	resumes := 0
+	sim.OnResume = func(c *simprobe.Core) {
+		if sim.ReadWord(cortexm.FlashPatchComparator0+4) != 0 {
+			t.Errorf("expected a single breakpoint at a time")
+		}
+		if resumes%2 == 0 {
+			c.Registers[cortexm.PC] = 0x412
+		} else {
+			c.Registers[cortexm.PC] = 0x430
+			sim.WriteWord(cortexm.DataWatchpointCYCCNT, sim.ReadWord(cortexm.DataWatchpointCYCCNT)+100+uint32(resumes/2))
+		}
+		resumes++
+		c.Halted = true
+	}
+
+	cycles, err := Measure(core, 0x412, 0x430, 3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cycles) != 3 || cycles[0] != 100 || cycles[1] != 101 || cycles[2] != 102 {
+		t.Fatalf("unexpected cycles %v", cycles)
+	}
+	if sim.ReadWord(cortexm.FlashPatchComparator0) != 0 {
+		t.Fatalf("expected the breakpoints to be cleared")
+	}
+
+	sim.OnResume = func(c *simprobe.Core) {
+		c.Registers[cortexm.PC] = 0x500
+		c.Halted = true
+	}
+	if _, err := Measure(core, 0x412, 0x430, 1, time.Second); err == nil {
+		t.Fatalf("expected halting elsewhere to be an error")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	s := Summarize([]uint32{100, 104, 102, 98})
+	if s.N != 4 || s.Min != 98 || s.Max != 104 || s.Mean != 101 || math.Abs(s.StdDev-2.582) > 0.001 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	out := &bytes.Buffer{}
+	Print(out, []uint32{48, 96}, 48e6)
+	want := "Run 1: 48 cycles (1µs)\nRun 2: 96 cycles (2µs)\n2 runs, min 48 (1µs), max 96 (2µs), mean 72.0 (1.5µs), stddev 33.9 (707ns)\n"
+	if out.String() != want {
+		t.Fatalf("expected %q, got %q", want, out.String())
+	}
+}
@@ -0,0 +1,727 @@
+// Package gdbserver implements the GDB Remote Serial Protocol for a Cortex-M
+// core, enough for arm-none-eabi-gdb to read and write registers and memory,
+// run, step, set hardware breakpoints and watchpoints, and program the flash
+// with load.  Breakpoints of either kind go to the FPB, since the code runs
+// from flash a software breakpoint can't be patched in.
+package gdbserver
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"goocd/core"
+	"goocd/core/cortexm"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PacketSize is the largest packet the server takes, advertised in qSupported.
+const PacketSize = 0x4000
+
+// PollInterval is how often the core is checked while running.
+var PollInterval = 10 * time.Millisecond
+
+// Stop signals of the stop replies
+const (
+	sigInt  = 2
+	sigTrap = 5
+)
+
+// Core is the core access the server drives, all of what the core packages have in common.
+type Core = core.Core
+
+// FlashRegion is a flash bank, gdb erases it in BlockSize units and programs it through the vFlash packets.
+type FlashRegion struct {
+	Start     uint32
+	Size      uint32
+	BlockSize uint32
+}
+
+// Server serves gdb connections to a core.
+type Server struct {
+	Core Core
+	// Flash is the flash layout reported in the memory map, the address space around it is RAM to gdb
+	Flash []FlashRegion
+	// Program writes data to the flash starting at addr, the start of an erased block.  Without it load fails.
+	Program func(addr uint32, data []byte) error
+	// ResetStrategy is what 'monitor reset' uses
+	ResetStrategy cortexm.ResetStrategy
+}
+
+// ListenAndServe accepts gdb connections on addr and serves them one after the other.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		err = s.Serve(conn)
+		_ = conn.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// event is what the reader hands the session: a packet, an interrupt, a negative acknowledge, a packet with a bad
+// checksum or the end of the connection.
+type event struct {
+	packet    string
+	interrupt bool
+	nak       bool
+	corrupt   bool
+	err       error
+}
+
+// session is the state of one gdb connection.
+type session struct {
+	*Server
+	w      io.Writer
+	noAck  bool
+	last   string
+	regs   []register
+	fpb    *cortexm.FPB
+	dwt    *cortexm.DWT
+	watch  map[string]*cortexm.Watchpoint
+	erased []*flashBlock
+	events chan event
+	done   chan struct{}
+}
+
+// flashBlock is an erased range collecting the vFlashWrite data that goes in it.
+type flashBlock struct {
+	addr uint32
+	data []byte
+}
+
+// Serve talks to gdb over conn until it detaches, kills or disconnects.  The core is halted when gdb connects, the
+// breakpoints and watchpoints gdb leaves behind are cleared when it goes.
+func (s *Server) Serve(conn io.ReadWriter) error {
+	ss := &session{
+		Server: s,
+		w:      conn,
+		fpb:    &cortexm.FPB{MemoryAccess: s.Core},
+		dwt:    &cortexm.DWT{MemoryAccess: s.Core},
+		watch:  make(map[string]*cortexm.Watchpoint),
+		events: make(chan event),
+		done:   make(chan struct{}),
+	}
+	defer close(ss.done)
+
+	err := s.Core.Halt()
+	if err != nil {
+		return err
+	}
+	ss.regs, err = registers(s.Core)
+	if err != nil {
+		return err
+	}
+	err = ss.fpb.Configure()
+	if err != nil {
+		return err
+	}
+	err = ss.dwt.Configure()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = ss.fpb.ClearAll()
+		_ = ss.dwt.ClearAll()
+	}()
+
+	go ss.read(bufio.NewReader(conn))
+	for {
+		ev := <-ss.events
+		switch {
+		case ev.err == io.EOF:
+			return nil
+		case ev.err != nil:
+			return ev.err
+		case ss.noAck && (ev.nak || ev.corrupt):
+		case ev.nak:
+			err = ss.send(ss.last)
+		case ev.corrupt:
+			_, err = ss.w.Write([]byte("-"))
+		case ev.interrupt:
+			// Only meaningful while running, resume handles those
+		default:
+			if !ss.noAck {
+				if _, err = ss.w.Write([]byte("+")); err != nil {
+					return err
+				}
+			}
+			var end bool
+			end, err = ss.handle(ev.packet)
+			if end {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// read splits what gdb sends into events until the connection ends or the session is done.
+func (ss *session) read(r *bufio.Reader) {
+	emit := func(ev event) bool {
+		select {
+		case ss.events <- ev:
+			return true
+		case <-ss.done:
+			return false
+		}
+	}
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			emit(event{err: err})
+			return
+		}
+		var ev event
+		switch c {
+		case 0x03:
+			ev.interrupt = true
+		case '-':
+			ev.nak = true
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				emit(event{err: err})
+				return
+			}
+			sum := make([]byte, 2)
+			if _, err = io.ReadFull(r, sum); err != nil {
+				emit(event{err: err})
+				return
+			}
+			data = data[:len(data)-1]
+			want, err := strconv.ParseUint(string(sum), 16, 8)
+			ev.packet = data
+			ev.corrupt = err != nil || byte(want) != checksum(data)
+		default:
+			// Acknowledges and line noise
+			continue
+		}
+		if !emit(ev) {
+			return
+		}
+	}
+}
+
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// send writes a packet, it is kept to resend if gdb asks again.
+func (ss *session) send(data string) error {
+	ss.last = data
+	_, err := fmt.Fprintf(ss.w, "$%s#%02x", data, checksum(data))
+	return err
+}
+
+// errorReply is what failed requests get, gdb only tells errors from success.
+const errorReply = "E01"
+
+// handle answers a packet, end is set once the session is over.
+func (ss *session) handle(packet string) (end bool, err error) {
+	if packet == "" {
+		return false, ss.send("")
+	}
+	switch packet[0] {
+	case '?':
+		return false, ss.send(fmt.Sprintf("S%02x", sigTrap))
+	case 'g':
+		return false, ss.send(ss.readRegisters())
+	case 'G':
+		return false, ss.reply(ss.writeRegisters(packet[1:]))
+	case 'p':
+		n, err := strconv.ParseUint(packet[1:], 16, 32)
+		if err != nil || int(n) >= len(ss.regs) {
+			return false, ss.send(errorReply)
+		}
+		v, err := ss.regs[n].read(ss.Core)
+		if err != nil {
+			return false, ss.send(errorReply)
+		}
+		return false, ss.send(v)
+	case 'P':
+		n, value, ok := strings.Cut(packet[1:], "=")
+		i, err := strconv.ParseUint(n, 16, 32)
+		if !ok || err != nil || int(i) >= len(ss.regs) {
+			return false, ss.send(errorReply)
+		}
+		return false, ss.reply(ss.regs[i].write(ss.Core, value))
+	case 'm':
+		// The hex reply has to fit a packet
+		addr, length, _, err := parseAddrLength(packet[1:])
+		if err != nil || length > (PacketSize-4)/2 {
+			return false, ss.send(errorReply)
+		}
+		b, err := ss.Core.ReadMem(addr, length)
+		if err != nil {
+			return false, ss.send(errorReply)
+		}
+		return false, ss.send(hex.EncodeToString(b))
+	case 'M':
+		addr, length, data, err := parseAddrLength(packet[1:])
+		if err != nil {
+			return false, ss.send(errorReply)
+		}
+		b, err := hex.DecodeString(data)
+		if err != nil || len(b) != length {
+			return false, ss.send(errorReply)
+		}
+		return false, ss.reply(ss.Core.WriteMem(addr, b))
+	case 'X':
+		addr, length, data, err := parseAddrLength(packet[1:])
+		if err != nil {
+			return false, ss.send(errorReply)
+		}
+		b := unescape(data)
+		if len(b) != length {
+			return false, ss.send(errorReply)
+		}
+		return false, ss.reply(ss.Core.WriteMem(addr, b))
+	case 'c', 's':
+		if len(packet) > 1 {
+			addr, err := strconv.ParseUint(packet[1:], 16, 32)
+			if err != nil {
+				return false, ss.send(errorReply)
+			}
+			if err = ss.Core.WriteCoreRegister(cortexm.PC, uint32(addr)); err != nil {
+				return false, ss.send(errorReply)
+			}
+		}
+		if packet[0] == 's' {
+			if err = ss.Core.Step(false); err != nil {
+				return false, ss.send(errorReply)
+			}
+			return false, ss.send(fmt.Sprintf("S%02x", sigTrap))
+		}
+		return ss.resume()
+	case 'Z', 'z':
+		return false, ss.reply(ss.breakpoint(packet[0] == 'Z', packet[1:]))
+	case 'H', 'T':
+		// A single thread
+		return false, ss.send("OK")
+	case 'D':
+		err = ss.send("OK")
+		if err != nil {
+			return true, err
+		}
+		_ = ss.fpb.ClearAll()
+		_ = ss.dwt.ClearAll()
+		return true, ss.Core.Resume()
+	case 'k':
+		return true, nil
+	case 'q', 'Q':
+		return false, ss.query(packet)
+	case 'v':
+		return false, ss.v(packet)
+	}
+	return false, ss.send("")
+}
+
+// reply sends OK, or an error when err is set.
+func (ss *session) reply(err error) error {
+	if err != nil {
+		return ss.send(errorReply)
+	}
+	return ss.send("OK")
+}
+
+// readRegisters answers g, registers that can't be read are reported unavailable.
+func (ss *session) readRegisters() string {
+	var b strings.Builder
+	for _, r := range ss.regs {
+		v, err := r.read(ss.Core)
+		if err != nil {
+			v = strings.Repeat("x", r.bits/4)
+		}
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+// writeRegisters answers G.
+func (ss *session) writeRegisters(values string) error {
+	for _, r := range ss.regs {
+		n := r.bits / 4
+		if len(values) < n {
+			return fmt.Errorf("error: gdbserver G packet is short of register %s", r.name)
+		}
+		err := r.write(ss.Core, values[:n])
+		if err != nil {
+			return err
+		}
+		values = values[n:]
+	}
+	return nil
+}
+
+// resume runs the core until it halts or gdb interrupts it, then sends the stop reply.
+func (ss *session) resume() (bool, error) {
+	// Resuming on a breakpoint would hit it again straight away, step off it first
+	pc, err := ss.Core.ReadCoreRegister(cortexm.PC)
+	if err != nil {
+		return false, ss.send(errorReply)
+	}
+	for _, bp := range ss.fpb.Breakpoints() {
+		if bp != pc {
+			continue
+		}
+		if err = ss.fpb.ClearBreakpoint(bp); err == nil {
+			err = ss.Core.Step(false)
+		}
+		if err == nil {
+			err = ss.fpb.SetBreakpoint(bp)
+		}
+		if err != nil {
+			return false, ss.send(errorReply)
+		}
+	}
+
+	// Only this halt should show up in DFSR
+	if _, err = ss.Core.DebugFaultStatus(); err != nil {
+		return false, ss.send(errorReply)
+	}
+	if err = ss.Core.Resume(); err != nil {
+		return false, ss.send(errorReply)
+	}
+
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-ss.events:
+			if ev.err == io.EOF {
+				return true, nil
+			}
+			if ev.err != nil {
+				return true, ev.err
+			}
+			if ev.interrupt {
+				if err = ss.Core.Halt(); err != nil {
+					return false, ss.send(errorReply)
+				}
+				return false, ss.send(fmt.Sprintf("S%02x", sigInt))
+			}
+		case <-ticker.C:
+			state, err := ss.Core.Status()
+			if err != nil {
+				return false, ss.send(errorReply)
+			}
+			if state == cortexm.StateHalted {
+				return false, ss.send(ss.stopReply())
+			}
+		}
+	}
+}
+
+// stopReply tells gdb why the core halted, naming the address of a watchpoint that fired.
+func (ss *session) stopReply() string {
+	dfsr, err := ss.Core.DebugFaultStatus()
+	if err == nil && dfsr&cortexm.DebugFaultStatusDWTTrap > 0 {
+		fired, err := ss.dwt.Fired()
+		if err == nil && len(fired) > 0 {
+			kind := "awatch"
+			switch fired[0].Kind {
+			case cortexm.WatchWrite:
+				kind = "watch"
+			case cortexm.WatchRead:
+				kind = "rwatch"
+			}
+			return fmt.Sprintf("T%02x%s:%x;", sigTrap, kind, fired[0].Addr)
+		}
+	}
+	return fmt.Sprintf("S%02x", sigTrap)
+}
+
+// breakpoint answers Z and z, type,addr,kind where kind is the instruction or watched data length.
+func (ss *session) breakpoint(insert bool, args string) error {
+	parts := strings.Split(args, ",")
+	if len(parts) < 3 {
+		return fmt.Errorf("error: gdbserver malformed breakpoint %q", args)
+	}
+	addr, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return err
+	}
+	size, err := strconv.ParseUint(strings.SplitN(parts[2], ";", 2)[0], 16, 32)
+	if err != nil {
+		return err
+	}
+
+	var kind cortexm.WatchpointKind
+	switch parts[0] {
+	case "0", "1":
+		if insert {
+			return ss.fpb.SetBreakpoint(uint32(addr))
+		}
+		return ss.fpb.ClearBreakpoint(uint32(addr))
+	case "2":
+		kind = cortexm.WatchWrite
+	case "3":
+		kind = cortexm.WatchRead
+	case "4":
+		kind = cortexm.WatchAccess
+	default:
+		return fmt.Errorf("error: gdbserver unknown breakpoint type %q", parts[0])
+	}
+
+	key := parts[0] + "," + parts[1] + "," + parts[2]
+	if !insert {
+		wp := ss.watch[key]
+		delete(ss.watch, key)
+		if wp == nil {
+			return nil
+		}
+		return ss.dwt.ClearWatchpoint(wp)
+	}
+	if ss.watch[key] != nil {
+		return nil
+	}
+	wp, err := ss.dwt.SetWatchpoint(uint32(addr), uint32(size), kind)
+	if err != nil {
+		return err
+	}
+	ss.watch[key] = wp
+	return nil
+}
+
+// query answers the q and Q packets.
+func (ss *session) query(packet string) error {
+	name, args, _ := strings.Cut(packet, ":")
+	switch {
+	case name == "qSupported":
+		return ss.send(fmt.Sprintf("PacketSize=%x;qXfer:features:read+;qXfer:memory-map:read+;QStartNoAckMode+", PacketSize))
+	case name == "QStartNoAckMode":
+		err := ss.send("OK")
+		ss.noAck = true
+		return err
+	case name == "qAttached":
+		return ss.send("1")
+	case name == "qXfer":
+		object, rest, _ := strings.Cut(args, ":")
+		op, rest, _ := strings.Cut(rest, ":")
+		annex, window, _ := strings.Cut(rest, ":")
+		if op != "read" {
+			return ss.send("")
+		}
+		var doc string
+		switch {
+		case object == "features" && annex == "target.xml":
+			doc = targetXML(ss.regs)
+		case object == "memory-map":
+			doc = memoryMap(ss.Flash)
+		default:
+			return ss.send("")
+		}
+		off, length, _, err := parseAddrLength(window)
+		if err != nil {
+			return ss.send(errorReply)
+		}
+		if int(off) >= len(doc) {
+			return ss.send("l")
+		}
+		end := int(off) + length
+		if end >= len(doc) {
+			return ss.send("l" + escape(doc[off:]))
+		}
+		return ss.send("m" + escape(doc[off:end]))
+	case strings.HasPrefix(packet, "qRcmd,"):
+		cmd, err := hex.DecodeString(packet[len("qRcmd,"):])
+		if err != nil {
+			return ss.send(errorReply)
+		}
+		out, err := ss.monitor(string(cmd))
+		if err != nil {
+			out += err.Error() + "\n"
+		}
+		if out != "" {
+			if err := ss.send("O" + hex.EncodeToString([]byte(out))); err != nil {
+				return err
+			}
+		}
+		return ss.reply(err)
+	}
+	return ss.send("")
+}
+
+// monitor runs a monitor command and returns what it prints.
+func (ss *session) monitor(cmd string) (string, error) {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return "", errors.New("error: monitor commands: reset [halt|run], halt")
+	}
+	switch fields[0] {
+	case "reset":
+		halt := len(fields) > 1 && fields[1] == "halt"
+		if len(fields) > 1 && fields[1] != "halt" && fields[1] != "run" {
+			return "", fmt.Errorf("error: monitor reset takes halt or run, got %q", fields[1])
+		}
+		err := ss.Core.ResetTarget(ss.ResetStrategy, halt)
+		if err != nil {
+			return "", err
+		}
+		if halt {
+			return "Reset, halted at the reset vector\n", nil
+		}
+		return "Reset, running\n", nil
+	case "halt":
+		err := ss.Core.Halt()
+		if err != nil {
+			return "", err
+		}
+		pc, err := ss.Core.ReadCoreRegister(cortexm.PC)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Halted at 0x%08x\n", pc), nil
+	}
+	return "", fmt.Errorf("error: unknown monitor command %q, expected reset [halt|run] or halt", fields[0])
+}
+
+// v answers the v packets, the flash programming of load.
+func (ss *session) v(packet string) error {
+	name, args, _ := strings.Cut(packet, ":")
+	switch name {
+	case "vFlashErase":
+		addr, length, _, err := parseAddrLength(args)
+		if err != nil || !ss.inFlash(addr, length) {
+			return ss.send(errorReply)
+		}
+		data := make([]byte, length)
+		for i := range data {
+			data[i] = 0xFF
+		}
+		ss.erased = append(ss.erased, &flashBlock{addr: addr, data: data})
+		return ss.send("OK")
+	case "vFlashWrite":
+		a, data, ok := strings.Cut(args, ":")
+		addr, err := strconv.ParseUint(a, 16, 32)
+		if !ok || err != nil {
+			return ss.send(errorReply)
+		}
+		b := unescape(data)
+		for _, blk := range ss.erased {
+			if uint32(addr) >= blk.addr && uint64(addr)+uint64(len(b)) <= uint64(blk.addr)+uint64(len(blk.data)) {
+				copy(blk.data[uint32(addr)-blk.addr:], b)
+				return ss.send("OK")
+			}
+		}
+		// Writing where vFlashErase didn't erase
+		return ss.send("E.memtype")
+	case "vFlashDone":
+		erased := ss.erased
+		ss.erased = nil
+		if ss.Program == nil {
+			return ss.send(errorReply)
+		}
+		for _, blk := range erased {
+			err := ss.Program(blk.addr, blk.data)
+			if err != nil {
+				return ss.send(errorReply)
+			}
+		}
+		return ss.send("OK")
+	}
+	// vMustReplyEmpty, vCont? and the rest
+	return ss.send("")
+}
+
+// inFlash reports whether the length bytes at addr are all in one of the flash regions.
+func (ss *session) inFlash(addr uint32, length int) bool {
+	for _, r := range ss.Flash {
+		if addr >= r.Start && uint64(addr)+uint64(length) <= uint64(r.Start)+uint64(r.Size) {
+			return true
+		}
+	}
+	return false
+}
+
+// memoryMap describes flash as flash and the address space around it as RAM, so gdb accesses all of it.
+func memoryMap(flash []FlashRegion) string {
+	regions := append([]FlashRegion{}, flash...)
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Start < regions[j].Start })
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?>` + "\n")
+	b.WriteString(`<!DOCTYPE memory-map PUBLIC "+//IDN gnu.org//DTD GDB Memory Map V1.0//EN" "http://sourceware.org/gdb/gdb-memory-map.dtd">` + "\n")
+	b.WriteString("<memory-map>\n")
+	next := uint64(0)
+	for _, r := range regions {
+		if uint64(r.Start) > next {
+			fmt.Fprintf(&b, "<memory type=\"ram\" start=\"0x%x\" length=\"0x%x\"/>\n", next, uint64(r.Start)-next)
+		}
+		fmt.Fprintf(&b, "<memory type=\"flash\" start=\"0x%x\" length=\"0x%x\"><property name=\"blocksize\">0x%x</property></memory>\n", r.Start, r.Size, r.BlockSize)
+		next = uint64(r.Start) + uint64(r.Size)
+	}
+	if next < 1<<32 {
+		fmt.Fprintf(&b, "<memory type=\"ram\" start=\"0x%x\" length=\"0x%x\"/>\n", next, 1<<32-next)
+	}
+	b.WriteString("</memory-map>\n")
+	return b.String()
+}
+
+// parseAddrLength parses the addr,length[:data] of memory packets.
+func parseAddrLength(s string) (addr uint32, length int, data string, err error) {
+	s, data, _ = strings.Cut(s, ":")
+	a, l, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, "", fmt.Errorf("error: gdbserver expected addr,length, got %q", s)
+	}
+	a64, err := strconv.ParseUint(a, 16, 32)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	l64, err := strconv.ParseUint(l, 16, 32)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	return uint32(a64), int(l64), data, nil
+}
+
+// unescape decodes the binary data of X and vFlashWrite, } escapes the next byte xor 0x20.
+func unescape(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '}' && i+1 < len(s) {
+			i++
+			b = append(b, s[i]^0x20)
+			continue
+		}
+		b = append(b, s[i])
+	}
+	return b
+}
+
+// escape encodes binary data for a reply.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '$', '#', '}', '*':
+			b.WriteByte('}')
+			b.WriteByte(s[i] ^ 0x20)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
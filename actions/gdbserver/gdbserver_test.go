@@ -0,0 +1,222 @@
+package gdbserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"goocd/core/cortexm"
+	"goocd/core/cortexm4"
+	"goocd/probes/simprobe"
+)
+
+// client is a scripted gdb.
+type client struct {
+	t     *testing.T
+	conn  net.Conn
+	r     *bufio.Reader
+	noAck bool
+}
+
+// packet reads the next packet, skipping acknowledges.
+func (c *client) packet() string {
+	c.t.Helper()
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if b != '$' {
+			continue
+		}
+		data, err := c.r.ReadString('#')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		sum := make([]byte, 2)
+		if _, err := c.r.Read(sum); err != nil {
+			c.t.Fatal(err)
+		}
+		data = data[:len(data)-1]
+		if fmt.Sprintf("%02x", checksum(data)) != string(sum) {
+			c.t.Fatalf("bad checksum on %q", data)
+		}
+		if !c.noAck {
+			if _, err := c.conn.Write([]byte("+")); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return data
+	}
+}
+
+// cmd sends a packet and returns the reply.
+func (c *client) cmd(packet string) string {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", packet, checksum(packet)); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.packet()
+}
+
+func TestServer(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.WriteWord(cortexm.FlashPatchCTRLRegister, 2<<cortexm.FlashPatchCTRLNumCodeLowPos)
+	sim.WriteWord(cortexm.DataWatchpointCTRLRegister, 2<<cortexm.DataWatchpointCTRLNumCompPos)
+	sim.Core.Registers[cortexm.PC] = 0x00000400
+
+	var programmed []byte
+	s := &Server{
+		Core:          cortexm4.New(sim),
+		Flash:         []FlashRegion{{Start: 0, Size: 0x100000, BlockSize: 0x2000}},
+		ResetStrategy: cortexm.ResetSystem,
+		Program: func(addr uint32, data []byte) error {
+			if addr != 0 {
+				return fmt.Errorf("unexpected flash address 0x%x", addr)
+			}
+			programmed = data
+			return nil
+		},
+	}
+	server, conn := net.Pipe()
+	defer conn.Close()
+	done := make(chan error)
+	go func() {
+		done <- s.Serve(server)
+		server.Close()
+	}()
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	expect := func(packet, want string) {
+		t.Helper()
+		if got := c.cmd(packet); got != want {
+			t.Fatalf("%s: expected %q, got %q", packet, want, got)
+		}
+	}
+
+	if r := c.cmd("qSupported:multiprocess+;swbreak+"); !strings.Contains(r, "qXfer:features:read+") || !strings.Contains(r, "QStartNoAckMode+") {
+		t.Fatalf("unexpected qSupported reply %q", r)
+	}
+	expect("QStartNoAckMode", "OK")
+	c.noAck = true
+	expect("?", "S05")
+
+	xml := c.cmd("qXfer:features:read:target.xml:0,fff")
+	if !strings.HasPrefix(xml, "l<?xml") || !strings.Contains(xml, featureMProfile) || !strings.Contains(xml, `<reg name="xpsr" bitsize="32" regnum="16"`) {
+		t.Fatalf("unexpected target description %q", xml)
+	}
+	if part := c.cmd("qXfer:features:read:target.xml:0,10"); part != "m<?xml version=\"1" {
+		t.Fatalf("expected the first 16 bytes, got %q", part)
+	}
+	memoryMap := c.cmd("qXfer:memory-map:read::0,fff")
+	if !strings.Contains(memoryMap, `<memory type="flash" start="0x0" length="0x100000"><property name="blocksize">0x2000</property></memory>`) ||
+		!strings.Contains(memoryMap, `<memory type="ram" start="0x100000" length="0xfff00000"/>`) {
+		t.Fatalf("unexpected memory map %q", memoryMap)
+	}
+
+	// Registers
+	expect("Pd=f00f0020", "OK")
+	expect("pd", "f00f0020")
+	regs, err := registers(s.Core)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := c.cmd("g"); len(g) != 8*len(regs) || g[13*8:14*8] != "f00f0020" {
+		t.Fatalf("unexpected g reply %q", g)
+	}
+
+	// Memory, the X data has an escaped }
+	expect("M20000000,4:efbeadde", "OK")
+	expect("m20000000,4", "efbeadde")
+	expect("X20000004,2:a}]", "OK")
+	expect("m20000004,2", "617d")
+	expect("m0,ffffffff", errorReply)
+
+	// Breakpoints and watchpoints
+	expect("Z1,412,2", "OK")
+	if sim.ReadWord(cortexm.FlashPatchComparator0) == 0 {
+		t.Fatalf("expected an FPB comparator to be set")
+	}
+	expect("z1,412,2", "OK")
+	if sim.ReadWord(cortexm.FlashPatchComparator0) != 0 {
+		t.Fatalf("expected the FPB comparator to be cleared")
+	}
+	expect("Z2,20000000,4", "OK")
+	expect("z2,20000000,4", "OK")
+	expect("Z9,0,0", errorReply)
+
+	// Run control
+	expect("s", "S05")
+	if sim.Core.Registers[cortexm.PC] != 0x402 {
+		t.Fatalf("expected a single step, PC is 0x%x", sim.Core.Registers[cortexm.PC])
+	}
+	if _, err := fmt.Fprintf(conn, "$c#%02x", checksum("c")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte{0x03}); err != nil {
+		t.Fatal(err)
+	}
+	if r := c.packet(); r != "S02" || !sim.Core.Halted {
+		t.Fatalf("expected the interrupt to halt the core, got %q", r)
+	}
+
+	// load
+	expect("vFlashErase:0,ffffffff", errorReply)
+	expect("vFlashErase:ffc00,800", errorReply)
+	expect("vFlashErase:0,400", "OK")
+	expect("vFlashWrite:10:\x01\x02}\x03", "OK")
+	expect("vFlashWrite:400:\x01", "E.memtype")
+	expect("vFlashDone", "OK")
+	if len(programmed) != 0x400 || !bytes.Equal(programmed[0xE:0x14], []byte{0xFF, 0xFF, 0x01, 0x02, '#', 0xFF}) {
+		t.Fatalf("unexpected flash image % x", programmed[:0x20])
+	}
+
+	// monitor reset halt
+	if r := c.cmd("qRcmd," + hex.EncodeToString([]byte("reset halt"))); !strings.HasPrefix(r, "O") {
+		t.Fatalf("expected monitor output, got %q", r)
+	}
+	if r := c.packet(); r != "OK" || !sim.Core.Halted {
+		t.Fatalf("expected the reset to halt, got %q", r)
+	}
+
+	expect("D", "OK")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if sim.Core.Halted {
+		t.Fatalf("expected detach to resume the core")
+	}
+}
+
+func TestRegisters_FPU(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.WriteWord(cortexm.MediaAndFPFeatureRegister0, 0x10110021)
+	sim.Core.Registers[cortexm.S0+2] = 0x11223344
+	sim.Core.Registers[cortexm.S0+3] = 0x55667788
+	core := cortexm4.New(sim)
+	if err := core.Halt(); err != nil {
+		t.Fatal(err)
+	}
+
+	regs, err := registers(core)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// r0-pc, xpsr, msp, psp, primask, basepri, faultmask, control, d0-d15 and fpscr
+	if len(regs) != 40 || regs[23].name != "d0" || regs[39].name != "fpscr" || regs[20].feature != featureMSystem {
+		t.Fatalf("unexpected registers %+v", regs)
+	}
+	if v, err := regs[24].read(core); err != nil || v != "4433221188776655" {
+		t.Fatalf("expected d1 made of s2 and s3, got %q %v", v, err)
+	}
+	if err := regs[24].write(core, "0100000002000000"); err != nil || sim.Core.Registers[cortexm.S0+3] != 2 {
+		t.Fatalf("expected d1 to write s2 and s3, got %v", err)
+	}
+	if !strings.Contains(targetXML(regs), `<feature name="org.gnu.gdb.arm.vfp">`+"\n"+`<reg name="d0" bitsize="64" regnum="23" type="ieee_double"/>`) {
+		t.Fatalf("unexpected target description\n%s", targetXML(regs))
+	}
+}
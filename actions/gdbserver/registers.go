@@ -0,0 +1,118 @@
+package gdbserver
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"goocd/core/cortexm"
+	"strings"
+)
+
+// The target description features gdb knows for M-profile cores
+const (
+	featureMProfile = "org.gnu.gdb.arm.m-profile"
+	featureMSystem  = "org.gnu.gdb.arm.m-system"
+	featureVFP      = "org.gnu.gdb.arm.vfp"
+)
+
+// register is one register of the target description, in g packet order.  Its value is made of one or two core
+// registers, least significant first, the VFP double precision registers pair up the single precision ones.
+type register struct {
+	name    string
+	bits    int
+	typ     string
+	feature string
+	regs    []cortexm.Register
+}
+
+// registers lays out the core's registers the way the target description presents them to gdb.
+func registers(core Core) ([]register, error) {
+	coreRegs, err := core.CoreRegisters()
+	if err != nil {
+		return nil, err
+	}
+
+	var regs []register
+	fpu := false
+	for _, r := range coreRegs {
+		switch {
+		case r == cortexm.FPSCR || r >= cortexm.S0 && r < cortexm.S0+32:
+			fpu = true
+			continue
+		case r <= cortexm.XPSR:
+			typ := "uint32"
+			switch r {
+			case cortexm.SP:
+				typ = "data_ptr"
+			case cortexm.LR, cortexm.PC:
+				typ = "code_ptr"
+			}
+			regs = append(regs, register{name: r.String(), bits: 32, typ: typ, feature: featureMProfile, regs: []cortexm.Register{r}})
+		default:
+			regs = append(regs, register{name: r.String(), bits: 32, typ: "uint32", feature: featureMSystem, regs: []cortexm.Register{r}})
+		}
+	}
+	if fpu {
+		for i := cortexm.Register(0); i < 16; i++ {
+			regs = append(regs, register{name: fmt.Sprintf("d%d", i), bits: 64, typ: "ieee_double", feature: featureVFP, regs: []cortexm.Register{cortexm.S0 + 2*i, cortexm.S0 + 2*i + 1}})
+		}
+		regs = append(regs, register{name: "fpscr", bits: 32, typ: "int", feature: featureVFP, regs: []cortexm.Register{cortexm.FPSCR}})
+	}
+	return regs, nil
+}
+
+// targetXML is the target description of regs, gdb numbers the registers in the order they appear.
+func targetXML(regs []register) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?>` + "\n")
+	b.WriteString(`<!DOCTYPE target SYSTEM "gdb-target.dtd">` + "\n")
+	b.WriteString(`<target version="1.0">` + "\n")
+	b.WriteString("<architecture>arm</architecture>\n")
+	feature := ""
+	for i, r := range regs {
+		if r.feature != feature {
+			if feature != "" {
+				b.WriteString("</feature>\n")
+			}
+			feature = r.feature
+			fmt.Fprintf(&b, "<feature name=%q>\n", feature)
+		}
+		fmt.Fprintf(&b, "<reg name=%q bitsize=\"%d\" regnum=\"%d\" type=%q/>\n", r.name, r.bits, i, r.typ)
+	}
+	if feature != "" {
+		b.WriteString("</feature>\n")
+	}
+	b.WriteString("</target>\n")
+	return b.String()
+}
+
+// read returns the value of r as gdb expects it, target byte order in hex.
+func (r register) read(core Core) (string, error) {
+	b := make([]byte, 4*len(r.regs))
+	for i, reg := range r.regs {
+		v, err := core.ReadCoreRegister(reg)
+		if err != nil {
+			return "", err
+		}
+		binary.LittleEndian.PutUint32(b[i*4:], v)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// write sets r from the hex value gdb sent.
+func (r register) write(core Core, value string) error {
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	if len(b) != 4*len(r.regs) {
+		return fmt.Errorf("error: gdbserver register %s takes %d bytes, got %d", r.name, 4*len(r.regs), len(b))
+	}
+	for i, reg := range r.regs {
+		err = core.WriteCoreRegister(reg, binary.LittleEndian.Uint32(b[i*4:]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package profile
+
+import (
+	"compress/gzip"
+	"goocd/fileformats/elfparser"
+	"io"
+	"sort"
+)
+
+// The profile.proto fields WritePprof uses, see github.com/google/pprof/blob/main/proto/profile.proto
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileMapping       = 3
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	mappingID           = 1
+	mappingMemoryStart  = 2
+	mappingMemoryLimit  = 3
+	mappingHasFunctions = 7
+
+	locationID        = 1
+	locationMappingID = 2
+	locationAddress   = 3
+	locationLine      = 4
+
+	lineFunctionID = 1
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+)
+
+// protoBuffer encodes protocol buffer fields, only the varint and length delimited wire types.
+type protoBuffer struct {
+	b []byte
+}
+
+func (p *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		p.b = append(p.b, byte(v)|0x80)
+		v >>= 7
+	}
+	p.b = append(p.b, byte(v))
+}
+
+func (p *protoBuffer) uint64(field int, v uint64) {
+	p.varint(uint64(field) << 3)
+	p.varint(v)
+}
+
+func (p *protoBuffer) bytes(field int, b []byte) {
+	p.varint(uint64(field)<<3 | 2)
+	p.varint(uint64(len(b)))
+	p.b = append(p.b, b...)
+}
+
+// message encodes a nested message built by fn.
+func (p *protoBuffer) message(field int, fn func(m *protoBuffer)) {
+	m := &protoBuffer{}
+	fn(m)
+	p.bytes(field, m.b)
+}
+
+// packed encodes a packed repeated varint field.
+func (p *protoBuffer) packed(field int, vs ...uint64) {
+	m := &protoBuffer{}
+	for _, v := range vs {
+		m.varint(v)
+	}
+	p.bytes(field, m.b)
+}
+
+// WritePprof writes the profile gzipped in the pprof format for 'go tool pprof', one location per sampled PC.
+// Every sample also counts as Duration over the number of samples of cpu time, so pprof can show where the time went.
+func (p *Profile) WritePprof(w io.Writer, symbols elfparser.SymbolTable) error {
+	strs := []string{""}
+	index := map[string]uint64{"": 0}
+	str := func(s string) uint64 {
+		i, ok := index[s]
+		if !ok {
+			i = uint64(len(strs))
+			index[s] = i
+			strs = append(strs, s)
+		}
+		return i
+	}
+	period := uint64(0)
+	if total := p.Total(); total > 0 {
+		period = uint64(p.Duration.Nanoseconds()) / uint64(total)
+	}
+
+	pcs := make([]uint32, 0, len(p.Samples))
+	for pc := range p.Samples {
+		pcs = append(pcs, pc)
+	}
+	sort.Slice(pcs, func(i, j int) bool { return pcs[i] < pcs[j] })
+
+	b := &protoBuffer{}
+	valueType := func(field int, typ, unit string) {
+		b.message(field, func(m *protoBuffer) {
+			m.uint64(valueTypeType, str(typ))
+			m.uint64(valueTypeUnit, str(unit))
+		})
+	}
+	valueType(profileSampleType, "samples", "count")
+	valueType(profileSampleType, "cpu", "nanoseconds")
+	for i, pc := range pcs {
+		n := uint64(p.Samples[pc])
+		b.message(profileSample, func(m *protoBuffer) {
+			m.packed(sampleLocationID, uint64(i+1))
+			m.packed(sampleValue, n, n*period)
+		})
+	}
+	b.message(profileMapping, func(m *protoBuffer) {
+		m.uint64(mappingID, 1)
+		m.uint64(mappingMemoryStart, 0)
+		m.uint64(mappingMemoryLimit, 1<<32)
+		m.uint64(mappingHasFunctions, 1)
+	})
+
+	// Functions are numbered in the order their PCs come up, PCs outside every symbol get no line
+	functions := map[string]uint64{}
+	var names []string
+	for i, pc := range pcs {
+		sym, named := function(symbols, pc)
+		b.message(profileLocation, func(m *protoBuffer) {
+			m.uint64(locationID, uint64(i+1))
+			m.uint64(locationMappingID, 1)
+			m.uint64(locationAddress, uint64(pc))
+			if !named {
+				return
+			}
+			id, ok := functions[sym.Name]
+			if !ok {
+				id = uint64(len(names) + 1)
+				functions[sym.Name] = id
+				names = append(names, sym.Name)
+			}
+			m.message(locationLine, func(l *protoBuffer) {
+				l.uint64(lineFunctionID, id)
+			})
+		})
+	}
+	for i, name := range names {
+		b.message(profileFunction, func(m *protoBuffer) {
+			m.uint64(functionID, uint64(i+1))
+			m.uint64(functionName, str(name))
+			m.uint64(functionSystemName, str(name))
+		})
+	}
+
+	b.uint64(profileTimeNanos, uint64(p.Start.UnixNano()))
+	b.uint64(profileDurationNanos, uint64(p.Duration.Nanoseconds()))
+	valueType(profilePeriodType, "cpu", "nanoseconds")
+	b.uint64(profilePeriod, period)
+	for _, s := range strs {
+		b.bytes(profileStringTable, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	_, err := gz.Write(b.b)
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,129 @@
+// Package profile is a statistical profiler for a running core.  It samples
+// the PC through the DWT Program Counter Sample Register, which reads the
+// address of a recently executed instruction without halting the core, and
+// maps the samples to functions with the ELF symbol table.  The sample rate is
+// whatever the probe manages, one memory read per sample, a few thousand a
+// second over a CMSIS-DAP probe.  SWO PC sample packets would be faster, but
+// the probes here have no SWO capture.
+package profile
+
+import (
+	"context"
+	"fmt"
+	"goocd/core/cortexm"
+	"goocd/fileformats/elfparser"
+	"io"
+	"sort"
+	"time"
+)
+
+// Profile is the PC samples taken over Duration.
+type Profile struct {
+	Samples map[uint32]int // by PC
+	// Unavailable counts the samples PCSR had no PC for, the core halted, in reset or lockup
+	Unavailable int
+	Start       time.Time
+	Duration    time.Duration
+}
+
+// Total is the number of samples with a PC.
+func (p *Profile) Total() int {
+	total := 0
+	for _, n := range p.Samples {
+		total += n
+	}
+	return total
+}
+
+// Sample reads the PC of the running core through DWT PCSR for duration, or until ctx is done.
+func Sample(ctx context.Context, core cortexm.MemoryAccess, duration time.Duration) (*Profile, error) {
+	// PCSR reads as unavailable without DEMCR TRCENA
+	vals, err := core.ReadAddr32(cortexm.DebugExceptionMonitorControlRegister, 1)
+	if err != nil {
+		return nil, err
+	}
+	err = core.WriteAddr32(cortexm.DebugExceptionMonitorControlRegister, vals[0]|cortexm.DebugExceptionMonitorTraceEnable)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Profile{Samples: make(map[uint32]int), Start: time.Now()}
+	for time.Since(p.Start) < duration && ctx.Err() == nil {
+		vals, err := core.ReadAddr32(cortexm.DataWatchpointPCSR, 1)
+		if err != nil {
+			return nil, err
+		}
+		if vals[0] == cortexm.DataWatchpointPCSRUnavailable {
+			p.Unavailable++
+			continue
+		}
+		p.Samples[vals[0]&^1]++
+	}
+	p.Duration = time.Since(p.Start)
+	return p, nil
+}
+
+// Entry is a function of the flat profile, or a lone PC outside every symbol.
+type Entry struct {
+	Name    string
+	Addr    uint32
+	Samples int
+}
+
+// Flat sums the samples by function, the most sampled first.
+func (p *Profile) Flat(symbols elfparser.SymbolTable) []Entry {
+	byName := make(map[string]*Entry)
+	for pc, n := range p.Samples {
+		e := entry(symbols, pc)
+		if byName[e.Name] == nil {
+			byName[e.Name] = &e
+		}
+		byName[e.Name].Samples += n
+	}
+
+	entries := make([]Entry, 0, len(byName))
+	for _, e := range byName {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Samples != entries[j].Samples {
+			return entries[i].Samples > entries[j].Samples
+		}
+		return entries[i].Addr < entries[j].Addr
+	})
+	return entries
+}
+
+// entry is the function pc falls in, or pc itself without one.
+func entry(symbols elfparser.SymbolTable, pc uint32) Entry {
+	if sym, ok := function(symbols, pc); ok {
+		return Entry{Name: sym.Name, Addr: sym.Addr}
+	}
+	return Entry{Name: fmt.Sprintf("0x%08x", pc), Addr: pc}
+}
+
+// function finds the function symbol pc falls in.
+func function(symbols elfparser.SymbolTable, pc uint32) (elfparser.Symbol, bool) {
+	sym, ok := symbols.Lookup(pc)
+	return sym, ok && sym.Func
+}
+
+// Print writes the flat profile the way 'go tool pprof -top' does.
+func (p *Profile) Print(w io.Writer, symbols elfparser.SymbolTable) {
+	total := p.Total()
+	fmt.Fprintf(w, "%d samples over %v", total, p.Duration.Round(time.Millisecond))
+	if p.Unavailable > 0 {
+		fmt.Fprintf(w, ", %d more without a PC (core halted, in reset or lockup)", p.Unavailable)
+	}
+	fmt.Fprintln(w)
+	if total == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "%8s %7s %7s\n", "flat", "flat%", "sum%")
+	sum := 0
+	for _, e := range p.Flat(symbols) {
+		sum += e.Samples
+		fmt.Fprintf(w, "%8d %6.2f%% %6.2f%%  %s\n", e.Samples, 100*float64(e.Samples)/float64(total), 100*float64(sum)/float64(total), e.Name)
+	}
+}
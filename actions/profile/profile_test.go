@@ -0,0 +1,97 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"goocd/core/cortexm"
+	"goocd/core/cortexm4"
+	"goocd/fileformats/elfparser"
+	"goocd/probes/simprobe"
+)
+
+var symbols = elfparser.SymbolTable{
+	{Name: "main", Addr: 0x400, Size: 0x20, Func: true, Global: true},
+	{Name: "busy_loop", Addr: 0x420, Size: 0x10, Func: true, Global: true},
+	{Name: "counter", Addr: 0x20000000, Size: 4, Global: true},
+}
+
+func TestSample(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.WriteWord(cortexm.DataWatchpointPCSR, 0x425)
+	core := cortexm4.New(sim)
+
+	p, err := Sample(context.Background(), core, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if demcr, err := core.ReadAddr32(cortexm.DebugExceptionMonitorControlRegister, 1); err != nil || demcr[0]&cortexm.DebugExceptionMonitorTraceEnable == 0 {
+		t.Fatalf("expected DEMCR TRCENA to be set, got %x %v", demcr, err)
+	}
+	if len(p.Samples) != 1 || p.Samples[0x424] == 0 || p.Unavailable != 0 || p.Duration < 10*time.Millisecond {
+		t.Fatalf("unexpected profile %+v", p)
+	}
+
+	sim.WriteWord(cortexm.DataWatchpointPCSR, cortexm.DataWatchpointPCSRUnavailable)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p, err = Sample(ctx, core, time.Second)
+	if err != nil || p.Total() != 0 {
+		t.Fatalf("expected a cancelled profile to stop, got %+v %v", p, err)
+	}
+}
+
+func TestProfile_Flat(t *testing.T) {
+	p := &Profile{
+		Samples:     map[uint32]int{0x402: 1, 0x410: 2, 0x424: 6, 0x20000000: 1, 0x800: 2},
+		Unavailable: 3,
+		Duration:    time.Second,
+	}
+	entries := p.Flat(symbols)
+	want := []Entry{{"busy_loop", 0x420, 6}, {"main", 0x400, 3}, {"0x00000800", 0x800, 2}, {"0x20000000", 0x20000000, 1}}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %+v, got %+v", want, entries)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Fatalf("expected %+v, got %+v", want, entries)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	p.Print(out, symbols)
+	if !strings.HasPrefix(out.String(), "12 samples over 1s, 3 more without a PC") ||
+		!strings.Contains(out.String(), "       6  50.00%  50.00%  busy_loop\n       3  25.00%  75.00%  main\n") {
+		t.Fatalf("unexpected flat profile\n%s", out.String())
+	}
+}
+
+func TestProfile_WritePprof(t *testing.T) {
+	p := &Profile{Samples: map[uint32]int{0x402: 1, 0x424: 300, 0x800: 2}, Start: time.Unix(1, 0), Duration: time.Second}
+	out := &bytes.Buffer{}
+	if err := p.WritePprof(out, symbols); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The sample of 300 at location 2, a packed location id then packed values, 300 samples of 1s/303 each
+	if !bytes.Contains(b, []byte{0x12, 0x0C, 0x0A, 0x01, 0x02, 0x12, 0x07, 0xAC, 0x02}) {
+		t.Fatalf("expected the busy_loop sample in % x", b)
+	}
+	for _, s := range []string{"samples", "nanoseconds", "main", "busy_loop"} {
+		if !bytes.Contains(b, append([]byte{0x32, byte(len(s))}, s...)) {
+			t.Fatalf("expected %q in the string table of % x", s, b)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Package rtt is a SEGGER Real Time Transfer client.  RTT is a set of ring
+// buffers in target RAM described by the _SEGGER_RTT control block, the
+// firmware writes the up buffers and reads the down buffers while the host
+// does the opposite through plain memory accesses, so the core keeps running.
+package rtt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ID is what the control block starts with, zero padded to IDSize bytes.
+const (
+	ID     = "SEGGER RTT"
+	IDSize = 16
+	// SymbolName is the control block in the firmware's ELF file
+	SymbolName = "_SEGGER_RTT"
+)
+
+// Control block layout, an ID then the buffer counts followed by the up and down buffer descriptors
+const (
+	maxUpOffset     = 16
+	maxDownOffset   = 20
+	headerSize      = 24
+	descriptorSize  = 24
+	descNameOffset  = 0
+	descBufOffset   = 4
+	descSizeOffset  = 8
+	descWrOffOffset = 12
+	descRdOffOffset = 16
+	descFlagsOffset = 20
+
+	// maxBuffers bounds the counts read from what may not be a control block
+	maxBuffers = 32
+	// maxNameLength bounds the names read for the buffers
+	maxNameLength = 32
+)
+
+// Core is the memory access RTT needs, used while the core runs.
+type Core interface {
+	ReadAddr32(addr uint32, count int) ([]uint32, error)
+	WriteAddr32(addr, value uint32) error
+	ReadMem(addr uint32, length int) ([]byte, error)
+	WriteMem(addr uint32, b []byte) error
+}
+
+// Buffer is one ring buffer, Desc is where its descriptor lives in the control block.
+type Buffer struct {
+	Name   string
+	Desc   uint32
+	Buffer uint32
+	Size   uint32
+}
+
+// RTT is a control block found in target RAM.
+type RTT struct {
+	Core Core
+	Addr uint32
+	Up   []Buffer
+	Down []Buffer
+}
+
+// Find scans size bytes of RAM from start for the control block and returns its address.
+func Find(core Core, start, size uint32) (uint32, error) {
+	mem, err := core.ReadMem(start, int(size))
+	if err != nil {
+		return 0, err
+	}
+	id := make([]byte, IDSize)
+	copy(id, ID)
+	i := bytes.Index(mem, id)
+	if i < 0 {
+		return 0, fmt.Errorf("error: rtt.Find() no control block in 0x%08x-0x%08x", start, start+size)
+	}
+	return start + uint32(i), nil
+}
+
+// Open reads the control block at addr.
+func Open(core Core, addr uint32) (*RTT, error) {
+	header, err := core.ReadMem(addr, headerSize)
+	if err != nil {
+		return nil, err
+	}
+	if string(bytes.TrimRight(header[:IDSize], "\x00")) != ID {
+		return nil, fmt.Errorf("error: rtt.Open() no control block at 0x%08x, found %q", addr, header[:IDSize])
+	}
+	up := binary.LittleEndian.Uint32(header[maxUpOffset:])
+	down := binary.LittleEndian.Uint32(header[maxDownOffset:])
+	if up > maxBuffers || down > maxBuffers {
+		return nil, fmt.Errorf("error: rtt.Open() control block at 0x%08x has %d up and %d down buffers", addr, up, down)
+	}
+
+	r := &RTT{Core: core, Addr: addr}
+	desc := addr + headerSize
+	for i := uint32(0); i < up+down; i++ {
+		b, err := r.readDescriptor(desc)
+		if err != nil {
+			return nil, err
+		}
+		if i < up {
+			r.Up = append(r.Up, b)
+		} else {
+			r.Down = append(r.Down, b)
+		}
+		desc += descriptorSize
+	}
+	return r, nil
+}
+
+// readDescriptor reads the buffer descriptor at desc.
+func (r *RTT) readDescriptor(desc uint32) (Buffer, error) {
+	vals, err := r.Core.ReadAddr32(desc, descriptorSize/4)
+	if err != nil {
+		return Buffer{}, err
+	}
+	b := Buffer{
+		Desc:   desc,
+		Buffer: vals[descBufOffset/4],
+		Size:   vals[descSizeOffset/4],
+	}
+	if name := vals[descNameOffset/4]; name != 0 {
+		// Keep to the 64 byte block the name starts in so the read doesn't run off the end of a memory region
+		n, err := r.Core.ReadMem(name, int(min32(maxNameLength, 64-name%64)))
+		if err != nil {
+			return Buffer{}, err
+		}
+		if i := bytes.IndexByte(n, 0); i >= 0 {
+			n = n[:i]
+		}
+		b.Name = string(n)
+	}
+	return b, nil
+}
+
+// Read copies what the target wrote to up buffer channel into p and frees it, returning 0 when there is nothing new.
+func (r *RTT) Read(channel int, p []byte) (int, error) {
+	if channel >= len(r.Up) {
+		return 0, fmt.Errorf("error: rtt.Read() no up buffer %d, the target has %d", channel, len(r.Up))
+	}
+	b := r.Up[channel]
+	offs, err := r.Core.ReadAddr32(b.Desc+descWrOffOffset, 2)
+	if err != nil {
+		return 0, err
+	}
+	wrOff, rdOff := offs[0], offs[1]
+	if wrOff >= b.Size || rdOff >= b.Size {
+		return 0, fmt.Errorf("error: rtt.Read() up buffer %d offsets %d and %d are outside its %d bytes", channel, wrOff, rdOff, b.Size)
+	}
+
+	n := 0
+	for rdOff != wrOff && n < len(p) {
+		// Up to the write offset, or to the end of the buffer when it wrapped
+		end := wrOff
+		if wrOff < rdOff {
+			end = b.Size
+		}
+		chunk := int(end - rdOff)
+		if chunk > len(p)-n {
+			chunk = len(p) - n
+		}
+		data, err := r.Core.ReadMem(b.Buffer+rdOff, chunk)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data)
+		rdOff = (rdOff + uint32(chunk)) % b.Size
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, r.Core.WriteAddr32(b.Desc+descRdOffOffset, rdOff)
+}
+
+// Write copies as much of p into down buffer channel as fits and hands it to the target, returning how much did.
+func (r *RTT) Write(channel int, p []byte) (int, error) {
+	if channel >= len(r.Down) {
+		return 0, fmt.Errorf("error: rtt.Write() no down buffer %d, the target has %d", channel, len(r.Down))
+	}
+	b := r.Down[channel]
+	offs, err := r.Core.ReadAddr32(b.Desc+descWrOffOffset, 2)
+	if err != nil {
+		return 0, err
+	}
+	wrOff, rdOff := offs[0], offs[1]
+	if wrOff >= b.Size || rdOff >= b.Size {
+		return 0, fmt.Errorf("error: rtt.Write() down buffer %d offsets %d and %d are outside its %d bytes", channel, wrOff, rdOff, b.Size)
+	}
+
+	n := 0
+	// One byte stays free so a full buffer can be told from an empty one
+	for (wrOff+1)%b.Size != rdOff && n < len(p) {
+		end := b.Size
+		if rdOff > wrOff {
+			end = rdOff - 1
+		} else if rdOff == 0 {
+			end = b.Size - 1
+		}
+		chunk := int(end - wrOff)
+		if chunk > len(p)-n {
+			chunk = len(p) - n
+		}
+		err = r.Core.WriteMem(b.Buffer+wrOff, p[n:n+chunk])
+		if err != nil {
+			return n, err
+		}
+		n += chunk
+		wrOff = (wrOff + uint32(chunk)) % b.Size
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, r.Core.WriteAddr32(b.Desc+descWrOffOffset, wrOff)
+}
+
+// Stream copies up buffer channel to out and in to down buffer channel, polling every interval until ctx is done.
+// The target may not have a down buffer, in is then ignored.
+func (r *RTT) Stream(ctx context.Context, channel int, in io.Reader, out io.Writer, interval time.Duration) error {
+	input := make(chan []byte)
+	if channel < len(r.Down) {
+		go func() {
+			for {
+				b := make([]byte, 256)
+				n, err := in.Read(b)
+				if n > 0 {
+					select {
+					case input <- b[:n]:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	buf := make([]byte, 4096)
+	var pending []byte
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := r.Read(channel, buf)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(buf[:n])
+		if err != nil {
+			return err
+		}
+
+		if len(pending) > 0 {
+			n, err = r.Write(channel, pending)
+			if err != nil {
+				return err
+			}
+			pending = pending[n:]
+		}
+
+		// Only take more input once the target consumed the last
+		wait := input
+		if len(pending) > 0 {
+			wait = nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case pending = <-wait:
+		case <-ticker.C:
+		}
+	}
+}
+
+func min32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,74 @@
+package rtt
+
+import (
+	"testing"
+
+	"goocd/core/cortexm"
+	"goocd/probes/simprobe"
+)
+
+func TestRTT(t *testing.T) {
+	sim := &simprobe.Probe{}
+	core := cortexm.New(sim)
+	write := func(addr uint32, b []byte) {
+		if err := core.WriteMem(addr, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One up and one down buffer of 8 bytes each, the up buffer wrapped with "abcdef" in it
+	const cb = 0x20000400
+	write(cb, []byte(ID))
+	sim.WriteWord(cb+maxUpOffset, 1)
+	sim.WriteWord(cb+maxDownOffset, 1)
+	write(0x20000100, []byte("Terminal\x00"))
+	for i, v := range []uint32{0x20000100, 0x20000200, 8, 3, 5, 0} {
+		sim.WriteWord(cb+headerSize+uint32(i*4), v)
+	}
+	for i, v := range []uint32{0x20000100, 0x20000300, 8, 6, 2, 0} {
+		sim.WriteWord(cb+headerSize+descriptorSize+uint32(i*4), v)
+	}
+	write(0x20000200, []byte("def45abc"))
+
+	addr, err := Find(core, 0x20000000, 0x1000)
+	if err != nil || addr != cb {
+		t.Fatalf("expected the control block at 0x%x, got 0x%x %v", cb, addr, err)
+	}
+	r, err := Open(core, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Up) != 1 || len(r.Down) != 1 || r.Up[0].Name != "Terminal" || r.Up[0].Size != 8 {
+		t.Fatalf("unexpected buffers %+v %+v", r.Up, r.Down)
+	}
+
+	p := make([]byte, 16)
+	n, err := r.Read(0, p)
+	if err != nil || string(p[:n]) != "abcdef" {
+		t.Fatalf("expected abcdef, got %q %v", p[:n], err)
+	}
+	if sim.ReadWord(cb+headerSize+descRdOffOffset) != 3 {
+		t.Fatalf("expected the read offset to catch up with the write offset")
+	}
+	if n, err = r.Read(0, p); n != 0 || err != nil {
+		t.Fatalf("expected nothing new, got %d %v", n, err)
+	}
+
+	// From offset 6 with the target at 2: 2 bytes to the end, 1 at the start, one stays free
+	down := uint32(cb + headerSize + descriptorSize)
+	n, err = r.Write(0, []byte("xyzw"))
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 bytes to fit, got %d %v", n, err)
+	}
+	b, _ := core.ReadMem(0x20000300, 8)
+	if string(b[6:]) != "xy" || b[0] != 'z' || sim.ReadWord(down+descWrOffOffset) != 1 {
+		t.Fatalf("unexpected down buffer %q, write offset %d", b, sim.ReadWord(down+descWrOffOffset))
+	}
+	if n, err = r.Write(0, []byte("w")); n != 0 || err != nil {
+		t.Fatalf("expected a full buffer, got %d %v", n, err)
+	}
+
+	if _, err := Open(core, 0x20000000); err == nil {
+		t.Fatalf("expected no control block at 0x20000000")
+	}
+}
@@ -3,7 +3,6 @@ package samflash
 import (
 	"encoding/binary"
 	"fmt"
-	"goocd/core/cortexm4"
 	"goocd/protocols/cmsisdap"
 	"time"
 )
@@ -27,17 +26,24 @@ const (
 	NVMCTRL_PARAM_PSZ_1024 = 0x7
 )
 
-//type Cortex interface {
-//	Halt() error
-//	ReadAddr32(addr uint32, count int) (value uint32, err error)
-//	WriteAddr32(addr, value uint32) error
-//	WriteSeqAddr32(addr uint32, value []uint32) error
-//	WriteTransfer32(port, portRegister byte, value uint32) error
-//}
+// Cortex is the core access the NVM Controller is driven through, any of the core packages satisfy it.
+type Cortex interface {
+	Halt() error
+	ReadAddr32(addr uint32, count int) ([]uint32, error)
+	WriteAddr32(addr, value uint32) error
+	WriteSeqAddr32(addr uint32, value []uint32) error
+	ReadMem16(addr uint32, count int) ([]uint16, error)
+	WriteMem16(addr uint32, value uint16) error
+}
+
+// CacheInvalidator is implemented by cores with caches, like cortexm7, which have to drop what they cached of the flash once it is programmed.
+type CacheInvalidator interface {
+	InvalidateCaches() error
+}
 
 type NVMFlash struct {
 	*cmsisdap.CMSISDAP
-	*cortexm4.DAPTransferCoreAccess
+	Cortex
 
 	Stats bool
 
@@ -50,6 +56,10 @@ type NVMFlash struct {
 	WriteAddress             uint32
 	NVMControllerAddress     uint32
 	NVMSetWriteAddressOffset uint32
+	NVMAddressShift          uint32 // 1 for controllers taking a halfword address (SAMD21/SAMC21)
+
+	NVMManualWriteOffSet uint32
+	NVMManualWriteMask   uint32 // 0 if pages are only written on the write command, else set before programming so a full page buffer isn't written on its own
 
 	NVMPARAMOffset   uint32
 	NVMPageSizeMask  uint32
@@ -59,10 +69,9 @@ type NVMFlash struct {
 	NVMPageSizeBits  uint32
 
 	NVMClearReady  bool
-	NVMReadyOffSet uint32
+	NVMReadyOffSet uint32 // the 16 bit STATUS (SAME51/SAML10) or 8 bit INTFLAG (SAMD21/SAMC21) register, accessed as a halfword
 	NVMReadyMask   uint32
 	NVMReadyVal    uint32
-	nvmReadyShift  uint32
 
 	NVMCMDOffSet uint32
 	NVMCMDKey    uint32
@@ -96,15 +105,13 @@ func (nvm *NVMFlash) LoadProgram(rom []byte) error {
 		return err
 	}
 
-	err = nvm.WriteTransfer32(cmsisdap.AccessPort, cmsisdap.PortRegister0, 0x52)
+	err = nvm.ManualWrite()
 	if err != nil {
 		return err
 	}
 
 	buffer := make([]uint32, 0, nvm.WriteSize/4)
 	offset := uint32(0)
-	nvm.nvmReadyShift = (nvm.NVMReadyOffSet % 4) * 8
-	//fmt.Printf("NVMSHIFT: %d\n", nvm.nvmReadyShift)
 
 	if nvm.Stats {
 		fmt.Printf("Total ROM LEN: %d\n", len(rom))
@@ -116,7 +123,7 @@ func (nvm *NVMFlash) LoadProgram(rom []byte) error {
 	for i := 0; i < len(rom); i += 4 {
 		if i%int(nvm.EraseSize) == 0 {
 			//fmt.Printf("Setting New Base: %x\n", nvm.WriteAddress+offset)
-			err = nvm.WriteAddr32(nvm.NVMControllerAddress+nvm.NVMSetWriteAddressOffset, nvm.WriteAddress+offset)
+			err = nvm.WriteAddr32(nvm.NVMControllerAddress+nvm.NVMSetWriteAddressOffset, (nvm.WriteAddress+offset)>>nvm.NVMAddressShift)
 			if err != nil {
 				return err
 			}
@@ -160,6 +167,9 @@ func (nvm *NVMFlash) LoadProgram(rom []byte) error {
 		}
 	}
 
+	if ci, ok := nvm.Cortex.(CacheInvalidator); ok {
+		return ci.InvalidateCaches()
+	}
 	return nil
 }
 
@@ -170,7 +180,7 @@ func (nvm *NVMFlash) ClearRegionLock() error {
 		return err
 	}
 
-	if resp == 0xFFFFFFFF {
+	if resp[0] == 0xFFFFFFFF {
 		return nil
 	}
 
@@ -179,6 +189,18 @@ func (nvm *NVMFlash) ClearRegionLock() error {
 	return nil
 }
 
+// ManualWrite turns off the automatic page write of controllers that have one, the pages are written by Commit.
+func (nvm *NVMFlash) ManualWrite() error {
+	if nvm.NVMManualWriteMask == 0 {
+		return nil
+	}
+	vals, err := nvm.ReadAddr32(nvm.NVMControllerAddress+nvm.NVMManualWriteOffSet, 1)
+	if err != nil {
+		return err
+	}
+	return nvm.WriteAddr32(nvm.NVMControllerAddress+nvm.NVMManualWriteOffSet, vals[0]|nvm.NVMManualWriteMask)
+}
+
 func (nvm *NVMFlash) Commit() error {
 	//fmt.Printf("Issuing Write Command: %x\n", (nvm.NVMCMDKey<<nvm.NVMCMDKeyPos)|nvm.NVMWriteCMD)
 	err := nvm.WriteAddr32(nvm.NVMControllerAddress+nvm.NVMCMDOffSet, (nvm.NVMCMDKey<<nvm.NVMCMDKeyPos)|nvm.NVMWriteCMD)
@@ -208,13 +230,14 @@ func (nvm *NVMFlash) Erase() error {
 func (nvm *NVMFlash) WaitForReady() error {
 	ti := time.Now()
 	for {
-		// Read Flag
-		val, err := nvm.ReadAddr32(nvm.NVMControllerAddress+nvm.NVMReadyOffSet, 1)
+		// Read Flag.  An 8 bit INTFLAG is the low byte of an aligned halfword whose high byte is reserved and reads 0,
+		// so the halfword read is safe there too
+		vals, err := nvm.ReadMem16(nvm.NVMControllerAddress+nvm.NVMReadyOffSet, 1)
 		if err != nil {
 			return err
 		}
 
-		val = val >> nvm.nvmReadyShift
+		val := uint32(vals[0])
 		//fmt.Printf("ValAP: %x\n", val)
 		// Bitwise Flag check
 		if val&nvm.NVMReadyMask > 0 {
@@ -229,8 +252,9 @@ func (nvm *NVMFlash) WaitForReady() error {
 
 	// Todo: See if this is even needed since this isn't the interrupt register
 	if nvm.NVMClearReady {
-		// Clear Interrupt
-		err := nvm.WriteAddr32(nvm.NVMControllerAddress+nvm.NVMReadyOffSet, nvm.NVMReadyVal)
+		// Clear Interrupt.  Writing the halfword also writes the reserved byte after an 8 bit INTFLAG, only 0 which
+		// the datasheet asks for, and no SAMD21/SAMC21 target sets NVMClearReady anyway
+		err := nvm.WriteMem16(nvm.NVMControllerAddress+nvm.NVMReadyOffSet, uint16(nvm.NVMReadyVal))
 		if err != nil {
 			return err
 		}
@@ -238,11 +262,11 @@ func (nvm *NVMFlash) WaitForReady() error {
 		ti = time.Now()
 		for {
 			// Wait For interrupt to be cleared after you cleared it
-			val, err := nvm.ReadAddr32(nvm.NVMControllerAddress+nvm.NVMReadyOffSet, 1)
+			vals, err := nvm.ReadMem16(nvm.NVMControllerAddress+nvm.NVMReadyOffSet, 1)
 			if err != nil {
 				return err
 			}
-			val = val >> nvm.nvmReadyShift
+			val := uint32(vals[0])
 			//fmt.Printf("ValAP: %x\n", val)
 			if val&nvm.NVMReadyMask == 0 {
 				break
@@ -257,10 +281,11 @@ func (nvm *NVMFlash) WaitForReady() error {
 }
 
 func (nvm *NVMFlash) Configure() error {
-	readVal, err := nvm.ReadAddr32(nvm.NVMControllerAddress+nvm.NVMPARAMOffset, 1)
+	readVals, err := nvm.ReadAddr32(nvm.NVMControllerAddress+nvm.NVMPARAMOffset, 1)
 	if err != nil {
 		return err
 	}
+	readVal := readVals[0]
 
 	nvm.PageCount = (readVal & nvm.NVMPageCountMask) >> nvm.NVMPageCountPos
 	nvm.NVMPageSizeBits = (readVal & nvm.NVMPageSizeMask) >> nvm.NVMPageSizePos
@@ -0,0 +1,132 @@
+package samflash
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"goocd/core/cortexm0"
+	"goocd/core/cortexm7"
+	"goocd/mcus/sam/atsamd21g18a"
+	"goocd/probes/simprobe"
+)
+
+func TestNVMFlash_LoadProgramSAMD21(t *testing.T) {
+	const ctrl = atsamd21g18a.NVMCTRL_Addr
+	sim := &simprobe.Probe{}
+	var erased, written []uint32
+	sim.OnWrite = samd21NVMController(sim, &erased, &written)
+
+	rom := make([]byte, 300)
+	for i := range rom {
+		rom[i] = byte(i)
+	}
+	nvm := samd21NVMFlash(cortexm0.New(sim))
+	if err := nvm.LoadProgram(rom); err != nil {
+		t.Fatal(err)
+	}
+
+	if nvm.WriteSize != 64 || nvm.EraseSize != 256 || nvm.FlashSize != 0x40000 {
+		t.Fatalf("unexpected geometry, page %d row %d flash %d", nvm.WriteSize, nvm.EraseSize, nvm.FlashSize)
+	}
+	if sim.ReadWord(ctrl+atsamd21g18a.NVMCTRL_CTRLB_Offset)&atsamd21g18a.NVMCTRL_CTRLB_MANW == 0 {
+		t.Fatalf("expected manual page writes to be enabled")
+	}
+	if len(erased) != 2 || erased[0] != 0 || erased[1] != 0x100 {
+		t.Fatalf("expected rows 0x0 and 0x100 to be erased, got %x", erased)
+	}
+	if len(written) != 5 {
+		t.Fatalf("expected 5 page writes, got %d", len(written))
+	}
+	padded := append(append([]byte{}, rom...), make([]byte, 64-len(rom)%64)...)
+	for i := 0; i < len(padded); i += 4 {
+		want := binary.LittleEndian.Uint32(padded[i:])
+		if got := sim.ReadWord(uint32(i)); got != want {
+			t.Fatalf("word at 0x%x: expected 0x%x, got 0x%x", i, want, got)
+		}
+	}
+}
+
+func TestNVMFlash_LoadProgramCortexM7(t *testing.T) {
+	sim := &simprobe.Probe{}
+	var erased, written []uint32
+	nvmController := samd21NVMController(sim, &erased, &written)
+
+	// A 16KB 4 way data cache and instruction cache, both enabled, invalidated once the last page is written
+	sim.WriteWord(cortexm7.CacheLevelIDRegister, cortexm7.CacheLevelIDCType1ICache|cortexm7.CacheLevelIDCType1DCache)
+	sim.WriteWord(cortexm7.ConfigurationControlRegister, cortexm7.ConfigurationControlDCache|cortexm7.ConfigurationControlICache)
+	sim.WriteWord(cortexm7.CacheSizeIDRegister, 1|3<<cortexm7.CacheSizeIDAssociativityPos|127<<cortexm7.CacheSizeIDNumSetsPos)
+	var setWay, iciallu int
+	sim.OnWrite = func(addr, value uint32) {
+		nvmController(addr, value)
+		switch addr {
+		case cortexm7.DCacheCleanInvalidateSetWay:
+			setWay++
+		case cortexm7.ICacheInvalidateAll:
+			iciallu++
+		case atsamd21g18a.NVMCTRL_Addr + atsamd21g18a.NVMCTRL_CTRLA_Offset:
+			setWay, iciallu = 0, 0
+		}
+	}
+
+	core := cortexm7.New(sim)
+	if err := core.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	nvm := samd21NVMFlash(core)
+	if err := nvm.LoadProgram(make([]byte, 64)); err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 {
+		t.Fatalf("expected 1 page write, got %d", len(written))
+	}
+	if setWay != 512 || iciallu != 1 {
+		t.Fatalf("expected the caches to be invalidated after the last page write, got %d set/way operations and %d ICIALLU", setWay, iciallu)
+	}
+}
+
+// samd21NVMController models the NVM Controller of a SAMD21 on sim, which takes halfword addresses, recording the rows
+// erased and the pages written.  The returned function handles the writes to it, for sim.OnWrite.
+func samd21NVMController(sim *simprobe.Probe, erased, written *[]uint32) func(addr, value uint32) {
+	const ctrl = atsamd21g18a.NVMCTRL_Addr
+	sim.WriteWord(ctrl+atsamd21g18a.NVMCTRL_PARAM_Offset, 0x3<<atsamd21g18a.NVMCTRL_PARAM_PSZ_Pos|4096) // 4096 pages of 64 bytes
+	sim.WriteWord(ctrl+atsamd21g18a.NVMCTRL_INTFLAG_Offset, atsamd21g18a.NVMCTRL_INTFLAG_READY)
+	return func(addr, value uint32) {
+		if addr != ctrl+atsamd21g18a.NVMCTRL_CTRLA_Offset || value>>atsamd21g18a.NVMCTRL_CTRLA_CMDEX_Pos&0xFF != atsamd21g18a.NVMCTRL_CTRLA_CMDEX_KEY {
+			return
+		}
+		rowAddr := sim.ReadWord(ctrl+atsamd21g18a.NVMCTRL_ADDR_Offset) << 1
+		switch value & atsamd21g18a.NVMCTRL_CTRLA_CMD_Msk {
+		case atsamd21g18a.NVMCTRL_CTRLA_CMD_ER:
+			*erased = append(*erased, rowAddr)
+		case atsamd21g18a.NVMCTRL_CTRLA_CMD_WP:
+			*written = append(*written, rowAddr)
+		}
+	}
+}
+
+// samd21NVMFlash is the SAMD21 NVM Controller driven through core.
+func samd21NVMFlash(core Cortex) *NVMFlash {
+	const ctrl = atsamd21g18a.NVMCTRL_Addr
+	return &NVMFlash{
+		Cortex:                   core,
+		WriteAddress:             0,
+		EraseMultiplyer:          4,
+		NVMControllerAddress:     ctrl,
+		NVMSetWriteAddressOffset: atsamd21g18a.NVMCTRL_ADDR_Offset,
+		NVMAddressShift:          1,
+		NVMManualWriteOffSet:     atsamd21g18a.NVMCTRL_CTRLB_Offset,
+		NVMManualWriteMask:       atsamd21g18a.NVMCTRL_CTRLB_MANW,
+		NVMPARAMOffset:           atsamd21g18a.NVMCTRL_PARAM_Offset,
+		NVMPageSizeMask:          atsamd21g18a.NVMCTRL_PARAM_PSZ_Msk,
+		NVMPageSizePos:           atsamd21g18a.NVMCTRL_PARAM_PSZ_Pos,
+		NVMPageCountMask:         atsamd21g18a.NVMCTRL_PARAM_NVMP_Msk,
+		NVMPageCountPos:          atsamd21g18a.NVMCTRL_PARAM_NVMP_Pos,
+		NVMReadyOffSet:           atsamd21g18a.NVMCTRL_INTFLAG_Offset,
+		NVMReadyMask:             atsamd21g18a.NVMCTRL_INTFLAG_READY_Msk,
+		NVMCMDOffSet:             atsamd21g18a.NVMCTRL_CTRLA_Offset,
+		NVMCMDKey:                atsamd21g18a.NVMCTRL_CTRLA_CMDEX_KEY,
+		NVMCMDKeyPos:             atsamd21g18a.NVMCTRL_CTRLA_CMDEX_Pos,
+		NVMEraseCMD:              atsamd21g18a.NVMCTRL_CTRLA_CMD_ER,
+		NVMWriteCMD:              atsamd21g18a.NVMCTRL_CTRLA_CMD_WP,
+	}
+}
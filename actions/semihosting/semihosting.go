@@ -0,0 +1,377 @@
+// Package semihosting services the ARM semihosting calls firmware makes with
+// BKPT 0xAB, the operation in R0 and its parameter block in R1.  The console
+// goes to the host's stdin and stdout, files are opened inside a sandbox
+// directory and the application's exit status is handed back to the caller,
+// enough for unit test firmware to printf and report pass or fail.
+package semihosting
+
+import (
+	"fmt"
+	"goocd/core/cortexm"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Semihosting operations, from ARM's "Semihosting for AArch32 and AArch64"
+const (
+	SysOpen         = 0x01
+	SysClose        = 0x02
+	SysWriteC       = 0x03
+	SysWrite0       = 0x04
+	SysWrite        = 0x05
+	SysRead         = 0x06
+	SysClock        = 0x10
+	SysTime         = 0x11
+	SysExit         = 0x18
+	SysExitExtended = 0x20
+)
+
+const (
+	// BKPTInstruction is the Thumb encoding of BKPT 0xAB
+	BKPTInstruction = 0xBEAB
+	// ADPStoppedApplicationExit is the SYS_EXIT reason of a normal exit
+	ADPStoppedApplicationExit = 0x20026
+	// ConsoleName is the file name opening the console, read modes give stdin, write modes stdout and append modes stderr
+	ConsoleName = ":tt"
+
+	failed = 0xFFFFFFFF // -1, the result of a failed operation
+
+	// chunkSize bounds the host buffer of one transfer, the target asks for as many bytes as it likes
+	chunkSize = 0x1000
+)
+
+// openFlags maps the SYS_OPEN modes, fopen's r, rb, r+, r+b, w, wb, w+, w+b, a, ab, a+ and a+b, to os.OpenFile flags.
+var openFlags = [...]int{
+	os.O_RDONLY, os.O_RDONLY,
+	os.O_RDWR, os.O_RDWR,
+	os.O_WRONLY | os.O_CREATE | os.O_TRUNC, os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+	os.O_RDWR | os.O_CREATE | os.O_TRUNC, os.O_RDWR | os.O_CREATE | os.O_TRUNC,
+	os.O_WRONLY | os.O_CREATE | os.O_APPEND, os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+	os.O_RDWR | os.O_CREATE | os.O_APPEND, os.O_RDWR | os.O_CREATE | os.O_APPEND,
+}
+
+// Core is the access to a halted core servicing a call needs: r0 and r1 hold the operation and its parameter block, r0 gets the result.
+type Core interface {
+	ReadAddr32(addr uint32, count int) ([]uint32, error)
+	ReadMem(addr uint32, length int) ([]byte, error)
+	ReadMem16(addr uint32, count int) ([]uint16, error)
+	WriteMem(addr uint32, b []byte) error
+	ReadCoreRegister(reg cortexm.Register) (uint32, error)
+	WriteCoreRegister(reg cortexm.Register, value uint32) error
+	Resume() error
+	WaitForHalt(timeout time.Duration) error
+	DebugFaultStatus() (uint32, error)
+}
+
+// handle is an open file of the target, the console handles have no closer.
+type handle struct {
+	r io.Reader
+	w io.Writer
+	c io.Closer
+}
+
+// Host is the host side of semihosting.
+type Host struct {
+	// Dir is the directory SYS_OPEN opens files in, names can't climb out of it
+	Dir    string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Exited is set once the application made a SYS_EXIT or SYS_EXIT_EXTENDED call, with its status in ExitCode
+	Exited   bool
+	ExitCode int
+
+	files map[uint32]*handle
+	next  uint32
+	start time.Time
+}
+
+// NewHost returns a Host opening files in dir, with the console on the process' stdin, stdout and stderr.
+func NewHost(dir string) *Host {
+	return &Host{Dir: dir, Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+}
+
+// Run resumes the core and services its semihosting calls until the application exits, returning its exit code.
+// Halting for anything but a semihosting call, or timeout passing, is an error.
+func (h *Host) Run(core Core, timeout time.Duration) (int, error) {
+	deadline := time.Now().Add(timeout)
+	for !h.Exited {
+		// Clear out stale halt reasons so Handle only sees the BKPT
+		_, err := core.DebugFaultStatus()
+		if err != nil {
+			return 0, err
+		}
+		err = core.Resume()
+		if err != nil {
+			return 0, err
+		}
+		err = core.WaitForHalt(time.Until(deadline))
+		if err != nil {
+			return 0, err
+		}
+
+		ok, err := h.Handle(core)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			pc, err := core.ReadCoreRegister(cortexm.PC)
+			if err != nil {
+				return 0, err
+			}
+			return 0, fmt.Errorf("error: Run() core halted at 0x%08x without a semihosting call", pc)
+		}
+	}
+	return h.ExitCode, nil
+}
+
+// Handle services the semihosting call a halted core is stopped at, putting the result in R0 and moving PC past the
+// BKPT so the core can be resumed.  ok is false when the core halted for another reason.  An exit leaves the core
+// halted on the BKPT.
+func (h *Host) Handle(core Core) (ok bool, err error) {
+	dfsr, err := core.DebugFaultStatus()
+	if err != nil {
+		return false, err
+	}
+	if dfsr&cortexm.DebugFaultStatusBKPT == 0 {
+		return false, nil
+	}
+	pc, err := core.ReadCoreRegister(cortexm.PC)
+	if err != nil {
+		return false, err
+	}
+	instr, err := core.ReadMem16(pc, 1)
+	if err != nil {
+		return false, err
+	}
+	if instr[0] != BKPTInstruction {
+		// A breakpoint of the debugger rather than a call
+		return false, nil
+	}
+
+	op, err := core.ReadCoreRegister(cortexm.R0)
+	if err != nil {
+		return true, err
+	}
+	param, err := core.ReadCoreRegister(cortexm.R1)
+	if err != nil {
+		return true, err
+	}
+	result, err := h.call(core, op, param)
+	if err != nil || h.Exited {
+		return true, err
+	}
+	err = core.WriteCoreRegister(cortexm.R0, result)
+	if err != nil {
+		return true, err
+	}
+	return true, core.WriteCoreRegister(cortexm.PC, pc+2)
+}
+
+// call runs operation op with the parameter block at param and returns what goes in R0.  Errors are for failing to
+// reach the target, host side failures are reported to the application through the result.
+func (h *Host) call(core Core, op, param uint32) (uint32, error) {
+	if h.files == nil {
+		h.files = make(map[uint32]*handle)
+		h.next = 1
+		h.start = time.Now()
+	}
+
+	switch op {
+	case SysOpen:
+		args, err := core.ReadAddr32(param, 3)
+		if err != nil {
+			return 0, err
+		}
+		if args[2] > chunkSize {
+			return failed, nil
+		}
+		name, err := core.ReadMem(args[0], int(args[2]))
+		if err != nil {
+			return 0, err
+		}
+		return h.open(string(name), args[1]), nil
+
+	case SysClose:
+		args, err := core.ReadAddr32(param, 1)
+		if err != nil {
+			return 0, err
+		}
+		f := h.files[args[0]]
+		if f == nil {
+			return failed, nil
+		}
+		delete(h.files, args[0])
+		if f.c != nil && f.c.Close() != nil {
+			return failed, nil
+		}
+		return 0, nil
+
+	case SysWriteC:
+		c, err := core.ReadMem(param, 1)
+		if err != nil {
+			return 0, err
+		}
+		_, _ = h.Stdout.Write(c)
+		return 0, nil
+
+	case SysWrite0:
+		s, err := readString(core, param)
+		if err != nil {
+			return 0, err
+		}
+		_, _ = h.Stdout.Write(s)
+		return 0, nil
+
+	case SysWrite:
+		// Returns the number of bytes not written
+		args, err := core.ReadAddr32(param, 3)
+		if err != nil {
+			return 0, err
+		}
+		f := h.files[args[0]]
+		if f == nil || f.w == nil {
+			return args[2], nil
+		}
+		for done := uint32(0); done < args[2]; done += chunkSize {
+			length := args[2] - done
+			if length > chunkSize {
+				length = chunkSize
+			}
+			b, err := core.ReadMem(args[1]+done, int(length))
+			if err != nil {
+				return 0, err
+			}
+			n, err := f.w.Write(b)
+			if err != nil {
+				return args[2] - done - uint32(n), nil
+			}
+		}
+		return 0, nil
+
+	case SysRead:
+		// Returns the number of bytes not read, all of them at the end of the file.  A short read, a chunk at most, is
+		// fine by the spec and the application asks again for the rest.
+		args, err := core.ReadAddr32(param, 3)
+		if err != nil {
+			return 0, err
+		}
+		f := h.files[args[0]]
+		if f == nil || f.r == nil {
+			return failed, nil
+		}
+		length := args[2]
+		if length > chunkSize {
+			length = chunkSize
+		}
+		b := make([]byte, length)
+		n, err := f.r.Read(b)
+		if err != nil && err != io.EOF {
+			return failed, nil
+		}
+		err = core.WriteMem(args[1], b[:n])
+		if err != nil {
+			return 0, err
+		}
+		return args[2] - uint32(n), nil
+
+	case SysClock:
+		// Centiseconds since the first call
+		return uint32(time.Since(h.start) / (10 * time.Millisecond)), nil
+
+	case SysTime:
+		return uint32(time.Now().Unix()), nil
+
+	case SysExit:
+		// AArch32 passes the reason itself, without a status
+		h.Exited = true
+		if param != ADPStoppedApplicationExit {
+			h.ExitCode = 1
+		}
+		return 0, nil
+
+	case SysExitExtended:
+		args, err := core.ReadAddr32(param, 2)
+		if err != nil {
+			return 0, err
+		}
+		h.Exited = true
+		h.ExitCode = int(int32(args[1]))
+		if args[0] != ADPStoppedApplicationExit {
+			h.ExitCode = 1
+		}
+		return 0, nil
+	}
+	return failed, nil
+}
+
+// Close closes the files the application left open, call it once the session ends.
+func (h *Host) Close() error {
+	var err error
+	for fd, f := range h.files {
+		delete(h.files, fd)
+		if f.c != nil {
+			if cerr := f.c.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// open opens name with a SYS_OPEN mode and returns its handle.
+func (h *Host) open(name string, mode uint32) uint32 {
+	if mode >= uint32(len(openFlags)) {
+		return failed
+	}
+	f := &handle{}
+	switch {
+	case name == ConsoleName && mode < 4:
+		f.r = h.Stdin
+	case name == ConsoleName && mode < 8:
+		f.w = h.Stdout
+	case name == ConsoleName:
+		f.w = h.Stderr
+	default:
+		file, err := os.OpenFile(h.path(name), openFlags[mode], 0o644)
+		if err != nil {
+			return failed
+		}
+		f.r, f.w, f.c = file, file, file
+	}
+	fd := h.next
+	h.next++
+	h.files[fd] = f
+	return fd
+}
+
+// path resolves a target file name inside Dir, cleaning it as an absolute path first so .. stops at Dir.
+func (h *Host) path(name string) string {
+	return filepath.Join(h.Dir, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// readString reads the zero terminated string at addr, a chunk at a time without crossing 64 byte boundaries so it
+// doesn't read past the end of a memory region.  A string longer than chunkSize is an error, a wild pointer would
+// otherwise walk all of memory.
+func readString(core Core, addr uint32) ([]byte, error) {
+	start := addr
+	var s []byte
+	for len(s) < chunkSize {
+		chunk, err := core.ReadMem(addr, int(64-addr%64))
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range chunk {
+			if c == 0 {
+				return s, nil
+			}
+			s = append(s, c)
+		}
+		addr += uint32(len(chunk))
+	}
+	return nil, fmt.Errorf("error: readString() no terminating zero in the %d bytes at 0x%08x", chunkSize, start)
+}
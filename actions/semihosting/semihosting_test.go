@@ -0,0 +1,154 @@
+package semihosting
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goocd/core/cortexm"
+	"goocd/probes/simprobe"
+)
+
+func TestHost_Handle(t *testing.T) {
+	sim := &simprobe.Probe{}
+	core := cortexm.New(sim)
+	if err := core.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	sim.WriteWord(0x00000400, BKPTInstruction)
+	writeBytes := func(addr uint32, b []byte) {
+		if err := core.WriteMem(addr, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	out := &bytes.Buffer{}
+	h := &Host{Dir: dir, Stdin: strings.NewReader("input"), Stdout: out}
+	call := func(op uint32, param uint32) uint32 {
+		t.Helper()
+		sim.Core.Registers[cortexm.R0] = op
+		sim.Core.Registers[cortexm.R1] = param
+		sim.Core.Registers[cortexm.PC] = 0x00000400
+		sim.WriteWord(cortexm.DebugFaultStatusRegister, cortexm.DebugFaultStatusBKPT)
+		ok, err := h.Handle(core)
+		if err != nil || !ok {
+			t.Fatalf("operation 0x%02x: expected it to be handled, got %v %v", op, ok, err)
+		}
+		if !h.Exited && sim.Core.Registers[cortexm.PC] != 0x00000402 {
+			t.Fatalf("operation 0x%02x: expected PC past the BKPT, got 0x%08x", op, sim.Core.Registers[cortexm.PC])
+		}
+		return sim.Core.Registers[cortexm.R0]
+	}
+
+	// A string straddling a 64 byte boundary
+	writeBytes(0x2000003C, []byte("hello world\n\x00"))
+	call(SysWrite0, 0x2000003C)
+	if out.String() != "hello world\n" {
+		t.Fatalf("unexpected console output %q", out.String())
+	}
+
+	// A string without its terminating zero in reach
+	if err := core.WriteMem(0x20002000, bytes.Repeat([]byte{'x'}, 0x1100)); err != nil {
+		t.Fatal(err)
+	}
+	sim.Core.Registers[cortexm.R0] = SysWrite0
+	sim.Core.Registers[cortexm.R1] = 0x20002000
+	sim.Core.Registers[cortexm.PC] = 0x00000400
+	sim.WriteWord(cortexm.DebugFaultStatusRegister, cortexm.DebugFaultStatusBKPT)
+	if _, err := h.Handle(core); err == nil || out.Len() != len("hello world\n") {
+		t.Fatalf("expected an unterminated string to fail without output, got %v %q", err, out.String())
+	}
+
+	// Opening outside the sandbox lands inside it
+	writeBytes(0x20000100, []byte("../out.txt"))
+	sim.WriteWord(0x20000200, 0x20000100)
+	sim.WriteWord(0x20000204, 4) // w
+	sim.WriteWord(0x20000208, 10)
+	fd := call(SysOpen, 0x20000200)
+	if fd == failed {
+		t.Fatalf("expected the file to open")
+	}
+	sim.WriteWord(0x20000200, fd)
+	sim.WriteWord(0x20000204, 0x2000003C)
+	sim.WriteWord(0x20000208, 5)
+	if n := call(SysWrite, 0x20000200); n != 0 {
+		t.Fatalf("expected everything written, %d bytes weren't", n)
+	}
+	if r := call(SysClose, 0x20000200); r != 0 {
+		t.Fatalf("expected the close to succeed, got 0x%x", r)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "out.txt")); err != nil || string(b) != "hello" {
+		t.Fatalf("unexpected file contents %q %v", b, err)
+	}
+	if r := call(SysClose, 0x20000200); r != failed {
+		t.Fatalf("expected closing twice to fail")
+	}
+
+	// Reading the console, asking for more than there is
+	writeBytes(0x20000100, []byte(ConsoleName))
+	sim.WriteWord(0x20000200, 0x20000100)
+	sim.WriteWord(0x20000204, 0) // r
+	sim.WriteWord(0x20000208, 3)
+	fd = call(SysOpen, 0x20000200)
+	sim.WriteWord(0x20000200, fd)
+	sim.WriteWord(0x20000204, 0x20000300)
+	sim.WriteWord(0x20000208, 8)
+	if n := call(SysRead, 0x20000200); n != 3 {
+		t.Fatalf("expected 3 bytes not read, got %d", n)
+	}
+	if b, _ := core.ReadMem(0x20000300, 5); string(b) != "input" {
+		t.Fatalf("unexpected bytes read %q", b)
+	}
+
+	// A read longer than a chunk comes up short, a write longer than one goes through whole
+	big := bytes.Repeat([]byte("0123456789abcdef"), 0x180)
+	if err := os.WriteFile(filepath.Join(dir, "big.bin"), big, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeBytes(0x20000100, []byte("big.bin"))
+	sim.WriteWord(0x20000200, 0x20000100)
+	sim.WriteWord(0x20000204, 2) // r+
+	sim.WriteWord(0x20000208, 7)
+	fd = call(SysOpen, 0x20000200)
+	sim.WriteWord(0x20000200, fd)
+	sim.WriteWord(0x20000204, 0x20001000)
+	sim.WriteWord(0x20000208, 0x1800)
+	if n := call(SysRead, 0x20000200); n != 0x800 {
+		t.Fatalf("expected a 0x1000 byte read leaving 0x800 bytes, got 0x%x", n)
+	}
+	if b, _ := core.ReadMem(0x20001000, 0x1000); !bytes.Equal(b, big[:0x1000]) {
+		t.Fatalf("unexpected bytes read")
+	}
+	if n := call(SysRead, 0x20000200); n != 0x1000 {
+		t.Fatalf("expected the last 0x800 bytes read, got 0x%x not read", n)
+	}
+	if n := call(SysWrite, 0x20000200); n != 0 {
+		t.Fatalf("expected everything written, %d bytes weren't", n)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "big.bin")); err != nil || len(b) != 0x3000 {
+		t.Fatalf("expected the write appended to the file, got %d bytes %v", len(b), err)
+	}
+	if n := call(SysRead, 0x20000200); n != failed {
+		t.Fatalf("expected the handle closed with the session")
+	}
+
+	sim.WriteWord(0x20000200, ADPStoppedApplicationExit)
+	sim.WriteWord(0x20000204, 3)
+	call(SysExitExtended, 0x20000200)
+	if !h.Exited || h.ExitCode != 3 {
+		t.Fatalf("expected exit code 3, got %v %d", h.Exited, h.ExitCode)
+	}
+
+	// A breakpoint that isn't a semihosting call
+	sim.WriteWord(0x00000400, 0xBE00)
+	sim.WriteWord(cortexm.DebugFaultStatusRegister, cortexm.DebugFaultStatusBKPT)
+	if ok, err := h.Handle(core); ok || err != nil {
+		t.Fatalf("expected BKPT 0 to be left alone, got %v %v", ok, err)
+	}
+}
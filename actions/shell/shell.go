@@ -0,0 +1,478 @@
+// Package shell is an interactive command console for a connected core, in
+// the spirit of OpenOCD's telnet console.  The probe stays connected between
+// commands, so reading a word costs a memory access rather than a full probe
+// and core configuration.  History is per session: 'history' lists it, '!!'
+// repeats the last command and '!n' command n.  There is no line editing,
+// run it under rlwrap for that.
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"goocd/core"
+	"goocd/core/cortexm"
+	"goocd/fileformats/autoparser"
+	"io"
+	"net"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Prompt is printed before every command.
+const Prompt = "> "
+
+// Shell runs commands against a core, its sessions share it one command at a time.
+type Shell struct {
+	Core core.Core
+	// Program writes data to the flash at addr for load, without it load fails
+	Program func(addr uint32, data []byte) error
+	// ResetStrategy is what reset uses unless told otherwise
+	ResetStrategy cortexm.ResetStrategy
+	// Dir is the directory load and verify open files in, names can't climb out of it
+	Dir string
+
+	mu sync.Mutex
+}
+
+// command is a shell command, run gets the arguments after the name.
+type command struct {
+	usage string
+	help  string
+	run   func(ss *session, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"help":    {"help", "list the commands", (*session).help},
+		"halt":    {"halt", "halt the core", (*session).halt},
+		"resume":  {"resume", "resume the core", (*session).resume},
+		"step":    {"step", "single step one instruction", (*session).step},
+		"status":  {"status", "print the state of the core", (*session).status},
+		"regs":    {"regs", "print the core registers", (*session).regs},
+		"reg":     {"reg name [value]", "print or set a core register", (*session).reg},
+		"mdw":     {"mdw addr [count]", "display 32-bit words", memoryDisplay(4)},
+		"mdh":     {"mdh addr [count]", "display 16-bit halfwords", memoryDisplay(2)},
+		"mdb":     {"mdb addr [count]", "display bytes", memoryDisplay(1)},
+		"mww":     {"mww addr value [count]", "fill count 32-bit words with value", memoryWrite(4)},
+		"mwh":     {"mwh addr value [count]", "fill count 16-bit halfwords with value", memoryWrite(2)},
+		"mwb":     {"mwb addr value [count]", "fill count bytes with value", memoryWrite(1)},
+		"reset":   {"reset [hw|sys|vect] [halt|run]", "reset the target, halting at the reset vector or running", (*session).reset},
+		"load":    {"load file", "program an .elf, .hex or .bin file (.bin at address 0) to flash and verify it", (*session).load},
+		"verify":  {"verify file", "compare an .elf, .hex or .bin file with the target's memory", (*session).verify},
+		"history": {"history", "list the commands of this session, repeat one with !n or the last with !!", (*session).listHistory},
+	}
+}
+
+// errQuit ends a session.
+var errQuit = errors.New("quit")
+
+// session is one console, with its own history.
+type session struct {
+	*Shell
+	out     io.Writer
+	history []string
+}
+
+// Run reads commands from in and writes their output to out until quit, exit or the end of in.
+func (s *Shell) Run(in io.Reader, out io.Writer) error {
+	ss := &session{Shell: s, out: out}
+	fmt.Fprintf(out, "goocd shell, 'help' lists the commands\n")
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, Prompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line, err := ss.expand(strings.TrimSpace(scanner.Text()))
+		if err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		ss.history = append(ss.history, line)
+
+		err = ss.exec(line)
+		if err == errQuit {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintln(out, err)
+		}
+	}
+}
+
+// ListenAndServe serves a console to every TCP connection on addr, for telnet or nc.
+func (s *Shell) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			_ = s.Run(conn, conn)
+		}()
+	}
+}
+
+// expand replaces !! and !n with the command from history.
+func (ss *session) expand(line string) (string, error) {
+	if !strings.HasPrefix(line, "!") {
+		return line, nil
+	}
+	n := len(ss.history)
+	if line != "!!" {
+		var err error
+		n, err = strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("error: expected !! or !n, got %q", line)
+		}
+	}
+	if n < 1 || n > len(ss.history) {
+		return "", fmt.Errorf("error: no command %d in history", n)
+	}
+	line = ss.history[n-1]
+	fmt.Fprintln(ss.out, line)
+	return line, nil
+}
+
+// exec runs one command line.
+func (ss *session) exec(line string) error {
+	fields := strings.Fields(line)
+	if fields[0] == "quit" || fields[0] == "exit" {
+		return errQuit
+	}
+	cmd, ok := commands[fields[0]]
+	if !ok {
+		return fmt.Errorf("error: unknown command %q, 'help' lists the commands", fields[0])
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return cmd.run(ss, fields[1:])
+}
+
+func (ss *session) help(args []string) error {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(ss.out, "  %-32s %s\n", commands[name].usage, commands[name].help)
+	}
+	fmt.Fprintf(ss.out, "  %-32s %s\n", "quit", "end the session")
+	return nil
+}
+
+func (ss *session) halt(args []string) error {
+	err := ss.Core.Halt()
+	if err != nil {
+		return err
+	}
+	return ss.printPC("halted")
+}
+
+func (ss *session) resume(args []string) error {
+	return ss.Core.Resume()
+}
+
+func (ss *session) step(args []string) error {
+	err := ss.Core.Step(false)
+	if err != nil {
+		return err
+	}
+	return ss.printPC("stepped")
+}
+
+func (ss *session) printPC(what string) error {
+	pc, err := ss.Core.ReadCoreRegister(cortexm.PC)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ss.out, "%s at 0x%08x\n", what, pc)
+	return nil
+}
+
+func (ss *session) status(args []string) error {
+	state, err := ss.Core.Status()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ss.out, "%s\n", state)
+	return nil
+}
+
+func (ss *session) regs(args []string) error {
+	regs, err := ss.Core.CoreRegisters()
+	if err != nil {
+		return err
+	}
+	for i, r := range regs {
+		v, err := ss.Core.ReadCoreRegister(r)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(ss.out, "%10s: 0x%08x", r, v)
+		if i%4 == 3 || i == len(regs)-1 {
+			fmt.Fprintln(ss.out)
+		}
+	}
+	return nil
+}
+
+func (ss *session) reg(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("error: usage: reg name [value]")
+	}
+	r, err := cortexm.ParseRegister(args[0])
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 {
+		v, err := parseUint32(args[1])
+		if err != nil {
+			return err
+		}
+		err = ss.Core.WriteCoreRegister(r, v)
+		if err != nil {
+			return err
+		}
+	}
+	v, err := ss.Core.ReadCoreRegister(r)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ss.out, "%s: 0x%08x\n", r, v)
+	return nil
+}
+
+// mdChunk is how many bytes md reads at a time, so a large count is never held in memory at once.
+const mdChunk = 1024
+
+// memoryDisplay returns the md command for accesses of size bytes, 16 bytes to a line.
+func memoryDisplay(size int) func(ss *session, args []string) error {
+	return func(ss *session, args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return errors.New("error: usage: md[whb] addr [count]")
+		}
+		addr, err := parseUint32(args[0])
+		if err != nil {
+			return err
+		}
+		count := uint32(1)
+		if len(args) == 2 {
+			if count, err = parseUint32(args[1]); err != nil {
+				return err
+			}
+		}
+
+		perLine := uint32(16 / size)
+		for done := uint32(0); done < count; {
+			n := count - done
+			if n > mdChunk/uint32(size) {
+				n = mdChunk / uint32(size)
+			}
+			values, err := ss.readValues(size, addr+done*uint32(size), int(n))
+			if err != nil {
+				return err
+			}
+			for _, v := range values {
+				if done%perLine == 0 {
+					fmt.Fprintf(ss.out, "0x%08x:", addr+done*uint32(size))
+				}
+				fmt.Fprintf(ss.out, " %0*x", size*2, v)
+				done++
+				if done%perLine == 0 || done == count {
+					fmt.Fprintln(ss.out)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// readValues reads count accesses of size bytes at addr.
+func (ss *session) readValues(size int, addr uint32, count int) ([]uint32, error) {
+	switch size {
+	case 4:
+		return ss.Core.ReadAddr32(addr, count)
+	case 2:
+		halfwords, err := ss.Core.ReadMem16(addr, count)
+		values := make([]uint32, 0, len(halfwords))
+		for _, v := range halfwords {
+			values = append(values, uint32(v))
+		}
+		return values, err
+	default:
+		b, err := ss.Core.ReadMem(addr, count)
+		values := make([]uint32, 0, len(b))
+		for _, v := range b {
+			values = append(values, uint32(v))
+		}
+		return values, err
+	}
+}
+
+// memoryWrite returns the mw command for accesses of size bytes.
+func memoryWrite(size int) func(ss *session, args []string) error {
+	return func(ss *session, args []string) error {
+		if len(args) < 2 || len(args) > 3 {
+			return errors.New("error: usage: mw[whb] addr value [count]")
+		}
+		addr, err := parseUint32(args[0])
+		if err != nil {
+			return err
+		}
+		value, err := parseUint32(args[1])
+		if err != nil {
+			return err
+		}
+		count := uint32(1)
+		if len(args) == 3 {
+			if count, err = parseUint32(args[2]); err != nil {
+				return err
+			}
+		}
+
+		for i := uint32(0); i < count; i++ {
+			a := addr + i*uint32(size)
+			switch size {
+			case 4:
+				err = ss.Core.WriteAddr32(a, value)
+			case 2:
+				err = ss.Core.WriteMem16(a, uint16(value))
+			default:
+				err = ss.Core.WriteMem8(a, uint8(value))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (ss *session) reset(args []string) error {
+	strategy := ss.ResetStrategy
+	halt := false
+	for _, arg := range args {
+		switch arg {
+		case "halt":
+			halt = true
+		case "run":
+			halt = false
+		default:
+			var err error
+			strategy, err = cortexm.ParseResetStrategy(arg)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err := ss.Core.ResetTarget(strategy, halt)
+	if err != nil {
+		return err
+	}
+	if halt {
+		return ss.printPC("reset, halted")
+	}
+	fmt.Fprintf(ss.out, "reset, running\n")
+	return nil
+}
+
+func (ss *session) load(args []string) error {
+	if len(args) != 1 {
+		return errors.New("error: usage: load file")
+	}
+	if ss.Program == nil {
+		return errors.New("error: this target can't program its flash")
+	}
+	err := forPrograms(ss.path(args[0]), func(addr uint32, data []byte) error {
+		err := ss.Program(addr, data)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(ss.out, "programmed %d bytes at 0x%08x\n", len(data), addr)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return ss.verify(args)
+}
+
+func (ss *session) verify(args []string) error {
+	if len(args) != 1 {
+		return errors.New("error: usage: verify file")
+	}
+	return forPrograms(ss.path(args[0]), func(addr uint32, data []byte) error {
+		mem, err := ss.Core.ReadMem(addr, len(data))
+		if err != nil {
+			return err
+		}
+		for i := range data {
+			if mem[i] != data[i] {
+				return fmt.Errorf("error: verify failed at 0x%08x, expected 0x%02x, read 0x%02x", addr+uint32(i), data[i], mem[i])
+			}
+		}
+		fmt.Fprintf(ss.out, "verified %d bytes at 0x%08x\n", len(data), addr)
+		return nil
+	})
+}
+
+// path resolves a file name inside Dir, cleaning it as an absolute path first so .. stops at Dir.
+func (ss *session) path(name string) string {
+	return filepath.Join(ss.Dir, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// forPrograms calls fn with every program in the file at path.
+func forPrograms(path string, fn func(addr uint32, data []byte) error) error {
+	reader, err := autoparser.ParseFromPath(path, 0)
+	if err != nil {
+		return err
+	}
+	for {
+		program, err := reader.NextProgram()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(uint32(program.StartAddr()), program.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (ss *session) listHistory(args []string) error {
+	for i, line := range ss.history {
+		fmt.Fprintf(ss.out, "%4d  %s\n", i+1, line)
+	}
+	return nil
+}
+
+func parseUint32(s string) (uint32, error) {
+	v, err := strconv.ParseUint(s, 0, 32) // supports hex, dec, oct, bin
+	if err != nil {
+		return 0, fmt.Errorf("error: expected a 32-bit number, got %q", s)
+	}
+	return uint32(v), nil
+}
@@ -0,0 +1,74 @@
+package shell
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goocd/core/cortexm"
+	"goocd/core/cortexm4"
+	"goocd/probes/simprobe"
+)
+
+func TestShell_Run(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.Core.Registers[cortexm.PC] = 0x00000400
+	core := cortexm4.New(sim)
+	dir := t.TempDir()
+	s := &Shell{
+		Core:          core,
+		Dir:           dir,
+		ResetStrategy: cortexm.ResetSystem,
+		Program: func(addr uint32, data []byte) error {
+			return core.WriteMem(addr, data)
+		},
+	}
+	if err := os.WriteFile(filepath.Join(dir, "fw.bin"), []byte{1, 2, 3, 4, 5, 6, 7, 8}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := strings.Join([]string{
+		"halt",
+		"mww 0x20000000 0xdeadbeef 2",
+		"mdw 0x20000000 3",
+		"mwb 0x20000009 0x7f",
+		"mdh 0x20000008 2",
+		"reg pc 0x412",
+		"!!",
+		"!42",
+		"load fw.bin",
+		"mwb 0x5 0",
+		"verify ../fw.bin",
+		"mdw 0x20000000 0x102",
+		"bogus",
+		"history",
+		"quit",
+		"halt",
+	}, "\n")
+	out := &bytes.Buffer{}
+	if err := s.Run(strings.NewReader(in), out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"halted at 0x00000400\n",
+		"0x20000000: deadbeef deadbeef 00000000\n",
+		"0x20000008: 7f00 0000\n",
+		"pc: 0x00000412\n> reg pc 0x412\npc: 0x00000412\n",
+		"error: no command 42 in history\n",
+		"programmed 8 bytes at 0x00000000\nverified 8 bytes at 0x00000000\n",
+		"error: verify failed at 0x00000005, expected 0x06, read 0x00\n",
+		"0x200003f0: 00000000 00000000 00000000 00000000\n0x20000400: 00000000 00000000\n> ",
+		`error: unknown command "bogus"`,
+		"   7  reg pc 0x412\n   8  load fw.bin\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in the output\n%s", want, out.String())
+		}
+	}
+	if strings.Count(out.String(), "halted at") != 1 {
+		t.Fatalf("expected quit to end the session\n%s", out.String())
+	}
+}
@@ -0,0 +1,120 @@
+// Package watch polls firmware variables through memory reads while the core
+// runs and prints their values, as a table rewritten in place on a terminal
+// or as a CSV stream, a row per poll, to log to a file.  Each variable is read
+// in one go, but the variables one after another, a struct is consistent with
+// itself only as far as the probe's memory accesses are.
+package watch
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"goocd/fileformats/dwarfparser"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Core is how variables are read, the core keeps running.
+type Core interface {
+	ReadMem(addr uint32, length int) ([]byte, error)
+}
+
+// Watcher polls Vars every Interval.
+type Watcher struct {
+	Core     Core
+	Vars     []dwarfparser.Variable
+	Interval time.Duration
+	// CSV writes a header, then a row per poll with the seconds since the first one and the values
+	CSV bool
+	// Redraw rewrites the table in place with ANSI escapes, for a terminal
+	Redraw bool
+}
+
+// Run polls until ctx is done, writing the values to out.
+func (w *Watcher) Run(ctx context.Context, out io.Writer) error {
+	var csvOut *csv.Writer
+	if w.CSV {
+		csvOut = csv.NewWriter(out)
+		header := []string{"time"}
+		for _, v := range w.Vars {
+			header = append(header, v.Name)
+		}
+		err := csvOut.Write(header)
+		if err != nil {
+			return err
+		}
+		csvOut.Flush()
+	}
+	width := 0
+	for _, v := range w.Vars {
+		if len(v.Name) > width {
+			width = len(v.Name)
+		}
+	}
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	start := time.Now()
+	for polls := 0; ; polls++ {
+		values, err := w.Poll()
+		if err != nil {
+			return err
+		}
+		elapsed := time.Since(start)
+
+		if w.CSV {
+			err = csvOut.Write(append([]string{strconv.FormatFloat(elapsed.Seconds(), 'f', 3, 64)}, values...))
+			if err != nil {
+				return err
+			}
+			csvOut.Flush()
+			err = csvOut.Error()
+		} else {
+			err = w.printTable(out, values, elapsed, width, polls > 0)
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll reads every variable and formats its value.
+func (w *Watcher) Poll() ([]string, error) {
+	values := make([]string, len(w.Vars))
+	for i, v := range w.Vars {
+		b, err := w.Core.ReadMem(v.Addr, int(v.Size))
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v.Format(b)
+	}
+	return values, nil
+}
+
+// printTable writes a line with the time and one per variable, over the previous ones when redrawing.
+func (w *Watcher) printTable(out io.Writer, values []string, elapsed time.Duration, width int, again bool) error {
+	eol := "\n"
+	if w.Redraw {
+		eol = "\x1b[K\n" // clear what's left of a longer previous value
+		if again {
+			fmt.Fprintf(out, "\x1b[%dA", len(values)+1)
+		}
+	} else if again {
+		fmt.Fprint(out, "\n")
+	}
+	fmt.Fprintf(out, "%*s  %v%s", -width, "time", elapsed.Round(time.Millisecond), eol)
+	for i, v := range w.Vars {
+		_, err := fmt.Fprintf(out, "%*s  %s%s", -width, v.Name, values[i], eol)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"regexp"
+	"testing"
+	"time"
+
+	"goocd/fileformats/dwarfparser"
+)
+
+// counting is a core whose counter at addr goes up by one on every read, done after polls reads of it.
+type counting struct {
+	addr    uint32
+	counter uint32
+	reads   int
+	polls   int
+	cancel  func()
+}
+
+func (c *counting) ReadMem(addr uint32, length int) ([]byte, error) {
+	b := make([]byte, length)
+	if addr == c.addr {
+		c.counter++
+		binary.LittleEndian.PutUint32(b, c.counter)
+		if c.reads++; c.reads == c.polls {
+			c.cancel()
+		}
+	} else {
+		b[0] = 1 // state.mode
+	}
+	return b, nil
+}
+
+func TestWatcher_Run(t *testing.T) {
+	info, err := dwarfparser.Load("../../fileformats/testdata/vars.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vars []dwarfparser.Variable
+	for _, name := range []string{"counter", "state.mode"} {
+		v, err := info.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vars = append(vars, v)
+	}
+
+	run := func(w *Watcher, polls int) string {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		w.Core = &counting{addr: vars[0].Addr, polls: polls, cancel: cancel}
+		w.Vars = vars
+		w.Interval = time.Millisecond
+		out := &bytes.Buffer{}
+		if err := w.Run(ctx, out); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	got := run(&Watcher{CSV: true}, 3)
+	if !regexp.MustCompile(`^time,counter,state.mode\n0.000,1,MODE_RUN\n\d+\.\d{3},2,MODE_RUN\n\d+\.\d{3},3,MODE_RUN\n$`).MatchString(got) {
+		t.Fatalf("unexpected CSV\n%s", got)
+	}
+
+	got = run(&Watcher{}, 2)
+	if !regexp.MustCompile(`^time        0s\ncounter     1\nstate.mode  MODE_RUN\n\ntime        \S+\ncounter     2\nstate.mode  MODE_RUN\n$`).MatchString(got) {
+		t.Fatalf("unexpected table\n%s", got)
+	}
+
+	got = run(&Watcher{Redraw: true}, 2)
+	if !regexp.MustCompile(`^time        0s\x1b\[K\ncounter     1\x1b\[K\nstate.mode  MODE_RUN\x1b\[K\n\x1b\[3Atime        \S+\x1b\[K\ncounter     2\x1b\[K\n`).MatchString(got) {
+		t.Fatalf("unexpected redrawn table %q", got)
+	}
+}
@@ -0,0 +1,295 @@
+// Package adi implements the parts of the ARM Debug Interface shared by every
+// Cortex-M core, powering up the Debug Port and accessing memory through the
+// AHB-AP MEM-AP.
+package adi
+
+import (
+	"encoding/binary"
+	"goocd/protocols/cmsisdap"
+)
+
+// Debug Port IDCODE Masks
+const (
+	VersionMask    = 0xF0000000
+	PartNumberMask = 0xFFFF000
+	DesignerMask   = 0xFFE
+)
+
+// Debug Port CTRL Register Mappings
+const (
+	CSYSPWRUPREQEnable  = 0x40000000
+	CSYSPWRUPREQDisable = 0x0
+
+	CDBGPWRUPREQEnable  = 0x10000000
+	CDBGPWRUPREQDisable = 0x0
+
+	CDBGRSTREQEnable  = 0x4000000
+	CDBGRSTREQDisable = 0x0
+
+	TRNCNTMask0x1FF000
+	MASKLANEMask = 0xF00
+
+	ORUNDETECTEnable
+	ORUNDETECTDisable
+)
+
+// Debug Port ABORT Register bits, clearing the sticky flags
+const (
+	ABORTSTKCMPCLR  = 0x2
+	ABORTSTKERRCLR  = 0x4
+	ABORTWDERRCLR   = 0x8
+	ABORTORUNERRCLR = 0x10
+)
+
+// Useful Consts
+const (
+	APSELPOS     = 0x24
+	APBANKSELPOS = 0x4
+)
+
+const (
+	AHBAPDAPEnable     = 0x40
+	AHBAPEnableDebug   = 0x20000000
+	AHBAPAddrIncOff    = 0x0
+	AHBAPAddrIncSingle = 0x10
+	AHBAPAddrIncPacked = 0x20
+	AHBAPSPIDEN        = 0x800000   // Read only, set when secure accesses are allowed
+	AHBAPHNonSec       = 0x40000000 // AHB5-AP on ARMv8-M, makes the access Non-secure
+	DataSizeuint8      = 0x0
+	DataSizeuint16     = 0x1
+	DataSizeuint32     = 0x2
+)
+
+// Port Banks
+const (
+	Bank0 = uint32(iota)
+	Bank1
+	Bank2
+	Bank3
+	Bank4
+	Bank5
+	Bank6
+	Bank7
+	Bank8
+	Bank9
+	BankA
+	BankB
+	BankC
+	BankD
+	BankE
+	BankF
+	BankPos = 0x4
+)
+
+type DAPTransferer interface {
+	DAPTransfer(dapidx uint8, count uint8, data []byte) ([]byte, error)
+	DAPTransferBlock(dapidx uint8, count uint16, request byte, data []byte) ([]byte, error)
+}
+
+// MemAP accesses target memory through the AHB-AP of a Debug Port reached over DAP transfers.
+type MemAP struct {
+	DAPTransferer
+	NonSecure      bool // Do every access with HNONSEC set, as Non-secure software would see memory
+	encodingBuffer [512]byte
+}
+
+// Request is a single DAP transfer request, the payload is only sent for writes.
+type Request struct {
+	RequestByte byte
+	Payload     uint32
+}
+
+// Configure
+func (d *MemAP) Configure() (err error) {
+	// Read Debug Port IDCODE
+	resp, err := d.DAPTransfer(0, 1, d.EncodeDAPRequest([]Request{
+		{
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Read | cmsisdap.PortRegister0),
+		},
+	}))
+	if err != nil {
+		return err
+	}
+	_ = resp
+	// Todo: Validate
+
+	// Debug Power Enable
+	resp, err = d.DAPTransfer(0, 5, d.EncodeDAPRequest([]Request{
+		{
+			// Clear out the Selections Registers to known state
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Write | cmsisdap.PortRegister8),
+		},
+		{
+			// Enable Debug Power
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Write | cmsisdap.PortRegister4),
+			Payload:     CSYSPWRUPREQEnable | CDBGPWRUPREQEnable,
+		},
+		{
+			// Read Back Register for validation
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Read | cmsisdap.PortRegister4),
+		},
+		{
+			// Enable Debug Power
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Write | cmsisdap.PortRegister4),
+			Payload:     CSYSPWRUPREQEnable | CDBGPWRUPREQEnable,
+		},
+		{
+			// Read Back Register for validation
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Read | cmsisdap.PortRegister4),
+		},
+	}))
+	if err != nil {
+		return err
+	}
+
+	// Todo: Validate
+
+	resp, err = d.DAPTransfer(0, 1, d.EncodeDAPRequest([]Request{
+		{
+			// Read Back Register for validation
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Read | cmsisdap.PortRegister4),
+		},
+	}))
+	if err != nil {
+		return err
+	}
+
+	// Todo: Validate
+
+	// Enable Debug Power. There's a 100% chance this can be simplified with a loop and verified rather than just doing this repeatedly in sequence
+	resp, err = d.DAPTransfer(0, 3, d.EncodeDAPRequest([]Request{
+		{
+			// Read Back Register for validation
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Read | cmsisdap.PortRegister4),
+		},
+		{
+			// Enable Debug Power
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Write | cmsisdap.PortRegister4),
+			Payload:     CSYSPWRUPREQEnable | CDBGPWRUPREQEnable,
+		},
+		{
+			// Read Back Register for validation
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Read | cmsisdap.PortRegister4),
+		},
+	}))
+	if err != nil {
+		return err
+	}
+
+	// Todo: Validate
+
+	// Read Access Port IDCODE
+	resp, err = d.DAPTransfer(0, 2, d.EncodeDAPRequest([]Request{
+		{
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Write | cmsisdap.PortRegister8),
+			Payload:     BankF << BankPos,
+		},
+		{
+			RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Read | cmsisdap.PortRegisterC),
+		},
+	}))
+	if err != nil {
+		return err
+	}
+	// Todo: Validate
+
+	// Initialize Debugging in the AHB-AP with DataSize 32 bit
+	resp, err = d.DAPTransfer(0, 2, d.EncodeDAPRequest([]Request{
+		{
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Write | cmsisdap.PortRegister8),
+			Payload:     Bank0,
+		},
+		{
+			RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Write | cmsisdap.PortRegister0),
+			Payload:     AHBAPEnableDebug | AHBAPDAPEnable | DataSizeuint32,
+		},
+	}))
+	if err != nil {
+		return err
+	}
+	// Todo: Validate
+
+	return nil
+}
+
+// CSW is the AHB-AP CSW value for accesses of size with the addrInc auto increment mode.
+func (d *MemAP) CSW(addrInc, size uint32) uint32 {
+	csw := AHBAPEnableDebug | AHBAPDAPEnable | addrInc | size
+	if d.NonSecure {
+		csw |= AHBAPHNonSec
+	}
+	return csw
+}
+
+// SecureAccessAllowed reports if the AHB-AP may do Secure accesses, SPIDEN in CSW reflects the debug authentication.
+func (d *MemAP) SecureAccessAllowed() (bool, error) {
+	resp, err := d.DAPTransfer(0, 2, d.EncodeDAPRequest([]Request{
+		{
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Write | cmsisdap.PortRegister8),
+			Payload:     Bank0,
+		},
+		{
+			RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Read | cmsisdap.PortRegister0),
+		},
+	}))
+	if err != nil {
+		return false, err
+	}
+	return binary.LittleEndian.Uint32(resp[3:7])&AHBAPSPIDEN > 0, nil
+}
+
+// ClearErrors clears the sticky error flags through the DP ABORT register.  A FAULT response to an access leaves
+// STICKYERR set, failing every AP access after it until it is cleared.
+func (d *MemAP) ClearErrors() error {
+	return d.WriteTransfer32(cmsisdap.DebugPort, cmsisdap.PortRegister0, ABORTSTKCMPCLR|ABORTSTKERRCLR|ABORTWDERRCLR|ABORTORUNERRCLR)
+}
+
+// WriteTransfer32 A simple way to abstract doing a single write transaction rather than a complete write which does multiple commands at once
+func (d *MemAP) WriteTransfer32(port, portRegister byte, value uint32) error {
+	_, err := d.DAPTransfer(0, 1, d.EncodeDAPRequest([]Request{
+		{
+			RequestByte: port | cmsisdap.Write | portRegister,
+			Payload:     value,
+		},
+	}))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReadTransfer32 A simple Way to abstract doing a single transaction rather than a complete Read which alters a few other registers.
+// Always returns a uint32, up to the Caller to ensure they read the correct value out of it.
+func (d *MemAP) ReadTransfer32(port, portRegister byte) (uint32, error) {
+	resp, err := d.DAPTransfer(0, 1, d.EncodeDAPRequest([]Request{
+		{
+			RequestByte: port | cmsisdap.Read | portRegister,
+		},
+	}))
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(resp[3:7]), nil
+}
+
+// EncodeDAPRequest Simple way to just simplify how we use DAP Transfer requests.
+// TODO: Decide if it makes sense for this and the type to live here rather than in CMSISDAP
+func (d *MemAP) EncodeDAPRequest(requests []Request) []byte {
+	idx := 0
+	for _, req := range requests {
+		d.encodingBuffer[idx] = req.RequestByte
+		idx++
+
+		if req.RequestByte&cmsisdap.Read > 0 {
+			// No more is needed for reads
+			continue
+		}
+		binary.LittleEndian.PutUint32(d.encodingBuffer[idx:], req.Payload)
+		//fmt.Printf("Request: %x, PayLoad: %x\n", req.RequestByte, req.Payload)
+		//randoBuf := make([]byte, 4)
+		//binary.LittleEndian.PutUint32(randoBuf, req.Payload)
+		//fmt.Printf("Little Endian conversion: %x\n", randoBuf)
+		idx += 4
+	}
+	return d.encodingBuffer[:idx]
+}
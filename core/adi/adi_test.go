@@ -0,0 +1,136 @@
+package adi
+
+import (
+	"testing"
+
+	"goocd/probes/simprobe"
+)
+
+func TestMemAP_ReadAddr32(t *testing.T) {
+	sim := &simprobe.Probe{}
+	base := uint32(0x200003F0) // 4 words before a 1KB boundary
+	for i := uint32(0); i < 200; i++ {
+		sim.WriteWord(base+i*4, 0xA5000000|i)
+	}
+
+	core := &MemAP{DAPTransferer: sim}
+	if err := core.Configure(); err != nil {
+		t.Fatal(err)
+	}
+
+	vals, err := core.ReadAddr32(base, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 200 {
+		t.Fatalf("expected 200 values, got %d", len(vals))
+	}
+	for i, v := range vals {
+		if v != 0xA5000000|uint32(i) {
+			t.Fatalf("value %d: expected 0x%x, got 0x%x", i, 0xA5000000|uint32(i), v)
+		}
+	}
+
+	if _, err := core.ReadAddr32(base+2, 1); err == nil {
+		t.Fatalf("expected error on unaligned address")
+	}
+}
+
+func TestMemAP_Mem8Mem16(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.WriteWord(0x41004018, 0x00040000)
+	core := &MemAP{DAPTransferer: sim}
+
+	if err := core.WriteMem16(0x4100401A, 0xBEEF); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.WriteMem8(0x41004019, 0x12); err != nil {
+		t.Fatal(err)
+	}
+	if got := sim.ReadWord(0x41004018); got != 0xBEEF1200 {
+		t.Fatalf("expected 0xBEEF1200, got 0x%x", got)
+	}
+
+	halfs, err := core.ReadMem16(0x41004018, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if halfs[0] != 0x1200 || halfs[1] != 0xBEEF {
+		t.Fatalf("unexpected halfwords %x", halfs)
+	}
+
+	if err := core.WriteMem(0x20000003, []byte{1, 2, 3, 4, 5, 6, 7}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := core.ReadMem(0x20000001, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0, 0, 1, 2, 3, 4, 5, 6, 7, 0}
+	for i := range want {
+		if b[i] != want[i] {
+			t.Fatalf("expected %x, got %x", want, b)
+		}
+	}
+
+	// Shorter than the unaligned head, no word accesses at all
+	if err := core.WriteMem(0x20000011, []byte{8, 9}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sim.ReadWord(0x20000010); got != 0x00090800 {
+		t.Fatalf("expected 0x00090800, got 0x%x", got)
+	}
+}
+
+func TestMemAP_NonSecure(t *testing.T) {
+	sim := &simprobe.Probe{SecureMemory: func(addr uint32) bool { return addr < 0x20000000 }}
+	sim.WriteWord(0x100, 0xCAFEF00D)
+	mem := &MemAP{DAPTransferer: sim}
+
+	allowed, err := mem.SecureAccessAllowed()
+	if err != nil || !allowed {
+		t.Fatalf("expected secure accesses to be allowed, got %t %v", allowed, err)
+	}
+	if vals, err := mem.ReadAddr32(0x100, 1); err != nil || vals[0] != 0xCAFEF00D {
+		t.Fatalf("expected a secure read to see 0xcafef00d, got %x %v", vals, err)
+	}
+
+	mem.NonSecure = true
+	if vals, err := mem.ReadAddr32(0x100, 1); err != nil || vals[0] != 0 {
+		t.Fatalf("expected a non-secure read of secure memory to read as zero, got %x %v", vals, err)
+	}
+	if err := mem.WriteAddr32(0x100, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := sim.ReadWord(0x100); got != 0xCAFEF00D {
+		t.Fatalf("expected a non-secure write to secure memory to be ignored, got 0x%x", got)
+	}
+
+	sim.SecureDebugDisabled = true
+	if allowed, _ := mem.SecureAccessAllowed(); allowed {
+		t.Fatalf("expected SPIDEN to be low")
+	}
+}
+
+func TestMemAP_ClearErrors(t *testing.T) {
+	sim := &simprobe.Probe{Fault: func(addr uint32) bool { return addr >= 0x20001000 }}
+	sim.WriteWord(0x20000FFC, 0x12345678)
+	core := &MemAP{DAPTransferer: sim}
+	if err := core.Configure(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := core.ReadAddr32(0x20000FF0, 8); err == nil {
+		t.Fatalf("expected the read past 0x20001000 to fault")
+	}
+	if err := core.WriteAddr32(0x20001000, 1); err == nil {
+		t.Fatalf("expected the write to 0x20001000 to fault")
+	}
+	vals, err := core.ReadAddr32(0x20000FFC, 1)
+	if err != nil {
+		t.Fatalf("expected the sticky error to be cleared, got %v", err)
+	}
+	if vals[0] != 0x12345678 {
+		t.Fatalf("expected 0x12345678, got 0x%x", vals[0])
+	}
+}
@@ -0,0 +1,265 @@
+package adi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"goocd/protocols/cmsisdap"
+)
+
+// TAR auto increment is only guaranteed within a 1KB boundary, after which TAR has to be written again
+const TARAutoIncrementBoundary = 0x400
+
+// MaxBlockTransferWords is how many words are requested per DAP_TransferBlock, keeping the request and response well within a single packet
+const MaxBlockTransferWords = 64
+
+// ReadAddr32 does direct memory access and reads count consecutive 32 bit values starting at the provided address.
+// TAR is rewritten whenever the auto increment would cross a 1KB boundary and the data is pulled in with block transfers.
+func (d *MemAP) ReadAddr32(addr uint32, count int) ([]uint32, error) {
+	return d.readMem(addr, count, DataSizeuint32)
+}
+
+// ReadMem16 reads count consecutive 16 bit values starting at the provided halfword aligned address.
+func (d *MemAP) ReadMem16(addr uint32, count int) ([]uint16, error) {
+	raw, err := d.readMem(addr, count, DataSizeuint16)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]uint16, len(raw))
+	for i, v := range raw {
+		values[i] = uint16(v >> laneShift(addr+uint32(i*2)))
+	}
+	return values, nil
+}
+
+// ReadMem8 reads count consecutive 8 bit values starting at the provided address.
+func (d *MemAP) ReadMem8(addr uint32, count int) ([]uint8, error) {
+	raw, err := d.readMem(addr, count, DataSizeuint8)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]uint8, len(raw))
+	for i, v := range raw {
+		values[i] = uint8(v >> laneShift(addr+uint32(i)))
+	}
+	return values, nil
+}
+
+// ReadMem reads length bytes starting at any address, using byte accesses for the unaligned head and tail and word accesses for everything in between.
+func (d *MemAP) ReadMem(addr uint32, length int) ([]byte, error) {
+	b := make([]byte, 0, length)
+
+	head := int((4 - addr%4) % 4)
+	if head > length {
+		head = length
+	}
+	if head > 0 {
+		vals, err := d.ReadMem8(addr, head)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, vals...)
+		addr += uint32(head)
+	}
+
+	words, err := d.ReadAddr32(addr, (length-len(b))/4)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range words {
+		b = binary.LittleEndian.AppendUint32(b, w)
+	}
+	addr += uint32(len(words) * 4)
+
+	if tail := length - len(b); tail > 0 {
+		vals, err := d.ReadMem8(addr, tail)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, vals...)
+	}
+	return b, nil
+}
+
+// WriteAddr32 is a simple way to write a value to a given address
+func (d *MemAP) WriteAddr32(addr, value uint32) error {
+	return d.writeMem(addr, []uint32{value}, DataSizeuint32)
+}
+
+// WriteSeqAddr32 does sequential write transactions to the AHB-AccessPort address provided based off how many values are in the buffer.
+func (d *MemAP) WriteSeqAddr32(addr uint32, value []uint32) error {
+	return d.writeMem(addr, value, DataSizeuint32)
+}
+
+// WriteMem16 writes a 16 bit value to the provided halfword aligned address.
+func (d *MemAP) WriteMem16(addr uint32, value uint16) error {
+	return d.writeMem(addr, []uint32{uint32(value) << laneShift(addr)}, DataSizeuint16)
+}
+
+// WriteMem8 writes an 8 bit value to the provided address.
+func (d *MemAP) WriteMem8(addr uint32, value uint8) error {
+	return d.writeMem(addr, []uint32{uint32(value) << laneShift(addr)}, DataSizeuint8)
+}
+
+// WriteMem writes the bytes to any address, using byte accesses for the unaligned head and tail and word accesses for everything in between.
+func (d *MemAP) WriteMem(addr uint32, b []byte) error {
+	for len(b) > 0 && addr%4 != 0 {
+		err := d.WriteMem8(addr, b[0])
+		if err != nil {
+			return err
+		}
+		addr++
+		b = b[1:]
+	}
+
+	words := make([]uint32, len(b)/4)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	if len(words) > 0 {
+		err := d.WriteSeqAddr32(addr, words)
+		if err != nil {
+			return err
+		}
+	}
+	addr += uint32(len(words) * 4)
+	b = b[len(words)*4:]
+
+	for i := range b {
+		err := d.WriteMem8(addr+uint32(i), b[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// laneShift is where on the 32 bit data bus the byte or halfword for addr lives.
+func laneShift(addr uint32) uint32 {
+	return (addr & 3) * 8
+}
+
+// transferSetup selects the AHB-AP with the requested data size and auto increment, pointing TAR at addr.
+func (d *MemAP) transferSetup(addr, size uint32) []Request {
+	return []Request{
+		{
+			// Clear out the Selections Registers to known state
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Write | cmsisdap.PortRegister8),
+		},
+		{
+			RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Write | cmsisdap.PortRegister0),
+			Payload:     d.CSW(AHBAPAddrIncSingle, size),
+		},
+		{
+			RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Write | cmsisdap.PortRegister4),
+			Payload:     addr,
+		},
+	}
+}
+
+// chunkLen is how many accesses of width bytes can be done from addr without crossing the TAR auto increment boundary, capped at remaining.
+func chunkLen(addr, width uint32, remaining int) int {
+	n := int((TARAutoIncrementBoundary - addr%TARAutoIncrementBoundary) / width)
+	if n > remaining {
+		n = remaining
+	}
+	if n > MaxBlockTransferWords {
+		n = MaxBlockTransferWords
+	}
+	return n
+}
+
+// readMem reads count elements of the given CSW data size, returning the raw data register value for each so the caller can pick out the byte lanes.
+// A single element is read in one DAP transfer together with the setup, anything longer uses block transfers.
+func (d *MemAP) readMem(addr uint32, count int, size uint32) ([]uint32, error) {
+	width := uint32(1) << size
+	if count < 0 {
+		return nil, fmt.Errorf("error: readMem() invalid count %d", count)
+	}
+	if addr%width != 0 {
+		return nil, fmt.Errorf("error: readMem() address 0x%x is not aligned to %d bytes", addr, width)
+	}
+
+	values := make([]uint32, 0, count)
+	for len(values) < count {
+		n := chunkLen(addr, width, count-len(values))
+		requests := d.transferSetup(addr, size)
+
+		if n == 1 {
+			// Read Data Register
+			requests = append(requests, Request{RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Read | cmsisdap.PortRegisterC)})
+			resp, err := d.DAPTransfer(0, uint8(len(requests)), d.EncodeDAPRequest(requests))
+			if err != nil {
+				return nil, d.failed(err)
+			}
+			values = append(values, binary.LittleEndian.Uint32(resp[3:7]))
+			addr += width
+			continue
+		}
+
+		_, err := d.DAPTransfer(0, uint8(len(requests)), d.EncodeDAPRequest(requests))
+		if err != nil {
+			return nil, d.failed(err)
+		}
+		// Read Data Register n times
+		resp, err := d.DAPTransferBlock(0, uint16(n), byte(cmsisdap.AccessPort|cmsisdap.Read|cmsisdap.PortRegisterC), nil)
+		if err != nil {
+			return nil, d.failed(err)
+		}
+		for i := 0; i < n; i++ {
+			values = append(values, binary.LittleEndian.Uint32(resp[4+i*4:]))
+		}
+		addr += uint32(n) * width
+	}
+
+	return values, nil
+}
+
+// writeMem writes each of the values, already placed on the correct byte lanes, with the given CSW data size.
+// A single element is written in one DAP transfer together with the setup, anything longer uses block transfers.
+func (d *MemAP) writeMem(addr uint32, values []uint32, size uint32) error {
+	width := uint32(1) << size
+	if addr%width != 0 {
+		return fmt.Errorf("error: writeMem() address 0x%x is not aligned to %d bytes", addr, width)
+	}
+
+	for len(values) > 0 {
+		n := chunkLen(addr, width, len(values))
+		requests := d.transferSetup(addr, size)
+
+		if n == 1 {
+			// Write Data Register
+			requests = append(requests, Request{RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Write | cmsisdap.PortRegisterC), Payload: values[0]})
+			_, err := d.DAPTransfer(0, uint8(len(requests)), d.EncodeDAPRequest(requests))
+			if err != nil {
+				return d.failed(err)
+			}
+			values = values[1:]
+			addr += width
+			continue
+		}
+
+		_, err := d.DAPTransfer(0, uint8(len(requests)), d.EncodeDAPRequest(requests))
+		if err != nil {
+			return d.failed(err)
+		}
+		data := make([]byte, 0, n*4)
+		for _, v := range values[:n] {
+			data = binary.LittleEndian.AppendUint32(data, v)
+		}
+		// Write Data Register n times
+		_, err = d.DAPTransferBlock(0, uint16(n), byte(cmsisdap.AccessPort|cmsisdap.Write|cmsisdap.PortRegisterC), data)
+		if err != nil {
+			return d.failed(err)
+		}
+		values = values[n:]
+		addr += uint32(n) * width
+	}
+
+	return nil
+}
+
+// failed clears the sticky errors a failed transfer may have left, so the accesses after it can succeed, and returns err.
+func (d *MemAP) failed(err error) error {
+	_ = d.ClearErrors()
+	return err
+}
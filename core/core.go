@@ -0,0 +1,93 @@
+// Package core picks the core package matching the CPU found behind a debug
+// port.  Every core package builds on cortexm.DAPTransferCoreAccess, Core is
+// what they have in common and what the actions and targets drive.
+package core
+
+import (
+	"fmt"
+	"goocd/core/adi"
+	"goocd/core/cortexm"
+	"goocd/core/cortexm0"
+	"goocd/core/cortexm23"
+	"goocd/core/cortexm33"
+	"goocd/core/cortexm4"
+	"goocd/core/cortexm7"
+	"time"
+)
+
+// Core is implemented by the DAPTransferCoreAccess of every core package.
+type Core interface {
+	cortexm.MemoryAccess
+	ReadMem(addr uint32, length int) ([]byte, error)
+	ReadMem16(addr uint32, count int) ([]uint16, error)
+	WriteMem8(addr uint32, value uint8) error
+	WriteMem16(addr uint32, value uint16) error
+	WriteMem(addr uint32, b []byte) error
+
+	Configure() error
+	ReadCPUID() (cortexm.CPUID, error)
+
+	ReadCoreRegister(reg cortexm.Register) (uint32, error)
+	WriteCoreRegister(reg cortexm.Register, value uint32) error
+	CoreRegisters() ([]cortexm.Register, error)
+	HasFPU() (bool, error)
+
+	Halt() error
+	Resume() error
+	Step(maskInts bool) error
+	Status() (cortexm.CoreState, error)
+	WaitForHalt(timeout time.Duration) error
+	DebugFaultStatus() (uint32, error)
+	ResetTarget(strategy cortexm.ResetStrategy, halt bool) error
+
+	HasSecurityExtension() (bool, error)
+	SecurityState() (cortexm.SecurityState, error)
+	DebugAuthentication() (cortexm.DebugAuthentication, error)
+}
+
+// New returns the core package driving part through t, not yet configured.
+// nonSecure makes the TrustZone capable cores access memory as Non-secure software would, it is ignored by the others.
+func New(t adi.DAPTransferer, part cortexm.PartNumber, nonSecure bool) (Core, error) {
+	switch part {
+	case cortexm.PartCortexM0, cortexm.PartCortexM0Plus:
+		return cortexm0.New(t), nil
+	case cortexm.PartCortexM3, cortexm.PartCortexM4:
+		// The Cortex-M3 is an ARMv7-M core without the FPU, which cortexm4 detects
+		return cortexm4.New(t), nil
+	case cortexm.PartCortexM7:
+		return cortexm7.New(t), nil
+	case cortexm.PartCortexM23:
+		c := cortexm23.New(t)
+		c.NonSecure = nonSecure
+		return c, nil
+	case cortexm.PartCortexM33:
+		c := cortexm33.New(t)
+		c.NonSecure = nonSecure
+		return c, nil
+	}
+	return nil, fmt.Errorf("error: core.New() no core package for %s", part)
+}
+
+// Detect reads CPUID through t and returns the matching core package, configured, along with the CPUID it found.
+func Detect(t adi.DAPTransferer, nonSecure bool) (Core, cortexm.CPUID, error) {
+	// CPUID isn't banked, but with Secure debug disabled only Non-secure accesses get through
+	probe := cortexm.New(t)
+	probe.NonSecure = nonSecure
+	err := probe.Configure()
+	if err != nil {
+		return nil, cortexm.CPUID{}, err
+	}
+	id, err := probe.ReadCPUID()
+	if err != nil {
+		return nil, id, err
+	}
+	if !id.IsARM() {
+		return nil, id, fmt.Errorf("error: core.Detect() unsupported core %s", id)
+	}
+
+	c, err := New(t, id.PartNo, nonSecure)
+	if err != nil {
+		return nil, id, err
+	}
+	return c, id, c.Configure()
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"testing"
+
+	"goocd/core/cortexm"
+	"goocd/core/cortexm0"
+	"goocd/core/cortexm23"
+	"goocd/core/cortexm4"
+	"goocd/core/cortexm7"
+	"goocd/probes/simprobe"
+)
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		cpuid uint32
+		want  string
+		check func(Core) bool
+	}{
+		{0x410CC601, "Cortex-M0+ r0p1", func(c Core) bool { _, ok := c.(*cortexm0.DAPTransferCoreAccess); return ok }},
+		{0x410FC241, "Cortex-M4 r0p1", func(c Core) bool { _, ok := c.(*cortexm4.DAPTransferCoreAccess); return ok }},
+		{0x411FC272, "Cortex-M7 r1p2", func(c Core) bool { _, ok := c.(*cortexm7.DAPTransferCoreAccess); return ok }},
+		{0x411CD200, "Cortex-M23 r1p0", func(c Core) bool {
+			m23, ok := c.(*cortexm23.DAPTransferCoreAccess)
+			return ok && m23.NonSecure
+		}},
+	}
+	for _, tc := range cases {
+		sim := &simprobe.Probe{}
+		sim.WriteWord(cortexm.CPUIDRegister, tc.cpuid)
+		c, id, err := Detect(sim, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id.String() != tc.want || !tc.check(c) {
+			t.Fatalf("CPUID 0x%08x: expected %s, got %s %T", tc.cpuid, tc.want, id, c)
+		}
+	}
+
+	sim := &simprobe.Probe{}
+	sim.WriteWord(cortexm.CPUIDRegister, 0x560F0010)
+	if _, id, err := Detect(sim, false); err == nil || id.IsARM() {
+		t.Fatalf("expected a non ARM core to be refused, got %s", id)
+	}
+}
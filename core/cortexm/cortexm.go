@@ -0,0 +1,171 @@
+// Package cortexm holds the debug functionality common to the Cortex-M cores,
+// the run control, core register, reset, breakpoint and watchpoint logic
+// found in the System Control Space of every ARMv6-M, ARMv7-M and ARMv8-M part.
+// The per core packages embed DAPTransferCoreAccess and add what is specific
+// to their architecture.
+package cortexm
+
+import (
+	"goocd/core/adi"
+)
+
+const (
+	FlashPatchCTRLRegister        = 0xE0002000
+	FlashPatchCTRLEnable          = 0x1
+	FlashPatchCTRLKey             = 0x2
+	FlashPatchCTRLNumCodeLowMask  = 0xF0
+	FlashPatchCTRLNumCodeLowPos   = 4
+	FlashPatchCTRLNumCodeHighMask = 0x7000
+	FlashPatchCTRLNumCodeHighPos  = 12
+	FlashPatchCTRLRevMask         = 0xF0000000
+	FlashPatchCTRLRevPos          = 28
+	FlashPatchComparator0         = 0xE0002008
+
+	// FPBv1 comparators match a word in the code region and replace one of its halfwords with a BKPT
+	FlashPatchCompV1Enable       = 0x1
+	FlashPatchCompV1AddrMask     = 0x1FFFFFFC
+	FlashPatchCompV1ReplaceLower = 0x40000000
+	FlashPatchCompV1ReplaceUpper = 0x80000000
+	FlashPatchCodeRegionEnd      = 0x20000000
+
+	// FPBv2 comparators hold the halfword address directly and can match anywhere
+	FlashPatchCompV2Enable = 0x1
+)
+
+const (
+	DataWatchpointCTRLRegister       = 0xE0001000
+	DataWatchpointCTRLNumCompMask    = 0xF0000000
+	DataWatchpointCTRLNumCompPos     = 28
+	DataWatchpointCTRLNoCycCnt       = 0x2000000 // ARMv7-M and ARMv8-M, ARMv6-M has no cycle counter at all
+	DataWatchpointCTRLCycCntEna      = 0x1
+	DataWatchpointCYCCNT             = 0xE0001004
+	DataWatchpointComparator0        = 0xE0001020
+	DataWatchpointComparatorStride   = 0x10
+	DataWatchpointMaskOffset         = 0x4 // ARMv7-M only
+	DataWatchpointMaskMask           = 0x1F
+	DataWatchpointFunctionOffset     = 0x8
+	DataWatchpointFunctionMatched    = 0x1000000
+	DataWatchpointDEVARCHRegister    = 0xE0001FBC
+	DataWatchpointDEVARCHPresentMask = 0x100000 // Only populated on ARMv8-M
+	DataWatchpointPCSR               = 0xE000101C
+	DataWatchpointPCSRUnavailable    = 0xFFFFFFFF // halted, in reset or lockup, or not implemented
+
+	// ARMv7-M FUNCTION values generating a debug event on a data address match
+	DataWatchpointV7FunctionRead   = 0x5
+	DataWatchpointV7FunctionWrite  = 0x6
+	DataWatchpointV7FunctionAccess = 0x7
+
+	// ARMv8-M FUNCTION MATCH values, ACTION and DATAVSIZE fields
+	DataWatchpointV8MatchAccess   = 0x4
+	DataWatchpointV8MatchWrite    = 0x5
+	DataWatchpointV8MatchRead     = 0x6
+	DataWatchpointV8MatchLimit    = 0x7
+	DataWatchpointV8ActionDebug   = 0x10
+	DataWatchpointV8DataVSizePos  = 10
+	DataWatchpointV8DataVSizeByte = 0x0
+	DataWatchpointV8DataVSizeHalf = 0x1
+	DataWatchpointV8DataVSizeWord = 0x2
+)
+
+const (
+	DebugFaultStatusRegister = 0xE000ED30
+	DebugFaultStatusHalted   = 0x1
+	DebugFaultStatusBKPT     = 0x2
+	DebugFaultStatusDWTTrap  = 0x4
+	DebugFaultStatusVCatch   = 0x8
+	DebugFaultStatusExternal = 0x10
+)
+
+const (
+	DebugHaltingControlStatusRegister = 0xE000EDF0
+	DebugHaltingControlStatusKey      = 0xA05F0000
+	DebugHaltingControlStatusEnable   = 0x1
+	DebugHaltingControlStatusHalt     = 0x2
+	DebugHaltingControlStatusStep     = 0x4
+	DebugHaltingControlStatusMaskInts = 0x8
+	DebugHaltingControlStatusRegReady = 0x10000
+	DebugHaltingControlStatusHalted   = 0x20000
+	DebugHaltingControlStatusSleeping = 0x40000
+	DebugHaltingControlStatusLockup   = 0x80000
+	DebugHaltingControlStatusRetired  = 0x1000000
+	DebugHaltingControlStatusReset    = 0x2000000
+)
+
+const (
+	DebugCoreRegisterSelectorRegister = 0xE000EDF4
+	DebugCoreRegisterSelectorWrite    = 0x10000
+	DebugCoreRegisterDataRegister     = 0xE000EDF8
+)
+
+const (
+	DebugExceptionMonitorControlRegister = 0xE000EDFC
+	DebugExceptionMonitorVCCoreReset     = 0x1
+	DebugExceptionMonitorVCMMErr         = 0x10 // Mainline only from here to VCSFErr
+	DebugExceptionMonitorVCNoCPErr       = 0x20
+	DebugExceptionMonitorVCChkErr        = 0x40
+	DebugExceptionMonitorVCStatErr       = 0x80
+	DebugExceptionMonitorVCBusErr        = 0x100
+	DebugExceptionMonitorVCIntErr        = 0x200
+	DebugExceptionMonitorVCHardErr       = 0x400
+	DebugExceptionMonitorVCSFErr         = 0x800 // ARMv8-M Security Extension only
+	DebugExceptionMonitorVCMask          = 0xFF0 // every vector catch but VC_CORERESET, which ResetTarget owns
+	DebugExceptionMonitorTraceEnable     = 0x1000000
+)
+
+const (
+	ApplicationInterruptResetControlRegister = 0xE000ED0C
+	ApplicationInterruptResetControlKey      = 0x05FA0000
+	ApplicationInterruptResetVectReset       = 0x1
+	ApplicationInterruptResetSysResetReq     = 0x4
+)
+
+const (
+	MediaAndFPFeatureRegister0 = 0xE000EF40
+)
+
+const (
+	ProcessorFeatureRegister1    = 0xE000ED44
+	ProcessorFeatureSecurityMask = 0xF0 // ARMv8-M only, populated when the Security Extension is implemented
+)
+
+// ARMv8-M Security Extension debug registers
+const (
+	DebugAuthenticationControlRegister   = 0xE000EE04
+	DebugAuthenticationControlSPIDENSel  = 0x1
+	DebugAuthenticationControlIntSPIDEN  = 0x2
+	DebugAuthenticationControlSPNIDENSel = 0x4
+	DebugAuthenticationControlIntSPNIDEN = 0x8
+
+	DebugSecurityControlStatusRegister = 0xE000EE08
+	DebugSecurityControlStatusSBRSelEn = 0x1
+	DebugSecurityControlStatusSBRSel   = 0x2
+	DebugSecurityControlStatusCDS      = 0x10000 // Current domain is Secure
+	DebugSecurityControlStatusCDSKey   = 0x20000
+
+	// DAUTHSTATUS has a two bit field per kind of debug
+	DebugAuthenticationStatusRegister  = 0xE000EFB8
+	DebugAuthenticationStatusNSIDPos   = 0
+	DebugAuthenticationStatusNSNIDPos  = 2
+	DebugAuthenticationStatusSIDPos    = 4
+	DebugAuthenticationStatusSNIDPos   = 6
+	DebugAuthenticationStatusFieldMask = 0x3
+	DebugAuthenticationStatusDisabled  = 0x2
+	DebugAuthenticationStatusEnabled   = 0x3
+)
+
+// MemoryAccess is the word access the FPB and DWT program their registers through.
+type MemoryAccess interface {
+	ReadAddr32(addr uint32, count int) ([]uint32, error)
+	WriteAddr32(addr, value uint32) error
+	WriteSeqAddr32(addr uint32, value []uint32) error
+}
+
+// DAPTransferCoreAccess drives the Cortex-M debug registers through the AHB-AP.
+type DAPTransferCoreAccess struct {
+	adi.MemAP
+}
+
+// New returns a DAPTransferCoreAccess talking to the core through t.
+func New(t adi.DAPTransferer) *DAPTransferCoreAccess {
+	return &DAPTransferCoreAccess{MemAP: adi.MemAP{DAPTransferer: t}}
+}
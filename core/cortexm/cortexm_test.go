@@ -0,0 +1,346 @@
+package cortexm
+
+import (
+	"strings"
+	"testing"
+
+	"goocd/probes/simprobe"
+)
+
+func TestDAPTransferCoreAccess_CoreRegisters(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.Core.Registers[PC] = 0x1234
+	sim.Core.Registers[CFBP] = 0x02000001
+	core := New(sim)
+
+	if _, err := core.ReadCoreRegister(PC); err != ErrNotHalted {
+		t.Fatalf("expected ErrNotHalted, got %v", err)
+	}
+	if err := core.Halt(); err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := core.ReadCoreRegister(PC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc != 0x1234 {
+		t.Fatalf("expected pc 0x1234, got 0x%x", pc)
+	}
+
+	if err := core.WriteCoreRegister(BASEPRI, 0x40); err != nil {
+		t.Fatal(err)
+	}
+	if got := sim.Core.Registers[CFBP]; got != 0x02004001 {
+		t.Fatalf("expected cfbp 0x02004001, got 0x%x", got)
+	}
+	control, err := core.ReadCoreRegister(CONTROL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if control != 0x2 {
+		t.Fatalf("expected control 0x2, got 0x%x", control)
+	}
+
+	if r, err := ParseRegister("S31"); err != nil || r != S0+31 {
+		t.Fatalf("expected s31, got %v %v", r, err)
+	}
+}
+
+func TestDAPTransferCoreAccess_RunControl(t *testing.T) {
+	sim := &simprobe.Probe{}
+	core := New(sim)
+
+	if err := core.Step(false); err == nil {
+		t.Fatalf("expected step on a running core to fail")
+	}
+	if err := core.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := core.Status(); state != StateHalted {
+		t.Fatalf("expected halted, got %s", state)
+	}
+
+	sim.Core.Registers[PC] = 0x100
+	if err := core.Step(true); err != nil {
+		t.Fatal(err)
+	}
+	if sim.Core.Steps != 1 || sim.Core.Registers[PC] != 0x102 || !sim.Core.Halted {
+		t.Fatalf("expected a single step to 0x102, got %d steps to 0x%x", sim.Core.Steps, sim.Core.Registers[PC])
+	}
+
+	if err := core.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	sim.Core.Sleeping = true
+	if state, _ := core.Status(); state != StateSleeping {
+		t.Fatalf("expected sleeping, got %s", state)
+	}
+}
+
+func TestDAPTransferCoreAccess_ResetTarget(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.WriteWord(0, 0x20004000)
+	sim.WriteWord(4, 0x00000411)
+	core := New(sim)
+
+	for _, strategy := range []ResetStrategy{ResetHardware, ResetSystem, ResetVector} {
+		if err := core.Resume(); err != nil {
+			t.Fatal(err)
+		}
+		if err := core.ResetTarget(strategy, true); err != nil {
+			t.Fatalf("%s: %v", strategy, err)
+		}
+		pc, err := core.ReadCoreRegister(PC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pc != 0x410 {
+			t.Fatalf("%s: expected halt at reset handler 0x410, got 0x%x", strategy, pc)
+		}
+		demcr, _ := core.ReadAddr32(DebugExceptionMonitorControlRegister, 1)
+		if demcr[0]&DebugExceptionMonitorVCCoreReset > 0 {
+			t.Fatalf("%s: expected VC_CORERESET to be disarmed", strategy)
+		}
+	}
+
+	if err := core.ResetTarget(ResetSystem, false); err != nil {
+		t.Fatal(err)
+	}
+	if sim.Core.Halted {
+		t.Fatalf("expected core to run after a reset without halt")
+	}
+}
+
+func TestFPB(t *testing.T) {
+	for _, tc := range []struct {
+		ctrl uint32
+		addr uint32
+		comp uint32
+	}{
+		{ctrl: 0x00000260, addr: 0x00000412, comp: 0x80000411},
+		{ctrl: 0x00000260, addr: 0x00000410, comp: 0x40000411},
+		{ctrl: 0x10001080, addr: 0x20000412, comp: 0x20000413},
+	} {
+		sim := &simprobe.Probe{}
+		sim.WriteWord(FlashPatchCTRLRegister, tc.ctrl)
+		fpb := &FPB{MemoryAccess: New(sim)}
+		if err := fpb.Configure(); err != nil {
+			t.Fatal(err)
+		}
+		if err := fpb.SetBreakpoint(tc.addr); err != nil {
+			t.Fatal(err)
+		}
+		if got := sim.ReadWord(FlashPatchComparator0); got != tc.comp {
+			t.Fatalf("ctrl 0x%x: expected comparator 0x%x, got 0x%x", tc.ctrl, tc.comp, got)
+		}
+		if err := fpb.ClearBreakpoint(tc.addr); err != nil {
+			t.Fatal(err)
+		}
+		if got := sim.ReadWord(FlashPatchComparator0); got != 0 || len(fpb.Breakpoints()) != 0 {
+			t.Fatalf("expected breakpoint to be cleared")
+		}
+	}
+
+	sim := &simprobe.Probe{}
+	sim.WriteWord(FlashPatchCTRLRegister, 0x00000020)
+	fpb := &FPB{MemoryAccess: New(sim)}
+	if err := fpb.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	if fpb.NumCode != 2 {
+		t.Fatalf("expected 2 comparators, got %d", fpb.NumCode)
+	}
+	if err := fpb.SetBreakpoint(0x20000000); err == nil {
+		t.Fatalf("expected FPBv1 to reject an address outside the code region")
+	}
+	_ = fpb.SetBreakpoint(0x100)
+	_ = fpb.SetBreakpoint(0x200)
+	if err := fpb.SetBreakpoint(0x300); err == nil {
+		t.Fatalf("expected an error once all comparators are used")
+	}
+}
+
+func TestDWT(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.WriteWord(DataWatchpointCTRLRegister, 0x40000000)
+	dwt := &DWT{MemoryAccess: New(sim)}
+	if err := dwt.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	if dwt.NumComp != 4 || dwt.V8M {
+		t.Fatalf("expected 4 ARMv7-M comparators, got %d v8m=%t", dwt.NumComp, dwt.V8M)
+	}
+
+	if _, err := dwt.SetWatchpoint(0x20000004, 8, WatchWrite); err == nil {
+		t.Fatalf("expected a misaligned block to be rejected")
+	}
+	wp, err := dwt.SetWatchpoint(0x20000010, 16, WatchWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := comparatorAddr(wp.Comparator)
+	if sim.ReadWord(base) != 0x20000010 || sim.ReadWord(base+DataWatchpointMaskOffset) != 4 || sim.ReadWord(base+DataWatchpointFunctionOffset) != DataWatchpointV7FunctionWrite {
+		t.Fatalf("unexpected ARMv7-M comparator setup")
+	}
+
+	sim.WriteWord(base+DataWatchpointFunctionOffset, DataWatchpointV7FunctionWrite|DataWatchpointFunctionMatched)
+	fired, err := dwt.Fired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fired) != 1 || fired[0] != wp {
+		t.Fatalf("expected the watchpoint to have fired")
+	}
+
+	sim.WriteWord(DataWatchpointDEVARCHRegister, 0x47701A02)
+	if err := dwt.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	if !dwt.V8M {
+		t.Fatalf("expected an ARMv8-M DWT")
+	}
+	if _, err := dwt.SetWatchpoint(0x20000002, 2, WatchRead); err != nil {
+		t.Fatal(err)
+	}
+	wp, err = dwt.SetWatchpoint(0x20000100, 0x30, WatchAccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wp.Comparator != 2 {
+		t.Fatalf("expected the range to start at comparator 2, got %d", wp.Comparator)
+	}
+	limit := comparatorAddr(3)
+	if sim.ReadWord(limit) != 0x2000012F || sim.ReadWord(limit+DataWatchpointFunctionOffset) != DataWatchpointV8MatchLimit|DataWatchpointV8ActionDebug {
+		t.Fatalf("unexpected ARMv8-M limit comparator setup")
+	}
+	if err := dwt.ClearAll(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDWT_CycleCounter(t *testing.T) {
+	sim := &simprobe.Probe{}
+	dwt := &DWT{MemoryAccess: New(sim)}
+	if err := dwt.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dwt.EnableCycleCounter(); err != nil {
+		t.Fatal(err)
+	}
+	if sim.ReadWord(DataWatchpointCTRLRegister)&DataWatchpointCTRLCycCntEna == 0 {
+		t.Fatalf("expected CYCCNTENA to be set")
+	}
+	sim.WriteWord(DataWatchpointCYCCNT, 1234)
+	if cycles, err := dwt.CycleCount(); err != nil || cycles != 1234 {
+		t.Fatalf("expected 1234 cycles, got %d %v", cycles, err)
+	}
+	if err := dwt.ResetCycleCount(); err != nil || sim.ReadWord(DataWatchpointCYCCNT) != 0 {
+		t.Fatalf("expected the cycle counter to be zeroed, got %v", err)
+	}
+
+	sim.WriteWord(DataWatchpointCTRLRegister, DataWatchpointCTRLNoCycCnt)
+	if err := dwt.EnableCycleCounter(); err == nil {
+		t.Fatalf("expected NOCYCCNT to be an error")
+	}
+}
+
+func TestDAPTransferCoreAccess_Security(t *testing.T) {
+	sim := &simprobe.Probe{}
+	core := New(sim)
+	if err := core.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := core.SecurityState(); err != nil || state != SecurityNone {
+		t.Fatalf("expected no security state without the Security Extension, got %s %v", state, err)
+	}
+
+	sim.WriteWord(ProcessorFeatureRegister1, 0x10)
+	sim.WriteWord(DebugSecurityControlStatusRegister, DebugSecurityControlStatusCDS|DebugSecurityControlStatusCDSKey)
+	sim.WriteWord(DebugAuthenticationStatusRegister, 0xEF) // Secure invasive debug disabled
+	if state, err := core.SecurityState(); err != nil || state != SecuritySecure {
+		t.Fatalf("expected secure, got %s %v", state, err)
+	}
+
+	auth, err := core.DebugAuthentication()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.SecureInvasive || !auth.SecureNonInvasive || !auth.NonSecureInvasive {
+		t.Fatalf("unexpected debug authentication %+v", auth)
+	}
+	if _, err := core.ReadCoreRegister(MSP_S); err != ErrSecureDebugDisabled {
+		t.Fatalf("expected ErrSecureDebugDisabled, got %v", err)
+	}
+	if _, err := core.ReadCoreRegister(MSP_NS); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := core.SetSecurityState(SecurityNonSecure); err != nil {
+		t.Fatal(err)
+	}
+	if got := sim.ReadWord(DebugSecurityControlStatusRegister); got != 0 {
+		t.Fatalf("expected CDS cleared with a zero CDSKEY, got 0x%x", got)
+	}
+	if err := core.SetSecurityState(SecuritySecure); err != ErrSecureDebugDisabled {
+		t.Fatalf("expected ErrSecureDebugDisabled, got %v", err)
+	}
+}
+
+func TestFaultAnalysis(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.WriteWord(CPUIDRegister, 0x410FC241)
+	sim.WriteWord(ConfigurableFaultStatusRegister, FaultStatusPreciseErr|FaultStatusBFARValid|FaultStatusDivByZero)
+	sim.WriteWord(HardFaultStatusRegister, HardFaultStatusForced)
+	sim.WriteWord(DebugFaultStatusRegister, DebugFaultStatusVCatch)
+	sim.WriteWord(BusFaultAddressRegister, 0x60000000)
+	core := New(sim)
+	if err := core.Halt(); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := ReadFaultStatus(core)
+	if err != nil {
+		t.Fatal(err)
+	}
+	causes := status.Causes()
+	want := []string{"(FORCED)", "(PRECISERR)", "(DIVBYZERO)", "0x60000000 (BFARVALID)", "(VCATCH)"}
+	if len(causes) != len(want) {
+		t.Fatalf("expected %d causes, got %q", len(want), causes)
+	}
+	for i, w := range want {
+		if !strings.HasSuffix(causes[i], w) {
+			t.Fatalf("expected cause %d to end with %q, got %q", i, w, causes[i])
+		}
+	}
+
+	// Halted on HardFault entry, the frame was pushed to PSP with a realignment word
+	sim.Core.Registers[XPSR] = 3
+	sim.Core.Registers[LR] = 0xFFFFFFFD
+	sim.Core.Registers[PSP] = 0x20001000
+	sim.WriteWord(0x20001014, 0x08000413)
+	sim.WriteWord(0x20001018, 0x08000520)
+	sim.WriteWord(0x2000101C, 0x01000200)
+	frame, err := UnwindExceptionFrame(core)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ExceptionName(frame.Exception) != "HardFault" || frame.Stack != PSP || frame.PC != 0x08000520 || frame.LR != 0x08000413 || frame.CallerSP != 0x20001024 {
+		t.Fatalf("unexpected frame %+v", frame)
+	}
+
+	sim.Core.Registers[LR] = 0x08000413
+	if _, err := UnwindExceptionFrame(core); err == nil {
+		t.Fatalf("expected an error once LR no longer holds EXC_RETURN")
+	}
+
+	// ARMv6-M only has DFSR
+	sim.WriteWord(CPUIDRegister, 0x410CC601)
+	status, err = ReadFaultStatus(core)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if causes := status.Causes(); len(causes) != 1 {
+		t.Fatalf("expected only the DFSR cause on ARMv6-M, got %q", causes)
+	}
+}
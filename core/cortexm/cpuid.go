@@ -0,0 +1,94 @@
+package cortexm
+
+import (
+	"fmt"
+)
+
+const (
+	CPUIDRegister         = 0xE000ED00
+	CPUIDImplementerMask  = 0xFF000000
+	CPUIDImplementerPos   = 24
+	CPUIDVariantMask      = 0xF00000
+	CPUIDVariantPos       = 20
+	CPUIDArchitectureMask = 0xF0000
+	CPUIDArchitecturePos  = 16
+	CPUIDPartNoMask       = 0xFFF0
+	CPUIDPartNoPos        = 4
+	CPUIDRevisionMask     = 0xF
+
+	CPUIDImplementerARM = 0x41
+
+	CPUIDArchitectureBaseline = 0xC // ARMv6-M and ARMv8-M Baseline
+	CPUIDArchitectureMainline = 0xF // ARMv7-M and ARMv8-M Mainline
+)
+
+// PartNumber is the CPUID PARTNO of an ARM implemented core.
+type PartNumber uint16
+
+const (
+	PartCortexM0     PartNumber = 0xC20
+	PartCortexM0Plus PartNumber = 0xC60
+	PartCortexM3     PartNumber = 0xC23
+	PartCortexM4     PartNumber = 0xC24
+	PartCortexM7     PartNumber = 0xC27
+	PartCortexM23    PartNumber = 0xD20
+	PartCortexM33    PartNumber = 0xD21
+)
+
+var partNames = map[PartNumber]string{
+	PartCortexM0:     "Cortex-M0",
+	PartCortexM0Plus: "Cortex-M0+",
+	PartCortexM3:     "Cortex-M3",
+	PartCortexM4:     "Cortex-M4",
+	PartCortexM7:     "Cortex-M7",
+	PartCortexM23:    "Cortex-M23",
+	PartCortexM33:    "Cortex-M33",
+}
+
+func (p PartNumber) String() string {
+	if name, ok := partNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("part 0x%03x", uint16(p))
+}
+
+// CPUID is the decoded CPUID register, identifying the core and its revision.
+type CPUID struct {
+	Implementer  uint8
+	Variant      uint8 // the r of rNpM
+	Architecture uint8
+	PartNo       PartNumber
+	Revision     uint8 // the p of rNpM
+}
+
+// DecodeCPUID splits a CPUID register value into its fields.
+func DecodeCPUID(value uint32) CPUID {
+	return CPUID{
+		Implementer:  uint8((value & CPUIDImplementerMask) >> CPUIDImplementerPos),
+		Variant:      uint8((value & CPUIDVariantMask) >> CPUIDVariantPos),
+		Architecture: uint8((value & CPUIDArchitectureMask) >> CPUIDArchitecturePos),
+		PartNo:       PartNumber((value & CPUIDPartNoMask) >> CPUIDPartNoPos),
+		Revision:     uint8(value & CPUIDRevisionMask),
+	}
+}
+
+// IsARM is true when ARM implemented the core, the only case where PartNo names a Cortex-M.
+func (c CPUID) IsARM() bool {
+	return c.Implementer == CPUIDImplementerARM
+}
+
+func (c CPUID) String() string {
+	if !c.IsARM() {
+		return fmt.Sprintf("implementer 0x%02x part 0x%03x r%dp%d", c.Implementer, uint16(c.PartNo), c.Variant, c.Revision)
+	}
+	return fmt.Sprintf("%s r%dp%d", c.PartNo, c.Variant, c.Revision)
+}
+
+// ReadCPUID reads and decodes the CPUID register, it is accessible whether or not the core is halted.
+func (d *DAPTransferCoreAccess) ReadCPUID() (CPUID, error) {
+	vals, err := d.ReadAddr32(CPUIDRegister, 1)
+	if err != nil {
+		return CPUID{}, err
+	}
+	return DecodeCPUID(vals[0]), nil
+}
@@ -0,0 +1,42 @@
+package cortexm
+
+import "fmt"
+
+// EnableCycleCounter starts the DWT cycle counter, which counts core clock cycles while the core isn't halted.
+// Call it after Configure, which enables trace.
+func (w *DWT) EnableCycleCounter() error {
+	vals, err := w.ReadAddr32(DataWatchpointCTRLRegister, 1)
+	if err != nil {
+		return err
+	}
+	if vals[0]&DataWatchpointCTRLNoCycCnt > 0 {
+		return fmt.Errorf("error: DWT.EnableCycleCounter() the DWT has no cycle counter")
+	}
+	err = w.WriteAddr32(DataWatchpointCTRLRegister, vals[0]|DataWatchpointCTRLCycCntEna)
+	if err != nil {
+		return err
+	}
+	// ARMv6-M has no CYCCNTENA bit, it reads as zero
+	vals, err = w.ReadAddr32(DataWatchpointCTRLRegister, 1)
+	if err != nil {
+		return err
+	}
+	if vals[0]&DataWatchpointCTRLCycCntEna == 0 {
+		return fmt.Errorf("error: DWT.EnableCycleCounter() the DWT has no cycle counter")
+	}
+	return nil
+}
+
+// CycleCount reads the cycle counter, it wraps around at 2^32.
+func (w *DWT) CycleCount() (uint32, error) {
+	vals, err := w.ReadAddr32(DataWatchpointCYCCNT, 1)
+	if err != nil {
+		return 0, err
+	}
+	return vals[0], nil
+}
+
+// ResetCycleCount zeroes the cycle counter.
+func (w *DWT) ResetCycleCount() error {
+	return w.WriteAddr32(DataWatchpointCYCCNT, 0)
+}
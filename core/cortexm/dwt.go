@@ -0,0 +1,294 @@
+package cortexm
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// WatchpointKind is what kind of data access triggers a watchpoint.
+type WatchpointKind int
+
+const (
+	WatchAccess = WatchpointKind(iota)
+	WatchRead
+	WatchWrite
+)
+
+func (k WatchpointKind) String() string {
+	switch k {
+	case WatchAccess:
+		return "rw"
+	case WatchRead:
+		return "r"
+	case WatchWrite:
+		return "w"
+	}
+	return fmt.Sprintf("WatchpointKind(%d)", int(k))
+}
+
+// ParseWatchpointKind is the inverse of WatchpointKind.String.
+func ParseWatchpointKind(s string) (WatchpointKind, error) {
+	for _, k := range []WatchpointKind{WatchAccess, WatchRead, WatchWrite} {
+		if strings.EqualFold(s, k.String()) {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("error: unknown watchpoint kind %q, expected rw, r or w", s)
+}
+
+// Watchpoint is a data address watch set through DWT comparators.
+type Watchpoint struct {
+	Addr       uint32
+	Size       uint32
+	Kind       WatchpointKind
+	Comparator int // first comparator used, ARMv8-M ranges use the next one too
+}
+
+// DWT drives the Data Watchpoint and Trace unit comparators to set watchpoints.
+// ARMv7-M comparators watch a naturally aligned power of two sized block through MASK,
+// ARMv8-M comparators watch a single byte, halfword or word, or a range using two comparators.
+type DWT struct {
+	MemoryAccess
+
+	NumComp int
+	V8M     bool   // ARMv8-M DWT, detected through DEVARCH
+	MaxMask uint32 // ARMv7-M, the widest MASK the comparators implement, it differs between cores
+
+	watchpoints []*Watchpoint // per comparator, both comparators of a range point at the same watchpoint
+}
+
+// Configure enables trace (the DWT doesn't work without DEMCR TRCENA), discovers the comparators and disables them all.
+func (w *DWT) Configure() error {
+	vals, err := w.ReadAddr32(DebugExceptionMonitorControlRegister, 1)
+	if err != nil {
+		return err
+	}
+	err = w.WriteAddr32(DebugExceptionMonitorControlRegister, vals[0]|DebugExceptionMonitorTraceEnable)
+	if err != nil {
+		return err
+	}
+
+	vals, err = w.ReadAddr32(DataWatchpointCTRLRegister, 1)
+	if err != nil {
+		return err
+	}
+	w.NumComp = int((vals[0] & DataWatchpointCTRLNumCompMask) >> DataWatchpointCTRLNumCompPos)
+
+	vals, err = w.ReadAddr32(DataWatchpointDEVARCHRegister, 1)
+	if err != nil {
+		return err
+	}
+	w.V8M = vals[0]&DataWatchpointDEVARCHPresentMask > 0
+
+	w.watchpoints = make([]*Watchpoint, w.NumComp)
+	for i := 0; i < w.NumComp; i++ {
+		err = w.WriteAddr32(comparatorAddr(i)+DataWatchpointFunctionOffset, 0)
+		if err != nil {
+			return err
+		}
+	}
+	if w.V8M || w.NumComp == 0 {
+		return nil
+	}
+
+	// MASK reads back the widest mask implemented after writing all ones to it
+	mask := comparatorAddr(0) + DataWatchpointMaskOffset
+	err = w.WriteAddr32(mask, DataWatchpointMaskMask)
+	if err != nil {
+		return err
+	}
+	vals, err = w.ReadAddr32(mask, 1)
+	if err != nil {
+		return err
+	}
+	w.MaxMask = vals[0] & DataWatchpointMaskMask
+	return w.WriteAddr32(mask, 0)
+}
+
+// SetWatchpoint watches size bytes at addr for the kind of access, halting the core when it happens.
+func (w *DWT) SetWatchpoint(addr, size uint32, kind WatchpointKind) (*Watchpoint, error) {
+	if size == 0 {
+		return nil, fmt.Errorf("error: DWT.SetWatchpoint() size must not be zero")
+	}
+	wp := &Watchpoint{Addr: addr, Size: size, Kind: kind}
+	if w.V8M {
+		return wp, w.setV8(wp)
+	}
+	return wp, w.setV7(wp)
+}
+
+func (w *DWT) setV7(wp *Watchpoint) error {
+	if bits.OnesCount32(wp.Size) != 1 || wp.Addr%wp.Size != 0 {
+		return fmt.Errorf("error: DWT watchpoint size %d must be a power of two with the address 0x%x aligned to it", wp.Size, wp.Addr)
+	}
+	if bits.TrailingZeros32(wp.Size) > int(w.MaxMask) {
+		return fmt.Errorf("error: DWT watchpoint size %d is larger than the %d bytes the comparators can mask", wp.Size, uint32(1)<<w.MaxMask)
+	}
+	comp, err := w.free(1)
+	if err != nil {
+		return err
+	}
+
+	function := uint32(DataWatchpointV7FunctionAccess)
+	switch wp.Kind {
+	case WatchRead:
+		function = DataWatchpointV7FunctionRead
+	case WatchWrite:
+		function = DataWatchpointV7FunctionWrite
+	}
+
+	base := comparatorAddr(comp)
+	err = w.WriteAddr32(base, wp.Addr)
+	if err != nil {
+		return err
+	}
+	err = w.WriteAddr32(base+DataWatchpointMaskOffset, uint32(bits.TrailingZeros32(wp.Size)))
+	if err != nil {
+		return err
+	}
+	err = w.WriteAddr32(base+DataWatchpointFunctionOffset, function)
+	if err != nil {
+		return err
+	}
+	wp.Comparator = comp
+	w.watchpoints[comp] = wp
+	return nil
+}
+
+func (w *DWT) setV8(wp *Watchpoint) error {
+	match := uint32(DataWatchpointV8MatchAccess)
+	switch wp.Kind {
+	case WatchRead:
+		match = DataWatchpointV8MatchRead
+	case WatchWrite:
+		match = DataWatchpointV8MatchWrite
+	}
+
+	var dataVSize uint32
+	single := wp.Addr%wp.Size == 0
+	switch wp.Size {
+	case 1:
+		dataVSize = DataWatchpointV8DataVSizeByte
+	case 2:
+		dataVSize = DataWatchpointV8DataVSizeHalf
+	case 4:
+		dataVSize = DataWatchpointV8DataVSizeWord
+	default:
+		single = false
+	}
+
+	if single {
+		comp, err := w.free(1)
+		if err != nil {
+			return err
+		}
+		base := comparatorAddr(comp)
+		err = w.WriteAddr32(base, wp.Addr)
+		if err != nil {
+			return err
+		}
+		err = w.WriteAddr32(base+DataWatchpointFunctionOffset, match|DataWatchpointV8ActionDebug|dataVSize<<DataWatchpointV8DataVSizePos)
+		if err != nil {
+			return err
+		}
+		wp.Comparator = comp
+		w.watchpoints[comp] = wp
+		return nil
+	}
+
+	// Address range, the first comparator holds the base and the next one the limit
+	comp, err := w.free(2)
+	if err != nil {
+		return err
+	}
+	base := comparatorAddr(comp)
+	err = w.WriteAddr32(base, wp.Addr)
+	if err != nil {
+		return err
+	}
+	err = w.WriteAddr32(base+DataWatchpointComparatorStride, wp.Addr+wp.Size-1)
+	if err != nil {
+		return err
+	}
+	err = w.WriteAddr32(base+DataWatchpointFunctionOffset, match|DataWatchpointV8ActionDebug)
+	if err != nil {
+		return err
+	}
+	err = w.WriteAddr32(base+DataWatchpointComparatorStride+DataWatchpointFunctionOffset, DataWatchpointV8MatchLimit|DataWatchpointV8ActionDebug)
+	if err != nil {
+		return err
+	}
+	wp.Comparator = comp
+	w.watchpoints[comp] = wp
+	w.watchpoints[comp+1] = wp
+	return nil
+}
+
+// ClearWatchpoint disables the comparators used by wp.
+func (w *DWT) ClearWatchpoint(wp *Watchpoint) error {
+	for i := range w.watchpoints {
+		if w.watchpoints[i] != wp {
+			continue
+		}
+		err := w.WriteAddr32(comparatorAddr(i)+DataWatchpointFunctionOffset, 0)
+		if err != nil {
+			return err
+		}
+		w.watchpoints[i] = nil
+	}
+	return nil
+}
+
+// ClearAll disables every comparator used for a watchpoint.
+func (w *DWT) ClearAll() error {
+	for _, wp := range w.watchpoints {
+		if wp == nil {
+			continue
+		}
+		err := w.ClearWatchpoint(wp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fired returns the watchpoints whose comparators matched since the last call, reading FUNCTION clears MATCHED.
+func (w *DWT) Fired() ([]*Watchpoint, error) {
+	var fired []*Watchpoint
+	for i, wp := range w.watchpoints {
+		if wp == nil {
+			continue
+		}
+		vals, err := w.ReadAddr32(comparatorAddr(i)+DataWatchpointFunctionOffset, 1)
+		if err != nil {
+			return nil, err
+		}
+		if vals[0]&DataWatchpointFunctionMatched > 0 && (len(fired) == 0 || fired[len(fired)-1] != wp) {
+			fired = append(fired, wp)
+		}
+	}
+	return fired, nil
+}
+
+// free finds n consecutive unused comparators, ranges need an even first comparator to pair with the next.
+func (w *DWT) free(n int) (int, error) {
+	for i := 0; i+n <= len(w.watchpoints); i++ {
+		if n > 1 && i%2 != 0 {
+			continue
+		}
+		ok := true
+		for j := i; j < i+n; j++ {
+			ok = ok && w.watchpoints[j] == nil
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("error: DWT has no %d free comparators out of %d", n, w.NumComp)
+}
+
+func comparatorAddr(i int) uint32 {
+	return DataWatchpointComparator0 + uint32(i)*DataWatchpointComparatorStride
+}
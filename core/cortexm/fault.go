@@ -0,0 +1,343 @@
+package cortexm
+
+import (
+	"fmt"
+)
+
+// Fault status and address registers, the configurable fault ones only exist on ARMv7-M and ARMv8-M Mainline
+const (
+	ConfigurableFaultStatusRegister = 0xE000ED28
+	HardFaultStatusRegister         = 0xE000ED2C
+	MemManageFaultAddressRegister   = 0xE000ED34
+	BusFaultAddressRegister         = 0xE000ED38
+	AuxiliaryFaultStatusRegister    = 0xE000ED3C // Implementation defined
+	SecureFaultStatusRegister       = 0xE000EDE4 // ARMv8-M Mainline with the Security Extension only
+	SecureFaultAddressRegister      = 0xE000EDE8
+
+	// MMFSR, CFSR[7:0]
+	FaultStatusIAccViol  = 0x1
+	FaultStatusDAccViol  = 0x2
+	FaultStatusMUnstkErr = 0x8
+	FaultStatusMStkErr   = 0x10
+	FaultStatusMLSPErr   = 0x20
+	FaultStatusMMARValid = 0x80
+	// BFSR, CFSR[15:8]
+	FaultStatusIBusErr      = 0x100
+	FaultStatusPreciseErr   = 0x200
+	FaultStatusImpreciseErr = 0x400
+	FaultStatusUnstkErr     = 0x800
+	FaultStatusStkErr       = 0x1000
+	FaultStatusLSPErr       = 0x2000
+	FaultStatusBFARValid    = 0x8000
+	// UFSR, CFSR[31:16]
+	FaultStatusUndefInstr = 0x10000
+	FaultStatusInvState   = 0x20000
+	FaultStatusInvPC      = 0x40000
+	FaultStatusNoCP       = 0x80000
+	FaultStatusStkOF      = 0x100000 // ARMv8-M only
+	FaultStatusUnaligned  = 0x1000000
+	FaultStatusDivByZero  = 0x2000000
+
+	HardFaultStatusVectTbl  = 0x2
+	HardFaultStatusForced   = 0x40000000
+	HardFaultStatusDebugEvt = 0x80000000
+
+	SecureFaultStatusInvEP     = 0x1
+	SecureFaultStatusInvIS     = 0x2
+	SecureFaultStatusInvER     = 0x4
+	SecureFaultStatusAUViol    = 0x8
+	SecureFaultStatusInvTran   = 0x10
+	SecureFaultStatusLSPErr    = 0x20
+	SecureFaultStatusSFARValid = 0x40
+	SecureFaultStatusLSErr     = 0x80
+)
+
+// EXC_RETURN, the value LR holds on exception entry
+const (
+	ExcReturnPrefixMask = 0xFF000000
+	ExcReturnPrefix     = 0xFF000000
+	ExcReturnES         = 0x1  // ARMv8-M: exception taken to the Secure state
+	ExcReturnSPSel      = 0x4  // frame was pushed to PSP
+	ExcReturnMode       = 0x8  // returning to Thread mode
+	ExcReturnFType      = 0x10 // clear when the frame holds FP context
+	ExcReturnDCRS       = 0x20 // ARMv8-M: clear when the callee registers were pushed too
+	ExcReturnS          = 0x40 // ARMv8-M: frame was pushed to a Secure stack
+
+	IPSRMask           = 0x1FF
+	StackedXPSRSPAlign = 0x200 // the stack was realigned to 8 bytes on entry
+)
+
+// Exception frame sizes in bytes
+const (
+	BasicFrameSize           = 0x20
+	ExtendedFrameSize        = 0x68
+	AdditionalStateFrameSize = 0x28
+)
+
+type faultBit struct {
+	mask  uint32
+	name  string
+	cause string
+}
+
+var cfsrBits = []faultBit{
+	{FaultStatusIAccViol, "IACCVIOL", "MemManage: instruction fetch from a location the MPU or XN forbids"},
+	{FaultStatusDAccViol, "DACCVIOL", "MemManage: data access to a location the MPU forbids"},
+	{FaultStatusMUnstkErr, "MUNSTKERR", "MemManage: fault unstacking on exception return"},
+	{FaultStatusMStkErr, "MSTKERR", "MemManage: fault stacking on exception entry"},
+	{FaultStatusMLSPErr, "MLSPERR", "MemManage: fault during lazy FP state preservation"},
+	{FaultStatusIBusErr, "IBUSERR", "BusFault: bus error on instruction fetch"},
+	{FaultStatusPreciseErr, "PRECISERR", "BusFault: precise data bus error, the stacked PC is the faulting instruction"},
+	{FaultStatusImpreciseErr, "IMPRECISERR", "BusFault: imprecise data bus error, the stacked PC is past the faulting instruction"},
+	{FaultStatusUnstkErr, "UNSTKERR", "BusFault: bus error unstacking on exception return"},
+	{FaultStatusStkErr, "STKERR", "BusFault: bus error stacking on exception entry"},
+	{FaultStatusLSPErr, "LSPERR", "BusFault: bus error during lazy FP state preservation"},
+	{FaultStatusUndefInstr, "UNDEFINSTR", "UsageFault: undefined instruction"},
+	{FaultStatusInvState, "INVSTATE", "UsageFault: invalid EPSR state, e.g. a branch to an address without the Thumb bit"},
+	{FaultStatusInvPC, "INVPC", "UsageFault: invalid EXC_RETURN or PC load on exception return"},
+	{FaultStatusNoCP, "NOCP", "UsageFault: coprocessor (FPU) disabled or not present"},
+	{FaultStatusStkOF, "STKOF", "UsageFault: stack overflow, a stack limit register was crossed"},
+	{FaultStatusUnaligned, "UNALIGNED", "UsageFault: unaligned access"},
+	{FaultStatusDivByZero, "DIVBYZERO", "UsageFault: divide by zero"},
+}
+
+var hfsrBits = []faultBit{
+	{HardFaultStatusVectTbl, "VECTTBL", "HardFault: bus error reading the vector table"},
+	{HardFaultStatusForced, "FORCED", "HardFault: escalated from a configurable fault that is disabled or couldn't be taken"},
+	{HardFaultStatusDebugEvt, "DEBUGEVT", "HardFault: debug event, e.g. a BKPT, while halting debug was disabled"},
+}
+
+var dfsrBits = []faultBit{
+	{DebugFaultStatusHalted, "HALTED", "Debug: halt request or step"},
+	{DebugFaultStatusBKPT, "BKPT", "Debug: breakpoint"},
+	{DebugFaultStatusDWTTrap, "DWTTRAP", "Debug: watchpoint"},
+	{DebugFaultStatusVCatch, "VCATCH", "Debug: vector catch"},
+	{DebugFaultStatusExternal, "EXTERNAL", "Debug: external debug request"},
+}
+
+var sfsrBits = []faultBit{
+	{SecureFaultStatusInvEP, "INVEP", "SecureFault: Non-secure code branched to a Secure address that isn't a valid entry point"},
+	{SecureFaultStatusInvIS, "INVIS", "SecureFault: invalid integrity signature in the exception frame"},
+	{SecureFaultStatusInvER, "INVER", "SecureFault: invalid exception return"},
+	{SecureFaultStatusAUViol, "AUVIOL", "SecureFault: Non-secure access to Secure memory (attribution unit violation)"},
+	{SecureFaultStatusInvTran, "INVTRAN", "SecureFault: branch to Non-secure code without a BXNS or BLXNS"},
+	{SecureFaultStatusLSPErr, "LSPERR", "SecureFault: SAU or IDAU violation during lazy FP state preservation"},
+	{SecureFaultStatusLSErr, "LSERR", "SecureFault: lazy state activation or deactivation error"},
+}
+
+// FaultStatus holds the fault status and address registers of a core.
+type FaultStatus struct {
+	Mainline          bool // CFSR, HFSR, MMFAR, BFAR and AFSR are implemented
+	SecurityExtension bool // SFSR and SFAR are implemented, on Mainline
+	CFSR              uint32
+	HFSR              uint32
+	DFSR              uint32
+	MMFAR             uint32
+	BFAR              uint32
+	AFSR              uint32
+	SFSR              uint32
+	SFAR              uint32
+}
+
+// Causes decodes every bit set in the fault status registers into a human readable cause, along with the valid fault addresses.
+func (f FaultStatus) Causes() []string {
+	var causes []string
+	decode := func(value uint32, bits []faultBit) {
+		for _, b := range bits {
+			if value&b.mask > 0 {
+				causes = append(causes, fmt.Sprintf("%s (%s)", b.cause, b.name))
+			}
+		}
+	}
+
+	if f.Mainline {
+		decode(f.HFSR, hfsrBits)
+		decode(f.CFSR, cfsrBits)
+		if f.CFSR&FaultStatusMMARValid > 0 {
+			causes = append(causes, fmt.Sprintf("MemManage fault address: 0x%08x (MMARVALID)", f.MMFAR))
+		}
+		if f.CFSR&FaultStatusBFARValid > 0 {
+			causes = append(causes, fmt.Sprintf("BusFault address: 0x%08x (BFARVALID)", f.BFAR))
+		}
+		if f.AFSR != 0 {
+			causes = append(causes, fmt.Sprintf("Implementation defined auxiliary fault: 0x%08x (AFSR)", f.AFSR))
+		}
+		if f.SecurityExtension {
+			decode(f.SFSR, sfsrBits)
+			if f.SFSR&SecureFaultStatusSFARValid > 0 {
+				causes = append(causes, fmt.Sprintf("SecureFault address: 0x%08x (SFARVALID)", f.SFAR))
+			}
+		}
+	}
+	decode(f.DFSR, dfsrBits)
+	return causes
+}
+
+// CoreAccess is the halted core access the fault analysis needs, CPUID and ID_PFR1 tell which fault registers exist.
+type CoreAccess interface {
+	MemoryAccess
+	ReadCoreRegister(reg Register) (uint32, error)
+	HasSecurityExtension() (bool, error)
+	ReadCPUID() (CPUID, error)
+}
+
+// ReadFaultStatus reads the fault status and address registers the core implements, without clearing them.
+// ARMv6-M and ARMv8-M Baseline only have DFSR.  SFSR and SFAR read as zero unless Secure debug is allowed.
+func ReadFaultStatus(core CoreAccess) (FaultStatus, error) {
+	var f FaultStatus
+	id, err := core.ReadCPUID()
+	if err != nil {
+		return f, err
+	}
+	f.Mainline = id.Architecture == CPUIDArchitectureMainline
+	f.SecurityExtension, err = core.HasSecurityExtension()
+	if err != nil {
+		return f, err
+	}
+
+	vals, err := core.ReadAddr32(DebugFaultStatusRegister, 1)
+	if err != nil {
+		return f, err
+	}
+	f.DFSR = vals[0]
+	if !f.Mainline {
+		return f, nil
+	}
+
+	// CFSR, HFSR, DFSR, MMFAR, BFAR and AFSR are consecutive
+	vals, err = core.ReadAddr32(ConfigurableFaultStatusRegister, 6)
+	if err != nil {
+		return f, err
+	}
+	f.CFSR, f.HFSR, f.MMFAR, f.BFAR, f.AFSR = vals[0], vals[1], vals[3], vals[4], vals[5]
+	if !f.SecurityExtension {
+		return f, nil
+	}
+	vals, err = core.ReadAddr32(SecureFaultStatusRegister, 2)
+	if err != nil {
+		return f, err
+	}
+	f.SFSR, f.SFAR = vals[0], vals[1]
+	return f, nil
+}
+
+var exceptionNames = []string{
+	"Thread", "Reset", "NMI", "HardFault", "MemManage", "BusFault", "UsageFault", "SecureFault",
+	"Reserved(8)", "Reserved(9)", "Reserved(10)", "SVCall", "DebugMonitor", "Reserved(13)", "PendSV", "SysTick",
+}
+
+// ExceptionName names an IPSR exception number, 0 being Thread mode.
+func ExceptionName(n uint32) string {
+	if n < uint32(len(exceptionNames)) {
+		return exceptionNames[n]
+	}
+	return fmt.Sprintf("IRQ%d", n-16)
+}
+
+// ExceptionFrame is the state the core pushed on exception entry.
+type ExceptionFrame struct {
+	Exception uint32   // IPSR of the handler the core is halted in, set by UnwindExceptionFrame
+	ExcReturn uint32   // LR on entry to the handler
+	Stack     Register // stack pointer the frame was pushed to
+	Addr      uint32   // address of the basic frame
+	Extended  bool     // the frame holds FP context too
+	R0        uint32
+	R1        uint32
+	R2        uint32
+	R3        uint32
+	R12       uint32
+	LR        uint32
+	PC        uint32
+	XPSR      uint32
+	CallerSP  uint32 // stack pointer of the interrupted code, before the frame was pushed
+}
+
+// UnwindExceptionFrame reads the exception frame of the handler a halted core is in.  LR still has to hold EXC_RETURN,
+// as it does when the core is halted on handler entry by a vector catch, otherwise there is no telling where the frame went.
+func UnwindExceptionFrame(core CoreAccess) (ExceptionFrame, error) {
+	xpsr, err := core.ReadCoreRegister(XPSR)
+	if err != nil {
+		return ExceptionFrame{}, err
+	}
+	if xpsr&IPSRMask == 0 {
+		return ExceptionFrame{}, fmt.Errorf("error: UnwindExceptionFrame() core is in Thread mode, there is no exception frame")
+	}
+	excReturn, err := core.ReadCoreRegister(LR)
+	if err != nil {
+		return ExceptionFrame{}, err
+	}
+	sp, err := core.ReadCoreRegister(SP)
+	if err != nil {
+		return ExceptionFrame{}, err
+	}
+	frame, err := ReadExceptionFrame(core, excReturn, sp)
+	frame.Exception = xpsr & IPSRMask
+	return frame, err
+}
+
+// IsExcReturn reports if a return address is an EXC_RETURN value rather than code.
+func IsExcReturn(addr uint32) bool {
+	return addr&ExcReturnPrefixMask == ExcReturnPrefix
+}
+
+// ReadExceptionFrame reads the frame a handler returning with excReturn will unstack.  handlerSP is the stack pointer of
+// the handler once it has popped everything it pushed, used when the frame sits on the handler's own stack, otherwise
+// the frame is found through the banked stack pointer, which the handler leaves alone.
+func ReadExceptionFrame(core CoreAccess, excReturn, handlerSP uint32) (ExceptionFrame, error) {
+	frame := ExceptionFrame{ExcReturn: excReturn}
+	if !IsExcReturn(excReturn) {
+		return frame, fmt.Errorf("error: ReadExceptionFrame() LR 0x%08x is no longer EXC_RETURN, the handler has already used it", excReturn)
+	}
+
+	security, err := core.HasSecurityExtension()
+	if err != nil {
+		return frame, err
+	}
+	process := excReturn&ExcReturnSPSel > 0
+	secureStack := security && excReturn&ExcReturnS > 0
+	switch {
+	case !security && process:
+		frame.Stack = PSP
+	case !security:
+		frame.Stack = MSP
+	case secureStack && process:
+		frame.Stack = PSP_S
+	case secureStack:
+		frame.Stack = MSP_S
+	case process:
+		frame.Stack = PSP_NS
+	default:
+		frame.Stack = MSP_NS
+	}
+
+	// The handler runs on the main stack of the state it was taken to
+	sp := handlerSP
+	if process || (security && secureStack != (excReturn&ExcReturnES > 0)) {
+		sp, err = core.ReadCoreRegister(frame.Stack)
+		if err != nil {
+			return frame, err
+		}
+	}
+
+	frame.Addr = sp
+	if secureStack && excReturn&ExcReturnDCRS == 0 {
+		// The integrity signature and callee saved registers come first
+		frame.Addr += AdditionalStateFrameSize
+	}
+	vals, err := core.ReadAddr32(frame.Addr, 8)
+	if err != nil {
+		return frame, err
+	}
+	frame.R0, frame.R1, frame.R2, frame.R3 = vals[0], vals[1], vals[2], vals[3]
+	frame.R12, frame.LR, frame.PC, frame.XPSR = vals[4], vals[5], vals[6], vals[7]
+
+	frame.Extended = excReturn&ExcReturnFType == 0
+	frame.CallerSP = frame.Addr + BasicFrameSize
+	if frame.Extended {
+		frame.CallerSP = frame.Addr + ExtendedFrameSize
+	}
+	if frame.XPSR&StackedXPSRSPAlign > 0 {
+		frame.CallerSP += 4
+	}
+	return frame, nil
+}
@@ -0,0 +1,122 @@
+package cortexm
+
+import (
+	"fmt"
+)
+
+// FPB drives the Flash Patch and Breakpoint unit to set hardware breakpoints.
+type FPB struct {
+	MemoryAccess
+
+	Revision uint32 // 0 for FPBv1, 1 for FPBv2
+	NumCode  int    // number of instruction address comparators
+
+	breakpoints []uint32 // address per comparator
+	used        []bool
+}
+
+// Configure discovers the comparators, clears them all and enables the unit.
+func (f *FPB) Configure() error {
+	vals, err := f.ReadAddr32(FlashPatchCTRLRegister, 1)
+	if err != nil {
+		return err
+	}
+	ctrl := vals[0]
+	f.Revision = (ctrl & FlashPatchCTRLRevMask) >> FlashPatchCTRLRevPos
+	f.NumCode = int((ctrl&FlashPatchCTRLNumCodeHighMask)>>FlashPatchCTRLNumCodeHighPos<<4 | (ctrl&FlashPatchCTRLNumCodeLowMask)>>FlashPatchCTRLNumCodeLowPos)
+	if f.Revision > 1 {
+		return fmt.Errorf("error: FPB.Configure() unsupported FPB revision %d", f.Revision)
+	}
+
+	f.breakpoints = make([]uint32, f.NumCode)
+	f.used = make([]bool, f.NumCode)
+	err = f.WriteSeqAddr32(FlashPatchComparator0, make([]uint32, f.NumCode))
+	if err != nil {
+		return err
+	}
+	return f.WriteAddr32(FlashPatchCTRLRegister, FlashPatchCTRLKey|FlashPatchCTRLEnable)
+}
+
+// SetBreakpoint puts a breakpoint on the instruction at addr in the first free comparator.
+func (f *FPB) SetBreakpoint(addr uint32) error {
+	free := -1
+	for i := range f.used {
+		if f.used[i] && f.breakpoints[i] == addr {
+			return nil
+		}
+		if !f.used[i] && free < 0 {
+			free = i
+		}
+	}
+	if free < 0 {
+		return fmt.Errorf("error: FPB.SetBreakpoint() all %d comparators are in use", f.NumCode)
+	}
+
+	comp, err := f.encode(addr)
+	if err != nil {
+		return err
+	}
+	err = f.WriteAddr32(FlashPatchComparator0+uint32(free)*4, comp)
+	if err != nil {
+		return err
+	}
+	f.breakpoints[free] = addr
+	f.used[free] = true
+	return nil
+}
+
+// ClearBreakpoint removes the breakpoint at addr, it's not an error if there is none.
+func (f *FPB) ClearBreakpoint(addr uint32) error {
+	for i := range f.used {
+		if !f.used[i] || f.breakpoints[i] != addr {
+			continue
+		}
+		err := f.WriteAddr32(FlashPatchComparator0+uint32(i)*4, 0)
+		if err != nil {
+			return err
+		}
+		f.used[i] = false
+	}
+	return nil
+}
+
+// ClearAll removes every breakpoint.
+func (f *FPB) ClearAll() error {
+	for _, addr := range f.Breakpoints() {
+		err := f.ClearBreakpoint(addr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Breakpoints returns the addresses that currently have a breakpoint.
+func (f *FPB) Breakpoints() []uint32 {
+	var addrs []uint32
+	for i := range f.used {
+		if f.used[i] {
+			addrs = append(addrs, f.breakpoints[i])
+		}
+	}
+	return addrs
+}
+
+// encode builds the comparator value for a breakpoint at addr according to the FPB revision.
+func (f *FPB) encode(addr uint32) (uint32, error) {
+	if addr%2 != 0 {
+		return 0, fmt.Errorf("error: FPB breakpoint address 0x%x is not halfword aligned", addr)
+	}
+	if f.Revision == 1 {
+		return addr | FlashPatchCompV2Enable, nil
+	}
+
+	if addr >= FlashPatchCodeRegionEnd {
+		return 0, fmt.Errorf("error: FPBv1 breakpoint address 0x%x is outside the code region", addr)
+	}
+	replace := uint32(FlashPatchCompV1ReplaceLower)
+	if addr&2 > 0 {
+		replace = FlashPatchCompV1ReplaceUpper
+	}
+	return addr&FlashPatchCompV1AddrMask | replace | FlashPatchCompV1Enable, nil
+}
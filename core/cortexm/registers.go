@@ -0,0 +1,302 @@
+package cortexm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"goocd/core/adi"
+	"goocd/protocols/cmsisdap"
+	"strings"
+	"time"
+)
+
+// Register is a core register as selected by DCRSR REGSEL.
+type Register uint32
+
+// Core Registers
+const (
+	R0 = Register(iota)
+	R1
+	R2
+	R3
+	R4
+	R5
+	R6
+	R7
+	R8
+	R9
+	R10
+	R11
+	R12
+	SP
+	LR
+	PC // DebugReturnAddress
+	XPSR
+	MSP
+	PSP
+	_
+	CFBP // CONTROL, FAULTMASK, BASEPRI and PRIMASK packed one per byte
+
+	FPSCR = Register(0x21)
+	S0    = Register(0x40) // S0-S31 follow sequentially
+)
+
+// The special registers share the CFBP selector, these pseudo registers address each byte of it on its own
+const (
+	PRIMASK   = CFBP | 0x100
+	BASEPRI   = CFBP | 0x200
+	FAULTMASK = CFBP | 0x300
+	CONTROL   = CFBP | 0x400
+)
+
+// ARMv8-M banked registers, the Secure ones are only accessible when the Security Extension is implemented
+// and secure debug is allowed.  The unbanked selectors above access the registers of the current security state.
+const (
+	MSP_NS    = Register(0x18)
+	PSP_NS    = Register(0x19)
+	MSP_S     = Register(0x1A)
+	PSP_S     = Register(0x1B)
+	MSPLIM_S  = Register(0x1C)
+	PSPLIM_S  = Register(0x1D)
+	MSPLIM_NS = Register(0x1E)
+	PSPLIM_NS = Register(0x1F)
+	CFBP_S    = Register(0x22)
+	CFBP_NS   = Register(0x23)
+
+	PRIMASK_S    = CFBP_S | 0x100
+	BASEPRI_S    = CFBP_S | 0x200
+	FAULTMASK_S  = CFBP_S | 0x300
+	CONTROL_S    = CFBP_S | 0x400
+	PRIMASK_NS   = CFBP_NS | 0x100
+	BASEPRI_NS   = CFBP_NS | 0x200
+	FAULTMASK_NS = CFBP_NS | 0x300
+	CONTROL_NS   = CFBP_NS | 0x400
+)
+
+// ErrNotHalted is returned by core register accesses while the core is running
+var ErrNotHalted = errors.New("error: core register access requires a halted core")
+
+// RegSelMask is the REGSEL field of DCRSR
+const RegSelMask = 0x7F
+
+// IntegerRegisters are the registers every Cortex-M core has, the core packages add their special registers to these.
+var IntegerRegisters = []Register{R0, R1, R2, R3, R4, R5, R6, R7, R8, R9, R10, R11, R12, SP, LR, PC, XPSR, MSP, PSP}
+
+// FPRegisters returns FPSCR and S0-S31, which only exist when the FPU is implemented.
+func FPRegisters() []Register {
+	regs := []Register{FPSCR}
+	for i := Register(0); i < 32; i++ {
+		regs = append(regs, S0+i)
+	}
+	return regs
+}
+
+var registerNames = map[Register]string{
+	R0: "r0", R1: "r1", R2: "r2", R3: "r3", R4: "r4", R5: "r5", R6: "r6", R7: "r7", R8: "r8", R9: "r9", R10: "r10", R11: "r11", R12: "r12",
+	SP: "sp", LR: "lr", PC: "pc", XPSR: "xpsr", MSP: "msp", PSP: "psp", CFBP: "cfbp",
+	PRIMASK: "primask", BASEPRI: "basepri", FAULTMASK: "faultmask", CONTROL: "control", FPSCR: "fpscr",
+	MSP_NS: "msp_ns", PSP_NS: "psp_ns", MSP_S: "msp_s", PSP_S: "psp_s",
+	MSPLIM_S: "msplim_s", PSPLIM_S: "psplim_s", MSPLIM_NS: "msplim_ns", PSPLIM_NS: "psplim_ns",
+	CFBP_S: "cfbp_s", CFBP_NS: "cfbp_ns",
+	PRIMASK_S: "primask_s", BASEPRI_S: "basepri_s", FAULTMASK_S: "faultmask_s", CONTROL_S: "control_s",
+	PRIMASK_NS: "primask_ns", BASEPRI_NS: "basepri_ns", FAULTMASK_NS: "faultmask_ns", CONTROL_NS: "control_ns",
+}
+
+// Secure reports if r is one of the Secure banked registers.
+func (r Register) Secure() bool {
+	switch r & RegSelMask {
+	case MSP_S, PSP_S, MSPLIM_S, PSPLIM_S, CFBP_S:
+		return true
+	}
+	return false
+}
+
+func (r Register) String() string {
+	if name, ok := registerNames[r]; ok {
+		return name
+	}
+	if r >= S0 && r < S0+32 {
+		return fmt.Sprintf("s%d", r-S0)
+	}
+	return fmt.Sprintf("reg(0x%x)", uint32(r))
+}
+
+// ParseRegister looks up a register by the name String gives it, case insensitive.
+func ParseRegister(name string) (Register, error) {
+	name = strings.ToLower(name)
+	for r, n := range registerNames {
+		if n == name {
+			return r, nil
+		}
+	}
+	for r := S0; r < S0+32; r++ {
+		if r.String() == name {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("error: unknown core register %q", name)
+}
+
+// HasFPU reports if the FP extension is implemented by checking MVFR0 is populated.
+func (d *DAPTransferCoreAccess) HasFPU() (bool, error) {
+	vals, err := d.ReadAddr32(MediaAndFPFeatureRegister0, 1)
+	if err != nil {
+		return false, err
+	}
+	return vals[0] != 0, nil
+}
+
+// HasSecurityExtension reports if an ARMv8-M core implements the Security Extension by checking ID_PFR1.
+func (d *DAPTransferCoreAccess) HasSecurityExtension() (bool, error) {
+	vals, err := d.ReadAddr32(ProcessorFeatureRegister1, 1)
+	if err != nil {
+		return false, err
+	}
+	return vals[0]&ProcessorFeatureSecurityMask > 0, nil
+}
+
+// ReadCoreRegister reads a core register through DCRSR/DCRDR, the core has to be halted.
+// The Secure banked registers also need Secure debug to be allowed.
+func (d *DAPTransferCoreAccess) ReadCoreRegister(reg Register) (uint32, error) {
+	if reg.Secure() {
+		err := d.checkSecureDebug()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	resp, err := d.DAPTransfer(0, 7, d.EncodeDAPRequest(append(d.debugBankSetup(),
+		adi.Request{
+			// DCRSR
+			RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Write | cmsisdap.PortRegister4),
+			Payload:     uint32(reg) & RegSelMask,
+		},
+		adi.Request{
+			// DHCSR
+			RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Read | cmsisdap.PortRegister0),
+		},
+		adi.Request{
+			// DCRDR
+			RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Read | cmsisdap.PortRegister8),
+		},
+	)))
+	if err != nil {
+		return 0, err
+	}
+
+	dhcsr := binary.LittleEndian.Uint32(resp[3:7])
+	if dhcsr&DebugHaltingControlStatusHalted == 0 {
+		return 0, ErrNotHalted
+	}
+	value := binary.LittleEndian.Uint32(resp[7:11])
+	if dhcsr&DebugHaltingControlStatusRegReady == 0 {
+		// Transfer was faster than the core, wait it out and read DCRDR again
+		err = d.waitRegReady()
+		if err != nil {
+			return 0, err
+		}
+		vals, err := d.ReadAddr32(DebugCoreRegisterDataRegister, 1)
+		if err != nil {
+			return 0, err
+		}
+		value = vals[0]
+	}
+
+	if field := reg >> 8; field > 0 {
+		value = (value >> ((field - 1) * 8)) & 0xFF
+	}
+	return value, nil
+}
+
+// WriteCoreRegister writes a core register through DCRSR/DCRDR, the core has to be halted.
+// The Secure banked registers also need Secure debug to be allowed.
+func (d *DAPTransferCoreAccess) WriteCoreRegister(reg Register, value uint32) error {
+	if reg.Secure() {
+		err := d.checkSecureDebug()
+		if err != nil {
+			return err
+		}
+	}
+
+	if field := reg >> 8; field > 0 {
+		// Only one byte of CFBP, keep the rest as is
+		packed, err := d.ReadCoreRegister(reg & RegSelMask)
+		if err != nil {
+			return err
+		}
+		shift := (field - 1) * 8
+		value = packed&^(0xFF<<shift) | (value&0xFF)<<shift
+	}
+
+	resp, err := d.DAPTransfer(0, 7, d.EncodeDAPRequest(append(d.debugBankSetup(),
+		adi.Request{
+			// DCRDR
+			RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Write | cmsisdap.PortRegister8),
+			Payload:     value,
+		},
+		adi.Request{
+			// DCRSR
+			RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Write | cmsisdap.PortRegister4),
+			Payload:     uint32(reg)&RegSelMask | DebugCoreRegisterSelectorWrite,
+		},
+		adi.Request{
+			// DHCSR
+			RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Read | cmsisdap.PortRegister0),
+		},
+	)))
+	if err != nil {
+		return err
+	}
+
+	dhcsr := binary.LittleEndian.Uint32(resp[3:7])
+	if dhcsr&DebugHaltingControlStatusHalted == 0 {
+		return ErrNotHalted
+	}
+	if dhcsr&DebugHaltingControlStatusRegReady == 0 {
+		return d.waitRegReady()
+	}
+	return nil
+}
+
+// debugBankSetup points TAR at DHCSR and selects the banked data registers,
+// so BD0-BD3 map onto DHCSR, DCRSR, DCRDR and DEMCR and the whole register transfer fits in one DAP transfer.
+func (d *DAPTransferCoreAccess) debugBankSetup() []adi.Request {
+	return []adi.Request{
+		{
+			// Clear out the Selections Registers to known state
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Write | cmsisdap.PortRegister8),
+		},
+		{
+			RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Write | cmsisdap.PortRegister0),
+			Payload:     d.CSW(adi.AHBAPAddrIncOff, adi.DataSizeuint32),
+		},
+		{
+			RequestByte: byte(cmsisdap.AccessPort | cmsisdap.Write | cmsisdap.PortRegister4),
+			Payload:     DebugHaltingControlStatusRegister,
+		},
+		{
+			RequestByte: byte(cmsisdap.DebugPort | cmsisdap.Write | cmsisdap.PortRegister8),
+			Payload:     adi.Bank1 << adi.BankPos,
+		},
+	}
+}
+
+// waitRegReady polls DHCSR until S_REGRDY signals the last DCRSR transfer completed.
+func (d *DAPTransferCoreAccess) waitRegReady() error {
+	ti := time.Now()
+	for {
+		vals, err := d.ReadAddr32(DebugHaltingControlStatusRegister, 1)
+		if err != nil {
+			return err
+		}
+		if vals[0]&DebugHaltingControlStatusRegReady > 0 {
+			return nil
+		}
+		if vals[0]&DebugHaltingControlStatusHalted == 0 {
+			return ErrNotHalted
+		}
+		if time.Since(ti) > time.Millisecond*100 {
+			return fmt.Errorf("error: timed out waiting for DHCSR S_REGRDY")
+		}
+	}
+}
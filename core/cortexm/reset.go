@@ -0,0 +1,116 @@
+package cortexm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ResetStrategy is how the target gets reset.
+type ResetStrategy int
+
+const (
+	ResetHardware = ResetStrategy(iota) // nRESET pin driven by the probe, resets everything
+	ResetSystem                         // AIRCR SYSRESETREQ, resets the core and peripherals
+	ResetVector                         // AIRCR VECTRESET, resets the core only, ARMv7-M only
+)
+
+func (r ResetStrategy) String() string {
+	switch r {
+	case ResetHardware:
+		return "hw"
+	case ResetSystem:
+		return "sys"
+	case ResetVector:
+		return "vect"
+	}
+	return fmt.Sprintf("ResetStrategy(%d)", int(r))
+}
+
+// ParseResetStrategy is the inverse of ResetStrategy.String.
+func ParseResetStrategy(s string) (ResetStrategy, error) {
+	for _, r := range []ResetStrategy{ResetHardware, ResetSystem, ResetVector} {
+		if strings.EqualFold(s, r.String()) {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("error: unknown reset strategy %q, expected hw, sys or vect", s)
+}
+
+// PinResetter is implemented by probes that can drive nRESET, like cmsisdap.CMSISDAP.
+type PinResetter interface {
+	Reset() error
+}
+
+// ResetTarget resets the target using strategy.  With halt set DEMCR VC_CORERESET is armed first so the core
+// halts before executing the first instruction of the reset handler, and disarmed again once it has.
+func (d *DAPTransferCoreAccess) ResetTarget(strategy ResetStrategy, halt bool) error {
+	vals, err := d.ReadAddr32(DebugExceptionMonitorControlRegister, 1)
+	if err != nil {
+		return err
+	}
+	demcr := vals[0] &^ DebugExceptionMonitorVCCoreReset
+	if halt {
+		// Vector catch only halts with debug enabled
+		err = d.WriteAddr32(DebugHaltingControlStatusRegister, DebugHaltingControlStatusKey|DebugHaltingControlStatusEnable)
+		if err != nil {
+			return err
+		}
+		err = d.WriteAddr32(DebugExceptionMonitorControlRegister, demcr|DebugExceptionMonitorVCCoreReset)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Reading DHCSR clears a stale S_RESET_ST so the one after the reset can be waited for
+	_, err = d.ReadAddr32(DebugHaltingControlStatusRegister, 1)
+	if err != nil {
+		return err
+	}
+
+	switch strategy {
+	case ResetHardware:
+		pr, ok := d.DAPTransferer.(PinResetter)
+		if !ok {
+			return fmt.Errorf("error: ResetTarget() probe %T can't drive nRESET", d.DAPTransferer)
+		}
+		err = pr.Reset()
+	case ResetSystem:
+		err = d.WriteAddr32(ApplicationInterruptResetControlRegister, ApplicationInterruptResetControlKey|ApplicationInterruptResetSysResetReq)
+	case ResetVector:
+		err = d.WriteAddr32(ApplicationInterruptResetControlRegister, ApplicationInterruptResetControlKey|ApplicationInterruptResetVectReset)
+	default:
+		err = fmt.Errorf("error: ResetTarget() unsupported reset strategy %s", strategy)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = d.waitForReset(halt)
+	if err != nil {
+		return err
+	}
+	if !halt {
+		return nil
+	}
+	return d.WriteAddr32(DebugExceptionMonitorControlRegister, demcr)
+}
+
+// waitForReset polls DHCSR until S_RESET_ST shows the reset happened and, when halt is set, S_HALT shows the vector catch hit.
+func (d *DAPTransferCoreAccess) waitForReset(halt bool) error {
+	ti := time.Now()
+	resetSeen := false
+	for {
+		vals, err := d.ReadAddr32(DebugHaltingControlStatusRegister, 1)
+		if err != nil {
+			return err
+		}
+		resetSeen = resetSeen || vals[0]&DebugHaltingControlStatusReset > 0
+		if resetSeen && (!halt || vals[0]&DebugHaltingControlStatusHalted > 0) {
+			return nil
+		}
+		if time.Since(ti) > HaltTimeout {
+			return fmt.Errorf("error: timed out waiting for reset, reset seen: %t, core is %s", resetSeen, dhcsrState(vals[0]))
+		}
+	}
+}
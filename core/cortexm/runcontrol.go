@@ -0,0 +1,142 @@
+package cortexm
+
+import (
+	"fmt"
+	"time"
+)
+
+// CoreState is what the core is doing according to DHCSR.
+type CoreState int
+
+const (
+	StateRunning = CoreState(iota)
+	StateHalted
+	StateSleeping
+	StateLockup
+	StateReset
+)
+
+func (s CoreState) String() string {
+	switch s {
+	case StateRunning:
+		return "running"
+	case StateHalted:
+		return "halted"
+	case StateSleeping:
+		return "sleeping"
+	case StateLockup:
+		return "lockup"
+	case StateReset:
+		return "reset"
+	}
+	return fmt.Sprintf("CoreState(%d)", int(s))
+}
+
+// HaltTimeout is how long Halt and Step wait for the core to report S_HALT.
+var HaltTimeout = time.Millisecond * 500
+
+// Halt access the cortex DHCSR register and writes the Halt bits according to CorextM4 specifications, then waits for the core to report it halted.
+func (d *DAPTransferCoreAccess) Halt() error {
+	err := d.WriteAddr32(DebugHaltingControlStatusRegister, DebugHaltingControlStatusKey|DebugHaltingControlStatusHalt|DebugHaltingControlStatusEnable)
+	if err != nil {
+		return err
+	}
+	return d.WaitForHalt(HaltTimeout)
+}
+
+// Resume clears C_HALT so the core continues running, debug stays enabled.
+func (d *DAPTransferCoreAccess) Resume() error {
+	return d.WriteAddr32(DebugHaltingControlStatusRegister, DebugHaltingControlStatusKey|DebugHaltingControlStatusEnable)
+}
+
+// Step executes a single instruction on a halted core and waits for it to halt again.
+// With maskInts set C_MASKINTS is held during the step so pending interrupts aren't taken, it can only be changed while halted so it is set up front.
+func (d *DAPTransferCoreAccess) Step(maskInts bool) error {
+	vals, err := d.ReadAddr32(DebugHaltingControlStatusRegister, 1)
+	if err != nil {
+		return err
+	}
+	if vals[0]&DebugHaltingControlStatusHalted == 0 {
+		return fmt.Errorf("error: Step() requires a halted core, core is %s", dhcsrState(vals[0]))
+	}
+
+	ctrl := uint32(DebugHaltingControlStatusKey | DebugHaltingControlStatusEnable)
+	if maskInts {
+		ctrl |= DebugHaltingControlStatusMaskInts
+		err = d.WriteAddr32(DebugHaltingControlStatusRegister, ctrl|DebugHaltingControlStatusHalt)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = d.WriteAddr32(DebugHaltingControlStatusRegister, ctrl|DebugHaltingControlStatusStep)
+	if err != nil {
+		return err
+	}
+	err = d.WaitForHalt(HaltTimeout)
+	if err != nil {
+		return err
+	}
+
+	// Back to a plain halt, dropping C_STEP and C_MASKINTS
+	return d.WriteAddr32(DebugHaltingControlStatusRegister, DebugHaltingControlStatusKey|DebugHaltingControlStatusHalt|DebugHaltingControlStatusEnable)
+}
+
+// Status reads DHCSR and reports the state of the core.  S_RESET_ST is sticky and cleared by this read, so a reset since the last Status is reported once.
+func (d *DAPTransferCoreAccess) Status() (CoreState, error) {
+	vals, err := d.ReadAddr32(DebugHaltingControlStatusRegister, 1)
+	if err != nil {
+		return 0, err
+	}
+	return dhcsrState(vals[0]), nil
+}
+
+// WaitForHalt polls DHCSR until S_HALT is set or timeout passes.
+func (d *DAPTransferCoreAccess) WaitForHalt(timeout time.Duration) error {
+	ti := time.Now()
+	for {
+		vals, err := d.ReadAddr32(DebugHaltingControlStatusRegister, 1)
+		if err != nil {
+			return err
+		}
+		if vals[0]&DebugHaltingControlStatusHalted > 0 {
+			return nil
+		}
+		if time.Since(ti) > timeout {
+			return fmt.Errorf("error: timed out waiting for core to halt, core is %s", dhcsrState(vals[0]))
+		}
+	}
+}
+
+func dhcsrState(dhcsr uint32) CoreState {
+	switch {
+	case dhcsr&DebugHaltingControlStatusReset > 0:
+		return StateReset
+	case dhcsr&DebugHaltingControlStatusLockup > 0:
+		return StateLockup
+	case dhcsr&DebugHaltingControlStatusHalted > 0:
+		return StateHalted
+	case dhcsr&DebugHaltingControlStatusSleeping > 0:
+		return StateSleeping
+	}
+	return StateRunning
+}
+
+// DebugFaultStatus reads DFSR to tell why the core halted and clears the bits read, so the next halt reports fresh ones.
+func (d *DAPTransferCoreAccess) DebugFaultStatus() (uint32, error) {
+	vals, err := d.ReadAddr32(DebugFaultStatusRegister, 1)
+	if err != nil {
+		return 0, err
+	}
+	return vals[0], d.WriteAddr32(DebugFaultStatusRegister, vals[0])
+}
+
+// SetVectorCatch arms the fault vector catches in mask, a combination of the DebugExceptionMonitorVC bits, disarming the others.
+// The core halts instead of taking an armed exception.
+func (d *DAPTransferCoreAccess) SetVectorCatch(mask uint32) error {
+	vals, err := d.ReadAddr32(DebugExceptionMonitorControlRegister, 1)
+	if err != nil {
+		return err
+	}
+	return d.WriteAddr32(DebugExceptionMonitorControlRegister, vals[0]&^DebugExceptionMonitorVCMask|mask&DebugExceptionMonitorVCMask)
+}
@@ -0,0 +1,143 @@
+package cortexm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SecurityState is the security state an ARMv8-M core with the Security Extension executes in.
+type SecurityState int
+
+const (
+	SecurityNone SecurityState = iota // no Security Extension
+	SecurityNonSecure
+	SecuritySecure
+)
+
+func (s SecurityState) String() string {
+	switch s {
+	case SecurityNone:
+		return "none"
+	case SecurityNonSecure:
+		return "non-secure"
+	case SecuritySecure:
+		return "secure"
+	}
+	return fmt.Sprintf("SecurityState(%d)", int(s))
+}
+
+// ErrSecureDebugDisabled is returned when touching Secure state while the debug authentication doesn't allow it
+var ErrSecureDebugDisabled = errors.New("error: secure invasive debug is not allowed by the debug authentication")
+
+// DebugAuthentication is what DAUTHSTATUS reports the debugger is allowed to do, along with the DAUTHCTRL overrides.
+type DebugAuthentication struct {
+	SecureInvasive       bool
+	SecureNonInvasive    bool
+	NonSecureInvasive    bool
+	NonSecureNonInvasive bool
+
+	// Secure software took control of the authentication through DAUTHCTRL instead of the SPIDEN/SPNIDEN signals
+	InternalSPIDEN  bool
+	InternalSPNIDEN bool
+}
+
+// DebugAuthentication reads DAUTHSTATUS and DAUTHCTRL.
+func (d *DAPTransferCoreAccess) DebugAuthentication() (DebugAuthentication, error) {
+	status, err := d.ReadAddr32(DebugAuthenticationStatusRegister, 1)
+	if err != nil {
+		return DebugAuthentication{}, err
+	}
+	ctrl, err := d.ReadAddr32(DebugAuthenticationControlRegister, 1)
+	if err != nil {
+		return DebugAuthentication{}, err
+	}
+
+	enabled := func(pos uint32) bool {
+		return (status[0]>>pos)&DebugAuthenticationStatusFieldMask == DebugAuthenticationStatusEnabled
+	}
+	return DebugAuthentication{
+		SecureInvasive:       enabled(DebugAuthenticationStatusSIDPos),
+		SecureNonInvasive:    enabled(DebugAuthenticationStatusSNIDPos),
+		NonSecureInvasive:    enabled(DebugAuthenticationStatusNSIDPos),
+		NonSecureNonInvasive: enabled(DebugAuthenticationStatusNSNIDPos),
+		InternalSPIDEN:       ctrl[0]&DebugAuthenticationControlSPIDENSel > 0,
+		InternalSPNIDEN:      ctrl[0]&DebugAuthenticationControlSPNIDENSel > 0,
+	}, nil
+}
+
+// SecureDebugAllowed reports if Secure invasive debug is allowed, or not implemented at all so there is nothing to deny.
+func (d *DAPTransferCoreAccess) SecureDebugAllowed() (bool, error) {
+	vals, err := d.ReadAddr32(DebugAuthenticationStatusRegister, 1)
+	if err != nil {
+		return false, err
+	}
+	return (vals[0]>>DebugAuthenticationStatusSIDPos)&DebugAuthenticationStatusFieldMask != DebugAuthenticationStatusDisabled, nil
+}
+
+// checkSecureDebug fails with ErrSecureDebugDisabled when Secure invasive debug is implemented but disabled.
+func (d *DAPTransferCoreAccess) checkSecureDebug() error {
+	allowed, err := d.SecureDebugAllowed()
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrSecureDebugDisabled
+	}
+	return nil
+}
+
+// SecurityState reports which security state the halted core is in through DSCSR CDS.
+func (d *DAPTransferCoreAccess) SecurityState() (SecurityState, error) {
+	secure, err := d.HasSecurityExtension()
+	if err != nil || !secure {
+		return SecurityNone, err
+	}
+
+	vals, err := d.ReadAddr32(DebugHaltingControlStatusRegister, 1)
+	if err != nil {
+		return SecurityNone, err
+	}
+	if vals[0]&DebugHaltingControlStatusHalted == 0 {
+		return SecurityNone, ErrNotHalted
+	}
+
+	vals, err = d.ReadAddr32(DebugSecurityControlStatusRegister, 1)
+	if err != nil {
+		return SecurityNone, err
+	}
+	if vals[0]&DebugSecurityControlStatusCDS > 0 {
+		return SecuritySecure, nil
+	}
+	return SecurityNonSecure, nil
+}
+
+// SetSecurityState switches the security state of the halted core by writing DSCSR CDS, entering Secure state needs Secure debug to be allowed.
+func (d *DAPTransferCoreAccess) SetSecurityState(state SecurityState) error {
+	current, err := d.SecurityState()
+	if err != nil {
+		return err
+	}
+	if current == SecurityNone || state == SecurityNone {
+		return fmt.Errorf("error: SetSecurityState() can't switch from %s to %s", current, state)
+	}
+	if state == current {
+		return nil
+	}
+	if state == SecuritySecure {
+		err = d.checkSecureDebug()
+		if err != nil {
+			return err
+		}
+	}
+
+	vals, err := d.ReadAddr32(DebugSecurityControlStatusRegister, 1)
+	if err != nil {
+		return err
+	}
+	// CDS is only written along with a zero CDSKEY
+	dscsr := vals[0] &^ (DebugSecurityControlStatusCDS | DebugSecurityControlStatusCDSKey)
+	if state == SecuritySecure {
+		dscsr |= DebugSecurityControlStatusCDS
+	}
+	return d.WriteAddr32(DebugSecurityControlStatusRegister, dscsr)
+}
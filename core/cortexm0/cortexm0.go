@@ -0,0 +1,38 @@
+// Package cortexm0 is the Cortex-M0 and Cortex-M0+ (ARMv6-M) core.  It has no
+// FPU, no BASEPRI or FAULTMASK and no VECTRESET.  Its breakpoint unit has at
+// most 4 comparators limited to the code region, programmed like an FPBv1
+// through cortexm.FPB, and its DWT has at most 2 comparators without the
+// cycle counter, programmed like the ARMv7-M one through cortexm.DWT.
+// The reduced AHB-AP has no packed transfers, which adi.MemAP never uses.
+package cortexm0
+
+import (
+	"fmt"
+	"goocd/core/adi"
+	"goocd/core/cortexm"
+)
+
+// SpecialRegisters are the registers packed in CFBP that ARMv6-M implements.
+var SpecialRegisters = []cortexm.Register{cortexm.PRIMASK, cortexm.CONTROL}
+
+type DAPTransferCoreAccess struct {
+	cortexm.DAPTransferCoreAccess
+}
+
+// New returns a DAPTransferCoreAccess talking to the core through t.
+func New(t adi.DAPTransferer) *DAPTransferCoreAccess {
+	return &DAPTransferCoreAccess{DAPTransferCoreAccess: cortexm.DAPTransferCoreAccess{MemAP: adi.MemAP{DAPTransferer: t}}}
+}
+
+// CoreRegisters returns every register worth showing on a halted core.
+func (d *DAPTransferCoreAccess) CoreRegisters() ([]cortexm.Register, error) {
+	return append(append([]cortexm.Register{}, cortexm.IntegerRegisters...), SpecialRegisters...), nil
+}
+
+// ResetTarget resets the target like cortexm.DAPTransferCoreAccess.ResetTarget, ARMv6-M has no VECTRESET so ResetVector is refused.
+func (d *DAPTransferCoreAccess) ResetTarget(strategy cortexm.ResetStrategy, halt bool) error {
+	if strategy == cortexm.ResetVector {
+		return fmt.Errorf("error: cortexm0.ResetTarget() %s reset is not implemented on ARMv6-M", strategy)
+	}
+	return d.DAPTransferCoreAccess.ResetTarget(strategy, halt)
+}
@@ -0,0 +1,64 @@
+package cortexm0
+
+import (
+	"testing"
+
+	"goocd/core/cortexm"
+	"goocd/probes/simprobe"
+)
+
+func TestDAPTransferCoreAccess(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.WriteWord(4, 0x000000D5)
+	sim.WriteWord(cortexm.FlashPatchCTRLRegister, 0x00000040) // BPU with 4 comparators
+	sim.WriteWord(cortexm.DataWatchpointCTRLRegister, 0x20000000)
+	core := New(sim)
+	if err := core.Configure(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := core.ResetTarget(cortexm.ResetVector, true); err == nil {
+		t.Fatalf("expected VECTRESET to be refused")
+	}
+	if err := core.ResetTarget(cortexm.ResetSystem, true); err != nil {
+		t.Fatal(err)
+	}
+
+	regs, err := core.CoreRegisters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, reg := range regs {
+		if _, err := core.ReadCoreRegister(reg); err != nil {
+			t.Fatalf("%s: %v", reg, err)
+		}
+	}
+	if pc, _ := core.ReadCoreRegister(cortexm.PC); pc != 0xD4 {
+		t.Fatalf("expected halt at 0xd4, got 0x%x", pc)
+	}
+
+	bpu := &cortexm.FPB{MemoryAccess: core}
+	if err := bpu.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	if bpu.Revision != 0 || bpu.NumCode != 4 {
+		t.Fatalf("expected an FPBv1 style BPU with 4 comparators, got v%d with %d", bpu.Revision+1, bpu.NumCode)
+	}
+	if err := bpu.SetBreakpoint(0xD6); err != nil {
+		t.Fatal(err)
+	}
+	if got := sim.ReadWord(cortexm.FlashPatchComparator0); got != 0x800000D5 {
+		t.Fatalf("expected comparator 0x800000d5, got 0x%x", got)
+	}
+
+	dwt := &cortexm.DWT{MemoryAccess: core}
+	if err := dwt.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	if dwt.NumComp != 2 || dwt.V8M {
+		t.Fatalf("expected 2 ARMv6-M comparators, got %d", dwt.NumComp)
+	}
+	if _, err := dwt.SetWatchpoint(0x20000000, 4, cortexm.WatchWrite); err != nil {
+		t.Fatal(err)
+	}
+}
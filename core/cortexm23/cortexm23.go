@@ -1 +1,58 @@
+// Package cortexm23 is the Cortex-M23 (ARMv8-M Baseline) core.  It has no
+// FPU, no BASEPRI or FAULTMASK and no VECTRESET, its breakpoint unit (BPU)
+// is programmed like an FPBv2 through cortexm.FPB and its DWT is the ARMv8-M
+// one, without MASK and with ranges built from comparator pairs.  When the
+// Security Extension is implemented the stack pointers, stack limits and
+// special registers are banked between the Secure and Non-secure state.
 package cortexm23
+
+import (
+	"fmt"
+	"goocd/core/adi"
+	"goocd/core/cortexm"
+)
+
+// SpecialRegisters are the registers packed in CFBP that ARMv8-M Baseline implements.
+var SpecialRegisters = []cortexm.Register{cortexm.PRIMASK, cortexm.CONTROL}
+
+// NonSecureRegisters are the Non-secure banked registers, they exist when the Security Extension is implemented.
+var NonSecureRegisters = []cortexm.Register{cortexm.MSP_NS, cortexm.PSP_NS, cortexm.PRIMASK_NS, cortexm.CONTROL_NS}
+
+// SecureRegisters are the Secure banked registers, only accessible when Secure debug is allowed.
+// Baseline only has stack limit registers for the Secure state.
+var SecureRegisters = []cortexm.Register{cortexm.MSP_S, cortexm.PSP_S, cortexm.MSPLIM_S, cortexm.PSPLIM_S, cortexm.PRIMASK_S, cortexm.CONTROL_S}
+
+type DAPTransferCoreAccess struct {
+	cortexm.DAPTransferCoreAccess
+}
+
+// New returns a DAPTransferCoreAccess talking to the core through t.
+func New(t adi.DAPTransferer) *DAPTransferCoreAccess {
+	return &DAPTransferCoreAccess{DAPTransferCoreAccess: cortexm.DAPTransferCoreAccess{MemAP: adi.MemAP{DAPTransferer: t}}}
+}
+
+// CoreRegisters returns every register worth showing on a halted core, the banked registers included when the Security Extension is implemented
+// and the Secure ones only when Secure debug is allowed.
+func (d *DAPTransferCoreAccess) CoreRegisters() ([]cortexm.Register, error) {
+	regs := append(append([]cortexm.Register{}, cortexm.IntegerRegisters...), SpecialRegisters...)
+	secure, err := d.HasSecurityExtension()
+	if err != nil || !secure {
+		return regs, err
+	}
+	allowed, err := d.SecureDebugAllowed()
+	if err != nil {
+		return nil, err
+	}
+	if allowed {
+		regs = append(regs, SecureRegisters...)
+	}
+	return append(regs, NonSecureRegisters...), nil
+}
+
+// ResetTarget resets the target like cortexm.DAPTransferCoreAccess.ResetTarget, ARMv8-M Baseline has no VECTRESET so ResetVector is refused.
+func (d *DAPTransferCoreAccess) ResetTarget(strategy cortexm.ResetStrategy, halt bool) error {
+	if strategy == cortexm.ResetVector {
+		return fmt.Errorf("error: cortexm23.ResetTarget() %s reset is not implemented on ARMv8-M Baseline", strategy)
+	}
+	return d.DAPTransferCoreAccess.ResetTarget(strategy, halt)
+}
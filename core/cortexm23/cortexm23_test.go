@@ -0,0 +1,69 @@
+package cortexm23
+
+import (
+	"testing"
+
+	"goocd/core/cortexm"
+	"goocd/probes/simprobe"
+)
+
+func TestDAPTransferCoreAccess_CoreRegisters(t *testing.T) {
+	sim := &simprobe.Probe{}
+	core := New(sim)
+
+	regs, err := core.CoreRegisters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regs) != len(cortexm.IntegerRegisters)+len(SpecialRegisters) {
+		t.Fatalf("expected no banked registers without the Security Extension, got %v", regs)
+	}
+
+	sim.WriteWord(cortexm.ProcessorFeatureRegister1, 0x10)
+	sim.Core.Registers[cortexm.MSP_S] = 0x20001000
+	sim.Core.Registers[cortexm.CFBP_NS] = 0x01000000
+	regs, err = core.CoreRegisters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regs) != len(cortexm.IntegerRegisters)+len(SpecialRegisters)+len(SecureRegisters)+len(NonSecureRegisters) {
+		t.Fatalf("expected the banked registers with the Security Extension, got %v", regs)
+	}
+
+	if err := core.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	if msp, err := core.ReadCoreRegister(cortexm.MSP_S); err != nil || msp != 0x20001000 {
+		t.Fatalf("expected msp_s 0x20001000, got 0x%x %v", msp, err)
+	}
+	if control, err := core.ReadCoreRegister(cortexm.CONTROL_NS); err != nil || control != 0x1 {
+		t.Fatalf("expected control_ns 0x1, got 0x%x %v", control, err)
+	}
+
+	sim.WriteWord(cortexm.DebugAuthenticationStatusRegister, 0x2F)
+	regs, err = core.CoreRegisters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, reg := range regs {
+		if reg.Secure() {
+			t.Fatalf("expected no secure registers with secure debug disabled, got %s", reg)
+		}
+	}
+}
+
+func TestDAPTransferCoreAccess_ResetTarget(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.WriteWord(4, 0x00000101)
+	core := New(sim)
+
+	if err := core.ResetTarget(cortexm.ResetVector, true); err == nil {
+		t.Fatalf("expected VECTRESET to be refused")
+	}
+	if err := core.ResetTarget(cortexm.ResetSystem, true); err != nil {
+		t.Fatal(err)
+	}
+	if pc, err := core.ReadCoreRegister(cortexm.PC); err != nil || pc != 0x100 {
+		t.Fatalf("expected halt at 0x100, got 0x%x %v", pc, err)
+	}
+}
@@ -0,0 +1,80 @@
+// Package cortexm33 is the Cortex-M33 (ARMv8-M Mainline) core.  Its FPB is
+// revision 2, its DWT is the ARMv8-M one and it has the optional single
+// precision FPU, accessed like the integer registers through DCRSR.  Both
+// stacks have a limit register and, when the Security Extension is
+// implemented, the stack pointers, stack limits and special registers are
+// banked between the Secure and Non-secure state.
+package cortexm33
+
+import (
+	"fmt"
+	"goocd/core/adi"
+	"goocd/core/cortexm"
+)
+
+// SpecialRegisters are the registers packed in CFBP, all of which exist on ARMv8-M Mainline.
+var SpecialRegisters = []cortexm.Register{cortexm.PRIMASK, cortexm.BASEPRI, cortexm.FAULTMASK, cortexm.CONTROL}
+
+// StackLimitRegisters are the stack limits of a core without the Security Extension, which always runs Non-secure.
+var StackLimitRegisters = []cortexm.Register{cortexm.MSPLIM_NS, cortexm.PSPLIM_NS}
+
+// NonSecureRegisters are the Non-secure banked registers, they exist when the Security Extension is implemented.
+var NonSecureRegisters = []cortexm.Register{
+	cortexm.MSP_NS, cortexm.PSP_NS, cortexm.MSPLIM_NS, cortexm.PSPLIM_NS,
+	cortexm.PRIMASK_NS, cortexm.BASEPRI_NS, cortexm.FAULTMASK_NS, cortexm.CONTROL_NS,
+}
+
+// SecureRegisters are the Secure banked registers, only accessible when Secure debug is allowed.
+var SecureRegisters = []cortexm.Register{
+	cortexm.MSP_S, cortexm.PSP_S, cortexm.MSPLIM_S, cortexm.PSPLIM_S,
+	cortexm.PRIMASK_S, cortexm.BASEPRI_S, cortexm.FAULTMASK_S, cortexm.CONTROL_S,
+}
+
+type DAPTransferCoreAccess struct {
+	cortexm.DAPTransferCoreAccess
+}
+
+// New returns a DAPTransferCoreAccess talking to the core through t.
+func New(t adi.DAPTransferer) *DAPTransferCoreAccess {
+	return &DAPTransferCoreAccess{DAPTransferCoreAccess: cortexm.DAPTransferCoreAccess{MemAP: adi.MemAP{DAPTransferer: t}}}
+}
+
+// CoreRegisters returns every register worth showing on a halted core: the stack limits, the banked registers when the
+// Security Extension is implemented, the Secure ones only when Secure debug is allowed, and the FP registers when the FPU is implemented.
+func (d *DAPTransferCoreAccess) CoreRegisters() ([]cortexm.Register, error) {
+	regs := append(append([]cortexm.Register{}, cortexm.IntegerRegisters...), SpecialRegisters...)
+
+	secure, err := d.HasSecurityExtension()
+	if err != nil {
+		return nil, err
+	}
+	if secure {
+		allowed, err := d.SecureDebugAllowed()
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			regs = append(regs, SecureRegisters...)
+		}
+		regs = append(regs, NonSecureRegisters...)
+	} else {
+		regs = append(regs, StackLimitRegisters...)
+	}
+
+	fpu, err := d.HasFPU()
+	if err != nil {
+		return nil, err
+	}
+	if fpu {
+		regs = append(regs, cortexm.FPRegisters()...)
+	}
+	return regs, nil
+}
+
+// ResetTarget resets the target like cortexm.DAPTransferCoreAccess.ResetTarget, ARMv8-M has no VECTRESET so ResetVector is refused.
+func (d *DAPTransferCoreAccess) ResetTarget(strategy cortexm.ResetStrategy, halt bool) error {
+	if strategy == cortexm.ResetVector {
+		return fmt.Errorf("error: cortexm33.ResetTarget() %s reset is not implemented on ARMv8-M", strategy)
+	}
+	return d.DAPTransferCoreAccess.ResetTarget(strategy, halt)
+}
@@ -0,0 +1,62 @@
+package cortexm33
+
+import (
+	"testing"
+
+	"goocd/core/cortexm"
+	"goocd/probes/simprobe"
+)
+
+func TestDAPTransferCoreAccess_CoreRegisters(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.Core.Registers[cortexm.S0+5] = 0x3F800000
+	sim.Core.Registers[cortexm.FPSCR] = 0x03000000
+	sim.Core.Registers[cortexm.PSPLIM_NS] = 0x20000400
+	sim.Core.Registers[cortexm.CFBP_S] = 0x00FF0000
+	core := New(sim)
+	if err := core.Halt(); err != nil {
+		t.Fatal(err)
+	}
+
+	regs, err := core.CoreRegisters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regs) != len(cortexm.IntegerRegisters)+len(SpecialRegisters)+len(StackLimitRegisters) {
+		t.Fatalf("expected the stack limits without FP or banked registers, got %v", regs)
+	}
+	if limit, err := core.ReadCoreRegister(cortexm.PSPLIM_NS); err != nil || limit != 0x20000400 {
+		t.Fatalf("expected psplim 0x20000400, got 0x%x %v", limit, err)
+	}
+
+	sim.WriteWord(cortexm.MediaAndFPFeatureRegister0, 0x10110021)
+	sim.WriteWord(cortexm.ProcessorFeatureRegister1, 0x210)
+	regs, err = core.CoreRegisters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regs) != len(cortexm.IntegerRegisters)+len(SpecialRegisters)+len(SecureRegisters)+len(NonSecureRegisters)+33 {
+		t.Fatalf("expected banked and FP registers, got %v", regs)
+	}
+
+	if s5, err := core.ReadCoreRegister(cortexm.S0 + 5); err != nil || s5 != 0x3F800000 {
+		t.Fatalf("expected s5 1.0, got 0x%x %v", s5, err)
+	}
+	if err := core.WriteCoreRegister(cortexm.FPSCR, 0x03C00000); err != nil {
+		t.Fatal(err)
+	}
+	if sim.Core.Registers[cortexm.FPSCR] != 0x03C00000 {
+		t.Fatalf("expected fpscr to be written")
+	}
+	if faultmask, err := core.ReadCoreRegister(cortexm.FAULTMASK_S); err != nil || faultmask != 0xFF {
+		t.Fatalf("expected faultmask_s 0xff, got 0x%x %v", faultmask, err)
+	}
+
+	if err := core.SetVectorCatch(cortexm.DebugExceptionMonitorVCHardErr | cortexm.DebugExceptionMonitorVCSFErr | cortexm.DebugExceptionMonitorVCCoreReset); err != nil {
+		t.Fatal(err)
+	}
+	demcr, _ := core.ReadAddr32(cortexm.DebugExceptionMonitorControlRegister, 1)
+	if demcr[0] != cortexm.DebugExceptionMonitorVCHardErr|cortexm.DebugExceptionMonitorVCSFErr {
+		t.Fatalf("expected only the HardFault and SecureFault catches, got 0x%x", demcr[0])
+	}
+}
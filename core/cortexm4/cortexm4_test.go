@@ -0,0 +1,83 @@
+package cortexm4
+
+import (
+	"testing"
+
+	"goocd/core/cortexm"
+	"goocd/probes/simprobe"
+)
+
+func TestDAPTransferCoreAccess_CoreRegisters(t *testing.T) {
+	sim := &simprobe.Probe{}
+	core := New(sim)
+
+	regs, err := core.CoreRegisters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regs) != len(cortexm.IntegerRegisters)+len(SpecialRegisters) {
+		t.Fatalf("expected no FP registers without an FPU, got %v", regs)
+	}
+
+	sim.WriteWord(cortexm.MediaAndFPFeatureRegister0, 0x10110021) // single precision FPU
+	sim.Core.Registers[cortexm.S0+31] = 0x3f800000
+	regs, err = core.CoreRegisters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regs) != len(cortexm.IntegerRegisters)+len(SpecialRegisters)+33 || regs[len(regs)-1] != cortexm.S0+31 {
+		t.Fatalf("expected fpscr and s0-s31 after the special registers, got %v", regs)
+	}
+	if err := core.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	if s31, err := core.ReadCoreRegister(cortexm.S0 + 31); err != nil || s31 != 0x3f800000 {
+		t.Fatalf("expected s31 0x3f800000, got 0x%x %v", s31, err)
+	}
+}
+
+func TestDAPTransferCoreAccess_DebugUnits(t *testing.T) {
+	sim := &simprobe.Probe{}
+	sim.WriteWord(cortexm.FlashPatchCTRLRegister, 0x00000260) // FPBv1, 6 code and 2 literal comparators
+	sim.WriteWord(cortexm.DataWatchpointCTRLRegister, 0x40000000)
+	sim.OnWrite = func(addr, value uint32) {
+		if addr == cortexm.DataWatchpointComparator0+cortexm.DataWatchpointMaskOffset {
+			sim.WriteWord(addr, value&0xF) // MASK implements 4 bits, 32KB at most
+		}
+	}
+	core := New(sim)
+	if err := core.Configure(); err != nil {
+		t.Fatal(err)
+	}
+
+	fpb := &cortexm.FPB{MemoryAccess: core}
+	if err := fpb.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	if fpb.Revision != 0 || fpb.NumCode != 6 {
+		t.Fatalf("expected an FPBv1 with 6 code comparators, got v%d with %d", fpb.Revision+1, fpb.NumCode)
+	}
+	if err := fpb.SetBreakpoint(0x410); err != nil {
+		t.Fatal(err)
+	}
+	if got := sim.ReadWord(cortexm.FlashPatchComparator0); got != 0x40000411 {
+		t.Fatalf("expected comparator 0x40000411, got 0x%x", got)
+	}
+
+	dwt := &cortexm.DWT{MemoryAccess: core}
+	if err := dwt.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	if dwt.NumComp != 4 || dwt.V8M {
+		t.Fatalf("expected 4 ARMv7-M comparators, got %d", dwt.NumComp)
+	}
+	if dwt.MaxMask != 0xF {
+		t.Fatalf("expected MASK to implement 4 bits, got 0x%x", dwt.MaxMask)
+	}
+	if _, err := dwt.SetWatchpoint(0x20000000, 0x10000, cortexm.WatchWrite); err == nil {
+		t.Fatal("expected a 64KB watchpoint to be rejected")
+	}
+	if _, err := dwt.SetWatchpoint(0x20000000, 0x8000, cortexm.WatchWrite); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,203 @@
+package cortexm7
+
+import (
+	"math/bits"
+)
+
+// cachesEnabled reads CCR, caches are only used once the firmware enabled them.
+func (d *DAPTransferCoreAccess) cachesEnabled() (dcache, icache bool, err error) {
+	if !d.DCache.Present && !d.ICache.Present {
+		return false, false, nil
+	}
+	vals, err := d.DAPTransferCoreAccess.ReadAddr32(ConfigurationControlRegister, 1)
+	if err != nil {
+		return false, false, err
+	}
+	return d.DCache.Present && vals[0]&ConfigurationControlDCache > 0, d.ICache.Present && vals[0]&ConfigurationControlICache > 0, nil
+}
+
+// cacheable reports if any of length bytes at addr may be held in the caches.
+func cacheable(addr uint32, length int) bool {
+	end := uint64(addr) + uint64(length)
+	if addr >= CacheableRegionEnd {
+		return false
+	}
+	return !(addr >= PeripheralRegionStart && end <= PeripheralRegionEnd)
+}
+
+// maintainDCache runs the data cache operation op on each line holding length bytes at addr.
+func (d *DAPTransferCoreAccess) maintainDCache(op, addr uint32, length int) error {
+	line := d.DCache.LineSize
+	for a := addr &^ (line - 1); uint64(a) < uint64(addr)+uint64(length); a += line {
+		err := d.DAPTransferCoreAccess.WriteAddr32(op, a)
+		if err != nil {
+			return err
+		}
+		if a+line < a {
+			break
+		}
+	}
+	return nil
+}
+
+// CleanInvalidateDCache writes back and drops every line of the data cache by set and way.
+func (d *DAPTransferCoreAccess) CleanInvalidateDCache() error {
+	if !d.DCache.Present {
+		return nil
+	}
+	setPos := uint32(bits.TrailingZeros32(d.DCache.LineSize))
+	wayPos := uint32(bits.LeadingZeros32(d.DCache.Ways - 1))
+	for way := uint32(0); way < d.DCache.Ways; way++ {
+		for set := uint32(0); set < d.DCache.Sets; set++ {
+			err := d.DAPTransferCoreAccess.WriteAddr32(DCacheCleanInvalidateSetWay, way<<wayPos|set<<setPos)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// InvalidateCaches cleans and invalidates the whole data cache and invalidates the instruction cache,
+// needed after memory changed behind the core's back like after flash programming.
+func (d *DAPTransferCoreAccess) InvalidateCaches() error {
+	dcache, icache, err := d.cachesEnabled()
+	if err != nil {
+		return err
+	}
+	if dcache {
+		err = d.CleanInvalidateDCache()
+		if err != nil {
+			return err
+		}
+	}
+	if icache {
+		return d.DAPTransferCoreAccess.WriteAddr32(ICacheInvalidateAll, 0)
+	}
+	return nil
+}
+
+// beforeRead cleans the data cache lines of the range so dirty data reaches memory where the debugger sees it.
+func (d *DAPTransferCoreAccess) beforeRead(addr uint32, length int) error {
+	if !cacheable(addr, length) {
+		return nil
+	}
+	dcache, _, err := d.cachesEnabled()
+	if err != nil || !dcache {
+		return err
+	}
+	if uint32(length) >= d.DCache.Size() {
+		return d.CleanInvalidateDCache()
+	}
+	return d.maintainDCache(DCacheCleanMVA, addr, length)
+}
+
+// write cleans and invalidates the data cache lines of the range so neither stale nor dirty lines hide the written data,
+// does the write and then invalidates the instruction cache in case code was written.
+func (d *DAPTransferCoreAccess) write(addr uint32, length int, write func() error) error {
+	if !cacheable(addr, length) {
+		return write()
+	}
+	dcache, icache, err := d.cachesEnabled()
+	if err != nil {
+		return err
+	}
+	if dcache {
+		if uint32(length) >= d.DCache.Size() {
+			err = d.CleanInvalidateDCache()
+		} else {
+			err = d.maintainDCache(DCacheCleanInvalidateMVA, addr, length)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = write()
+	if err != nil {
+		return err
+	}
+
+	if dcache {
+		// The core may have fetched the old data again in the meantime
+		err = d.maintainDCache(DCacheInvalidateMVA, addr, length)
+		if err != nil {
+			return err
+		}
+	}
+	if icache {
+		return d.DAPTransferCoreAccess.WriteAddr32(ICacheInvalidateAll, 0)
+	}
+	return nil
+}
+
+// ReadAddr32 reads like adi.MemAP.ReadAddr32 after cleaning the data cache lines of the range.
+func (d *DAPTransferCoreAccess) ReadAddr32(addr uint32, count int) ([]uint32, error) {
+	err := d.beforeRead(addr, count*4)
+	if err != nil {
+		return nil, err
+	}
+	return d.DAPTransferCoreAccess.ReadAddr32(addr, count)
+}
+
+// ReadMem16 reads like adi.MemAP.ReadMem16 after cleaning the data cache lines of the range.
+func (d *DAPTransferCoreAccess) ReadMem16(addr uint32, count int) ([]uint16, error) {
+	err := d.beforeRead(addr, count*2)
+	if err != nil {
+		return nil, err
+	}
+	return d.DAPTransferCoreAccess.ReadMem16(addr, count)
+}
+
+// ReadMem8 reads like adi.MemAP.ReadMem8 after cleaning the data cache lines of the range.
+func (d *DAPTransferCoreAccess) ReadMem8(addr uint32, count int) ([]uint8, error) {
+	err := d.beforeRead(addr, count)
+	if err != nil {
+		return nil, err
+	}
+	return d.DAPTransferCoreAccess.ReadMem8(addr, count)
+}
+
+// ReadMem reads like adi.MemAP.ReadMem after cleaning the data cache lines of the range.
+func (d *DAPTransferCoreAccess) ReadMem(addr uint32, length int) ([]byte, error) {
+	err := d.beforeRead(addr, length)
+	if err != nil {
+		return nil, err
+	}
+	return d.DAPTransferCoreAccess.ReadMem(addr, length)
+}
+
+// WriteAddr32 writes like adi.MemAP.WriteAddr32 keeping the caches coherent.
+func (d *DAPTransferCoreAccess) WriteAddr32(addr, value uint32) error {
+	return d.write(addr, 4, func() error {
+		return d.DAPTransferCoreAccess.WriteAddr32(addr, value)
+	})
+}
+
+// WriteSeqAddr32 writes like adi.MemAP.WriteSeqAddr32 keeping the caches coherent.
+func (d *DAPTransferCoreAccess) WriteSeqAddr32(addr uint32, value []uint32) error {
+	return d.write(addr, len(value)*4, func() error {
+		return d.DAPTransferCoreAccess.WriteSeqAddr32(addr, value)
+	})
+}
+
+// WriteMem16 writes like adi.MemAP.WriteMem16 keeping the caches coherent.
+func (d *DAPTransferCoreAccess) WriteMem16(addr uint32, value uint16) error {
+	return d.write(addr, 2, func() error {
+		return d.DAPTransferCoreAccess.WriteMem16(addr, value)
+	})
+}
+
+// WriteMem8 writes like adi.MemAP.WriteMem8 keeping the caches coherent.
+func (d *DAPTransferCoreAccess) WriteMem8(addr uint32, value uint8) error {
+	return d.write(addr, 1, func() error {
+		return d.DAPTransferCoreAccess.WriteMem8(addr, value)
+	})
+}
+
+// WriteMem writes like adi.MemAP.WriteMem keeping the caches coherent.
+func (d *DAPTransferCoreAccess) WriteMem(addr uint32, b []byte) error {
+	return d.write(addr, len(b), func() error {
+		return d.DAPTransferCoreAccess.WriteMem(addr, b)
+	})
+}
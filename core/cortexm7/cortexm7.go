@@ -0,0 +1,142 @@
+// Package cortexm7 is the Cortex-M7 (ARMv7E-M) core.  Its FPB is revision 2,
+// matching anywhere in the address space, its DWT is the ARMv7-M one and the
+// FPU may be single or double precision.  The debugger accesses memory behind
+// the L1 caches, so the memory writes of this package clean and invalidate
+// the data cache around them and invalidate the instruction cache after them.
+package cortexm7
+
+import (
+	"goocd/core/adi"
+	"goocd/core/cortexm"
+)
+
+// Cache identification and control registers
+const (
+	ConfigurationControlRegister = 0xE000ED14
+	ConfigurationControlDCache   = 0x10000
+	ConfigurationControlICache   = 0x20000
+
+	CacheLevelIDRegister     = 0xE000ED78
+	CacheLevelIDCType1Mask   = 0x7
+	CacheLevelIDCType1ICache = 0x1
+	CacheLevelIDCType1DCache = 0x2
+
+	CacheSizeIDRegister          = 0xE000ED80
+	CacheSizeIDLineSizeMask      = 0x7 // log2(words per line) - 2
+	CacheSizeIDAssociativityMask = 0x1FF8
+	CacheSizeIDAssociativityPos  = 3
+	CacheSizeIDNumSetsMask       = 0xFFFE000
+	CacheSizeIDNumSetsPos        = 13
+
+	CacheSizeSelectionRegister = 0xE000ED84
+	CacheSizeSelectionDCache   = 0x0
+	CacheSizeSelectionICache   = 0x1
+)
+
+// Cache maintenance operations, written with an address (MVA) or a set/way
+const (
+	ICacheInvalidateAll         = 0xE000EF50
+	DCacheInvalidateMVA         = 0xE000EF5C
+	DCacheCleanMVA              = 0xE000EF68
+	DCacheCleanInvalidateMVA    = 0xE000EF70
+	DCacheCleanInvalidateSetWay = 0xE000EF74
+)
+
+// The default memory map only caches the Code, SRAM and external RAM regions
+const (
+	CacheableRegionEnd    = 0xA0000000
+	PeripheralRegionStart = 0x40000000
+	PeripheralRegionEnd   = 0x60000000
+)
+
+// CacheInfo describes an L1 cache as found in CCSIDR.
+type CacheInfo struct {
+	Present  bool
+	LineSize uint32 // bytes
+	Ways     uint32
+	Sets     uint32
+}
+
+// Size is the cache size in bytes.
+func (c CacheInfo) Size() uint32 {
+	return c.LineSize * c.Ways * c.Sets
+}
+
+// SpecialRegisters are the registers packed in CFBP, all of which exist on ARMv7-M.
+var SpecialRegisters = []cortexm.Register{cortexm.PRIMASK, cortexm.BASEPRI, cortexm.FAULTMASK, cortexm.CONTROL}
+
+type DAPTransferCoreAccess struct {
+	cortexm.DAPTransferCoreAccess
+
+	DCache CacheInfo
+	ICache CacheInfo
+}
+
+// New returns a DAPTransferCoreAccess talking to the core through t.
+func New(t adi.DAPTransferer) *DAPTransferCoreAccess {
+	return &DAPTransferCoreAccess{DAPTransferCoreAccess: cortexm.DAPTransferCoreAccess{MemAP: adi.MemAP{DAPTransferer: t}}}
+}
+
+// Configure powers up the Debug Port and detects the caches.
+func (d *DAPTransferCoreAccess) Configure() error {
+	err := d.DAPTransferCoreAccess.Configure()
+	if err != nil {
+		return err
+	}
+	return d.DetectCaches()
+}
+
+// DetectCaches reads CLIDR and the CCSIDR of each L1 cache that is implemented.
+func (d *DAPTransferCoreAccess) DetectCaches() error {
+	vals, err := d.DAPTransferCoreAccess.ReadAddr32(CacheLevelIDRegister, 1)
+	if err != nil {
+		return err
+	}
+	ctype := vals[0] & CacheLevelIDCType1Mask
+
+	d.DCache, d.ICache = CacheInfo{}, CacheInfo{}
+	if ctype&CacheLevelIDCType1DCache > 0 {
+		d.DCache, err = d.cacheInfo(CacheSizeSelectionDCache)
+		if err != nil {
+			return err
+		}
+	}
+	if ctype&CacheLevelIDCType1ICache > 0 {
+		d.ICache, err = d.cacheInfo(CacheSizeSelectionICache)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DAPTransferCoreAccess) cacheInfo(selection uint32) (CacheInfo, error) {
+	err := d.DAPTransferCoreAccess.WriteAddr32(CacheSizeSelectionRegister, selection)
+	if err != nil {
+		return CacheInfo{}, err
+	}
+	vals, err := d.DAPTransferCoreAccess.ReadAddr32(CacheSizeIDRegister, 1)
+	if err != nil {
+		return CacheInfo{}, err
+	}
+	ccsidr := vals[0]
+	return CacheInfo{
+		Present:  true,
+		LineSize: 16 << (ccsidr & CacheSizeIDLineSizeMask),
+		Ways:     (ccsidr&CacheSizeIDAssociativityMask)>>CacheSizeIDAssociativityPos + 1,
+		Sets:     (ccsidr&CacheSizeIDNumSetsMask)>>CacheSizeIDNumSetsPos + 1,
+	}, nil
+}
+
+// CoreRegisters returns every register worth showing on a halted core, the FP registers included when the FPU is implemented.
+func (d *DAPTransferCoreAccess) CoreRegisters() ([]cortexm.Register, error) {
+	regs := append(append([]cortexm.Register{}, cortexm.IntegerRegisters...), SpecialRegisters...)
+	fpu, err := d.HasFPU()
+	if err != nil {
+		return nil, err
+	}
+	if fpu {
+		regs = append(regs, cortexm.FPRegisters()...)
+	}
+	return regs, nil
+}
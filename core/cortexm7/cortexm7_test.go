@@ -0,0 +1,123 @@
+package cortexm7
+
+import (
+	"testing"
+
+	"goocd/core/cortexm"
+	"goocd/probes/simprobe"
+)
+
+// newSim is a Cortex-M7 with a 16KB 4 way data cache and a 16KB 2 way instruction cache, both enabled.
+func newSim(ops map[uint32][]uint32) *simprobe.Probe {
+	sim := &simprobe.Probe{}
+	sim.WriteWord(CacheLevelIDRegister, CacheLevelIDCType1ICache|CacheLevelIDCType1DCache)
+	sim.WriteWord(ConfigurationControlRegister, ConfigurationControlDCache|ConfigurationControlICache)
+	sim.OnWrite = func(addr, value uint32) {
+		switch addr {
+		case CacheSizeSelectionRegister:
+			if value == CacheSizeSelectionDCache {
+				sim.WriteWord(CacheSizeIDRegister, 1|3<<CacheSizeIDAssociativityPos|127<<CacheSizeIDNumSetsPos)
+			} else {
+				sim.WriteWord(CacheSizeIDRegister, 1|1<<CacheSizeIDAssociativityPos|255<<CacheSizeIDNumSetsPos)
+			}
+		case ICacheInvalidateAll, DCacheInvalidateMVA, DCacheCleanMVA, DCacheCleanInvalidateMVA, DCacheCleanInvalidateSetWay:
+			ops[addr] = append(ops[addr], value)
+		}
+	}
+	return sim
+}
+
+func TestDAPTransferCoreAccess_Caches(t *testing.T) {
+	ops := make(map[uint32][]uint32)
+	sim := newSim(ops)
+	core := New(sim)
+	if err := core.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	if core.DCache.Size() != 16384 || core.DCache.Ways != 4 || core.ICache.Size() != 16384 || core.ICache.Ways != 2 {
+		t.Fatalf("unexpected caches %+v %+v", core.DCache, core.ICache)
+	}
+
+	if err := core.WriteSeqAddr32(0x20400010, make([]uint32, 8)); err != nil {
+		t.Fatal(err)
+	}
+	lines := []uint32{0x20400000, 0x20400020}
+	for _, op := range []uint32{DCacheCleanInvalidateMVA, DCacheInvalidateMVA} {
+		if len(ops[op]) != 2 || ops[op][0] != lines[0] || ops[op][1] != lines[1] {
+			t.Fatalf("op 0x%x: expected lines %x, got %x", op, lines, ops[op])
+		}
+	}
+	if len(ops[ICacheInvalidateAll]) != 1 {
+		t.Fatalf("expected the instruction cache to be invalidated once")
+	}
+
+	if _, err := core.ReadAddr32(0x20400000, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(ops[DCacheCleanMVA]) != 1 {
+		t.Fatalf("expected a clean before the read, got %x", ops[DCacheCleanMVA])
+	}
+
+	// Peripherals and the debug registers aren't cached
+	for k := range ops {
+		delete(ops, k)
+	}
+	if err := core.WriteAddr32(0x400E0C04, 1); err != nil {
+		t.Fatal(err)
+	}
+	fpb := &cortexm.FPB{MemoryAccess: core}
+	sim.WriteWord(cortexm.FlashPatchCTRLRegister, 0x10000080) // FPBv2, 8 comparators
+	if err := fpb.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fpb.SetBreakpoint(0x60000100); err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 0 {
+		t.Fatalf("expected no cache maintenance, got %x", ops)
+	}
+
+	if err := core.InvalidateCaches(); err != nil {
+		t.Fatal(err)
+	}
+	setWay := ops[DCacheCleanInvalidateSetWay]
+	if len(setWay) != 512 || setWay[511] != 3<<30|127<<5 {
+		t.Fatalf("expected 512 set/way operations ending with way 3 set 127, got %d", len(setWay))
+	}
+
+	// Nothing to maintain while the firmware hasn't enabled the caches
+	for k := range ops {
+		delete(ops, k)
+	}
+	sim.WriteWord(ConfigurationControlRegister, 0)
+	if err := core.WriteMem8(0x20400001, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 0 {
+		t.Fatalf("expected no cache maintenance with caches disabled, got %x", ops)
+	}
+}
+
+func TestDAPTransferCoreAccess_DWT(t *testing.T) {
+	sim := newSim(make(map[uint32][]uint32))
+	sim.WriteWord(cortexm.DataWatchpointCTRLRegister, 0x40000000)
+	core := New(sim)
+	if err := core.Configure(); err != nil {
+		t.Fatal(err)
+	}
+
+	dwt := &cortexm.DWT{MemoryAccess: core}
+	if err := dwt.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	if dwt.MaxMask != cortexm.DataWatchpointMaskMask {
+		t.Fatalf("expected MASK to implement 5 bits, got 0x%x", dwt.MaxMask)
+	}
+	wp, err := dwt.SetWatchpoint(0x20000000, 0x10000, cortexm.WatchWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sim.ReadWord(cortexm.DataWatchpointComparator0 + cortexm.DataWatchpointMaskOffset); wp.Comparator != 0 || got != 16 {
+		t.Fatalf("expected MASK 16 on comparator 0, got %d on %d", got, wp.Comparator)
+	}
+}
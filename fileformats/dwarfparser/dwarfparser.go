@@ -0,0 +1,256 @@
+// Package dwarfparser resolves the global variables of a firmware from the
+// DWARF debug information of its ELF file, so their memory read through the
+// probe can be shown as typed values.  A variable is named like in C, members
+// of structs picked with dots and array elements with brackets, e.g.
+// 'state.mode' or 'config.samples[1]'.  Without debug information the ELF
+// symbol table still gives the address and size, the value shows as an
+// unsigned number.  Values are little endian like on every Cortex-M.
+package dwarfparser
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"goocd/fileformats/elfparser"
+	"strconv"
+	"strings"
+)
+
+// DW_OP_addr, the location of a variable at a fixed address
+const opAddr = 0x03
+
+// Info is the global variables of an ELF file.
+type Info struct {
+	data      *dwarf.Data // nil without debug information
+	variables map[string]global
+	symbols   elfparser.SymbolTable
+}
+
+// global is a variable entry of the debug information.
+type global struct {
+	addr    uint32
+	typeOff dwarf.Offset
+}
+
+// Variable is a global variable, or a member of one.
+type Variable struct {
+	Name string
+	Addr uint32
+	Size uint32
+	Type dwarf.Type // nil for a variable only known from the symbol table
+	// BitSize and BitOffset pick a bit field out of the Size bytes at Addr, BitOffset counts from the least significant bit
+	BitSize   uint32
+	BitOffset uint32
+}
+
+// Load reads the global variables of the ELF file at path.
+func Load(path string) (*Info, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error: dwarfparser.Load() %w", err)
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read reads the global variables of f.
+func Read(f *elf.File) (*Info, error) {
+	symbols, err := elfparser.ReadSymbols(f)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{variables: make(map[string]global), symbols: symbols}
+	if f.Section(".debug_info") == nil {
+		return info, nil
+	}
+	info.data, err = f.DWARF()
+	if err != nil {
+		return nil, fmt.Errorf("error: dwarfparser.Read() %w", err)
+	}
+
+	r := info.data.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, fmt.Errorf("error: dwarfparser.Read() %w", err)
+		}
+		if e == nil {
+			return info, nil
+		}
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			// Its children are the globals, along with the functions and types
+			continue
+		case dwarf.TagVariable:
+			err = info.addVariable(e)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if e.Children {
+			r.SkipChildren()
+		}
+	}
+}
+
+// addVariable records the variable e when it has a fixed address, the name and type may be on the declaration it completes.
+func (i *Info) addVariable(e *dwarf.Entry) error {
+	loc, ok := e.Val(dwarf.AttrLocation).([]byte)
+	if !ok || len(loc) < 5 || loc[0] != opAddr {
+		return nil
+	}
+	name, _ := e.Val(dwarf.AttrName).(string)
+	typeOff, _ := e.Val(dwarf.AttrType).(dwarf.Offset)
+	if spec, ok := e.Val(dwarf.AttrSpecification).(dwarf.Offset); ok && (name == "" || typeOff == 0) {
+		r := i.data.Reader()
+		r.Seek(spec)
+		decl, err := r.Next()
+		if err != nil || decl == nil {
+			return fmt.Errorf("error: dwarfparser.Read() no declaration at 0x%x: %v", spec, err)
+		}
+		if name == "" {
+			name, _ = decl.Val(dwarf.AttrName).(string)
+		}
+		if typeOff == 0 {
+			typeOff, _ = decl.Val(dwarf.AttrType).(dwarf.Offset)
+		}
+	}
+	if name == "" || typeOff == 0 {
+		return nil
+	}
+	i.variables[name] = global{addr: binary.LittleEndian.Uint32(loc[1:]), typeOff: typeOff}
+	return nil
+}
+
+// Lookup resolves a global variable, or a part of one, written like in C, e.g. 'counter', 'state.uart.baud' or
+// 'config.samples[1]'.
+func (i *Info) Lookup(name string) (Variable, error) {
+	end := strings.IndexAny(name, ".[")
+	if end < 0 {
+		end = len(name)
+	}
+	v, err := i.global(name[:end])
+	if err != nil {
+		return v, err
+	}
+	for rest := name[end:]; rest != ""; {
+		switch rest[0] {
+		case '.':
+			end = strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			v, err = v.member(rest[1 : end+1])
+			rest = rest[end+1:]
+		case '[':
+			end = strings.IndexByte(rest, ']')
+			if end < 0 {
+				return v, fmt.Errorf("error: dwarfparser.Lookup() missing ] in %q", name)
+			}
+			var index uint64
+			index, err = strconv.ParseUint(rest[1:end], 0, 32)
+			if err != nil {
+				return v, fmt.Errorf("error: dwarfparser.Lookup() bad index in %q: %w", name, err)
+			}
+			v, err = v.element(uint32(index))
+			rest = rest[end+1:]
+		default:
+			return v, fmt.Errorf("error: dwarfparser.Lookup() unexpected %q in %q", rest[0], name)
+		}
+		if err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+// global finds a variable in the debug information, or else in the symbol table.
+func (i *Info) global(name string) (Variable, error) {
+	g, ok := i.variables[name]
+	if ok {
+		t, err := i.data.Type(g.typeOff)
+		if err != nil {
+			return Variable{}, fmt.Errorf("error: dwarfparser.Lookup() type of %s: %w", name, err)
+		}
+		return Variable{Name: name, Addr: g.addr, Size: uint32(t.Size()), Type: t}, nil
+	}
+	sym, ok := i.symbols.Find(name)
+	if !ok || sym.Func {
+		return Variable{}, fmt.Errorf("error: dwarfparser.Lookup() no global variable %s", name)
+	}
+	return Variable{Name: name, Addr: sym.Addr, Size: sym.Size}, nil
+}
+
+// member is the struct or union member called name.
+func (v Variable) member(name string) (Variable, error) {
+	st, ok := underlying(v.Type).(*dwarf.StructType)
+	if !ok || v.BitSize > 0 {
+		return Variable{}, fmt.Errorf("error: dwarfparser.Lookup() %s is not a struct or union, it has no member %s", v.Name, name)
+	}
+	for _, f := range st.Field {
+		if f.Name != name {
+			continue
+		}
+		offset, size, bitOffset, bitSize := fieldLayout(f)
+		return Variable{
+			Name:      v.Name + "." + name,
+			Addr:      v.Addr + offset,
+			Size:      size,
+			Type:      f.Type,
+			BitSize:   bitSize,
+			BitOffset: bitOffset,
+		}, nil
+	}
+	return Variable{}, fmt.Errorf("error: dwarfparser.Lookup() %s has no member %s", v.Name, name)
+}
+
+// element is the array element at index.
+func (v Variable) element(index uint32) (Variable, error) {
+	at, ok := underlying(v.Type).(*dwarf.ArrayType)
+	if !ok {
+		return Variable{}, fmt.Errorf("error: dwarfparser.Lookup() %s is not an array", v.Name)
+	}
+	if at.Count >= 0 && int64(index) >= at.Count {
+		return Variable{}, fmt.Errorf("error: dwarfparser.Lookup() index %d is out of range for %s of %d elements", index, v.Name, at.Count)
+	}
+	size := uint32(at.Type.Size())
+	return Variable{
+		Name: fmt.Sprintf("%s[%d]", v.Name, index),
+		Addr: v.Addr + index*size,
+		Size: size,
+		Type: at.Type,
+	}, nil
+}
+
+// fieldLayout is where a member lives in its struct, bit fields are bitSize bits from bit bitOffset of the size bytes at offset.
+func fieldLayout(f *dwarf.StructField) (offset, size, bitOffset, bitSize uint32) {
+	if f.BitSize == 0 {
+		return uint32(f.ByteOffset), uint32(f.Type.Size()), 0, 0
+	}
+	// DWARF 2 style bit fields come with the size of their storage unit and count the bits from its most significant
+	// one, DWARF 4 style ones count from the start of the struct
+	var bit int64
+	if f.ByteSize > 0 {
+		bit = f.ByteOffset*8 + f.ByteSize*8 - f.BitOffset - f.BitSize
+	} else {
+		bit = f.DataBitOffset
+	}
+	offset = uint32(bit / 8)
+	bitOffset = uint32(bit % 8)
+	return offset, (bitOffset + uint32(f.BitSize) + 7) / 8, bitOffset, uint32(f.BitSize)
+}
+
+// underlying strips the typedefs and qualifiers off t.
+func underlying(t dwarf.Type) dwarf.Type {
+	for {
+		switch tt := t.(type) {
+		case *dwarf.TypedefType:
+			t = tt.Type
+		case *dwarf.QualType:
+			t = tt.Type
+		default:
+			return t
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package dwarfparser
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"testing"
+)
+
+// memory reads the initial values of vars.elf's globals from its .data section.
+func memory(t *testing.T, v Variable) []byte {
+	t.Helper()
+	f, err := elf.Open("../testdata/vars.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data := f.Section(".data")
+	b, err := data.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := v.Addr - uint32(data.Addr)
+	return b[start : start+v.Size]
+}
+
+// stringAddr finds the address of the string literal s in vars.elf's .rodata, so the tests don't depend on where the
+// compiler put it.
+func stringAddr(t *testing.T, s string) uint32 {
+	t.Helper()
+	f, err := elf.Open("../testdata/vars.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rodata := f.Section(".rodata")
+	b, err := rodata.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(b, append([]byte(s), 0))
+	if i < 0 {
+		t.Fatalf("expected %q in .rodata", s)
+	}
+	return uint32(rodata.Addr) + uint32(i)
+}
+
+func TestInfo_Lookup(t *testing.T) {
+	info, err := Load("../testdata/vars.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := info.Lookup("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{"counter", "42"},
+		{"state.mode", "MODE_RUN"},
+		{"state.temperature", "-40"},
+		{"state.gain", "1.5"},
+		{"state.ready", "1"},
+		{"state.level", "-3"},
+		{"state.uart.baud", "115200"},
+		{"state.uart", "{baud: 115200, parity: 2}"},
+		{"config", "{uart: {baud: 9600, parity: 0}, samples: [-1, 0, 1], scale: 0.25, enabled: true}"},
+		{"state", fmt.Sprintf("{mode: MODE_RUN, temperature: -40, gain: 1.5, ready: 1, level: -3, uart: {baud: 115200, parity: 2}, history: [1, 2, 3, 4], name: 0x%08x}", stringAddr(t, "main"))},
+		{"console", fmt.Sprintf("0x%08x", config.Addr)},
+		{"config.samples[2]", "1"},
+		{"state.history[0x3]", "4"},
+		{"config.uart.baud", "9600"},
+	} {
+		v, err := info.Lookup(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := v.Format(memory(t, v)); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	for _, name := range []string{"bogus", "counter.bogus", "state.bogus", "state.ready.bogus", "config.samples[3]", "counter[0]", "config.samples[1", "config.samples[x]", "config]"} {
+		if _, err := info.Lookup(name); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestInfo_Lookup_Layout(t *testing.T) {
+	f, err := elf.Open("../testdata/vars.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if f.Machine != elf.EM_ARM {
+		t.Fatalf("expected an ARM vars.elf, got %v", f.Machine)
+	}
+	info, err := Load("../testdata/vars.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The ARM EABI layout: short enums, bit fields in their int container and doubles 8 byte aligned
+	for _, tt := range []struct {
+		name                         string
+		parent                       string
+		offset, size, bitOff, bitLen uint32
+	}{
+		{"state.mode", "state", 0, 1, 0, 0},
+		{"state.temperature", "state", 2, 2, 0, 0},
+		{"state.ready", "state", 8, 1, 0, 1},
+		{"state.level", "state", 8, 1, 1, 3},
+		{"state.name", "state", 24, 4, 0, 0},
+		{"config.samples[1]", "config", 12, 4, 0, 0},
+		{"config.scale", "config", 24, 8, 0, 0},
+		{"config.enabled", "config", 32, 1, 0, 0},
+	} {
+		parent, err := info.Lookup(tt.parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := info.Lookup(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Addr-parent.Addr != tt.offset || v.Size != tt.size || v.BitOffset != tt.bitOff || v.BitSize != tt.bitLen {
+			t.Errorf("%s: expected %d bytes at offset %d bits %d+%d, got %d at %d bits %d+%d", tt.name, tt.size, tt.offset, tt.bitOff, tt.bitLen,
+				v.Size, v.Addr-parent.Addr, v.BitOffset, v.BitSize)
+		}
+	}
+}
+
+func TestInfo_Lookup_Symbols(t *testing.T) {
+	// Only the symbol table, the ARM example has no debug information
+	info, err := Load("../testdata/example1.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := info.Lookup("main"); err == nil {
+		t.Fatalf("expected functions not to be variables")
+	}
+	for _, sym := range info.symbols {
+		if sym.Func || sym.Size == 0 || sym.Size > 8 {
+			continue
+		}
+		v, err := info.Lookup(sym.Name)
+		if err != nil || v.Addr != sym.Addr || v.Type != nil {
+			t.Fatalf("expected %s from the symbol table, got %+v %v", sym.Name, v, err)
+		}
+		if got := v.Format(make([]byte, v.Size)); got != "0" {
+			t.Fatalf("expected an unsigned number, got %q", got)
+		}
+		return
+	}
+	t.Fatalf("expected an object symbol in the example")
+}
+
+func TestVariable_Render(t *testing.T) {
+	info, err := Load("../testdata/vars.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := info.Lookup("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{"counter", "42"},
+		{"config.samples", "[3]int32_t{-1, 0, 1}"},
+		{"console", fmt.Sprintf("(*uart)(0x%08x)", config.Addr)},
+		{"config", `config_t{
+	uart: uart{
+		baud: 9600,
+		parity: 0,
+	},
+	samples: [3]int32_t{-1, 0, 1},
+	scale: 0.25,
+	enabled: true,
+}`},
+	} {
+		v, err := info.Lookup(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := v.Render(memory(t, v)); got != tt.want {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.name, tt.want, got)
+		}
+	}
+
+	v, err := info.Lookup("state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if TypeName(v.Type) != "state" || TypeName(v.Type.(*dwarf.StructType).Field[7].Type) != "*char" {
+		t.Fatalf("unexpected type names %s %s", TypeName(v.Type), TypeName(v.Type.(*dwarf.StructType).Field[7].Type))
+	}
+}
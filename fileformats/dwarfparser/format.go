@@ -0,0 +1,219 @@
+package dwarfparser
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Format renders b, the Size bytes read at Addr, as a value of the variable's type on one line: numbers, enum names,
+// floats, arrays as [a, b, ...] and structs as {member: value, ...}.
+func (v Variable) Format(b []byte) string {
+	return printer{}.variable(v, b, "")
+}
+
+// Render renders b, the Size bytes read at Addr, as a value of the variable's type the way Go would write it, composite
+// literals with the type names, e.g. 'samples: [3]int32_t{-1, 0, 1}', and structs a member per line.  Char arrays
+// show as the string they hold, pointers with the type they point to.
+func (v Variable) Render(b []byte) string {
+	return printer{goSyntax: true}.variable(v, b, "")
+}
+
+// printer writes values in the compact style of Format, or the Go style of Render.
+type printer struct {
+	goSyntax bool
+}
+
+// variable renders the value of v in b, indent is that of the line it starts on.
+func (p printer) variable(v Variable, b []byte, indent string) string {
+	if len(b) < int(v.Size) {
+		return fmt.Sprintf("<%d of %d bytes>", len(b), v.Size)
+	}
+	b = b[:v.Size]
+	if v.BitSize > 0 {
+		return scalar(v.Type, bits(b, v.BitOffset, v.BitSize), int(v.BitSize))
+	}
+	if v.Type == nil {
+		if len(b) > 8 {
+			return hex.EncodeToString(b)
+		}
+		return strconv.FormatUint(bits(b, 0, uint32(len(b))*8), 10)
+	}
+	return p.value(v.Type, b, indent)
+}
+
+// value renders b as a value of type t.
+func (p printer) value(t dwarf.Type, b []byte, indent string) string {
+	switch ut := underlying(t).(type) {
+	case *dwarf.StructType:
+		return p.structValue(t, ut, b, indent)
+	case *dwarf.ArrayType:
+		return p.arrayValue(t, ut, b, indent)
+	case *dwarf.PtrType:
+		v := bits(b, 0, uint32(len(b))*8)
+		if p.goSyntax {
+			return fmt.Sprintf("(%s)(0x%08x)", TypeName(t), v)
+		}
+		return fmt.Sprintf("0x%08x", v)
+	}
+	if len(b) > 8 {
+		return hex.EncodeToString(b)
+	}
+	return scalar(t, bits(b, 0, uint32(len(b))*8), len(b)*8)
+}
+
+func (p printer) structValue(t dwarf.Type, st *dwarf.StructType, b []byte, indent string) string {
+	var s strings.Builder
+	if p.goSyntax {
+		s.WriteString(TypeName(t))
+	}
+	s.WriteString("{")
+	inner := indent + "\t"
+	for i, f := range st.Field {
+		switch {
+		case p.goSyntax:
+			s.WriteString("\n" + inner)
+		case i > 0:
+			s.WriteString(", ")
+		}
+		offset, size, bitOffset, bitSize := fieldLayout(f)
+		if int(offset+size) > len(b) {
+			fmt.Fprintf(&s, "%s: <incomplete>", f.Name)
+		} else {
+			field := Variable{Size: size, Type: f.Type, BitSize: bitSize, BitOffset: bitOffset}
+			fmt.Fprintf(&s, "%s: %s", f.Name, p.variable(field, b[offset:offset+size], inner))
+		}
+		if p.goSyntax {
+			s.WriteString(",")
+		}
+	}
+	if p.goSyntax && len(st.Field) > 0 {
+		s.WriteString("\n" + indent)
+	}
+	s.WriteString("}")
+	return s.String()
+}
+
+func (p printer) arrayValue(t dwarf.Type, at *dwarf.ArrayType, b []byte, indent string) string {
+	size := int(at.Type.Size())
+	if p.goSyntax && isChar(at.Type) {
+		if nul := bytes.IndexByte(b, 0); nul >= 0 {
+			b = b[:nul]
+		}
+		return strconv.Quote(string(b))
+	}
+
+	var elems []string
+	multiline := false
+	for i := 0; size > 0 && i+size <= len(b); i += size {
+		elem := p.value(at.Type, b[i:i+size], indent+"\t")
+		multiline = multiline || strings.Contains(elem, "\n")
+		elems = append(elems, elem)
+	}
+	if !p.goSyntax {
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+	if !multiline {
+		return TypeName(t) + "{" + strings.Join(elems, ", ") + "}"
+	}
+	return TypeName(t) + "{\n" + indent + "\t" + strings.Join(elems, ",\n"+indent+"\t") + ",\n" + indent + "}"
+}
+
+// isChar reports whether t is one of C's char types.
+func isChar(t dwarf.Type) bool {
+	switch underlying(t).(type) {
+	case *dwarf.CharType, *dwarf.UcharType:
+		return true
+	}
+	return false
+}
+
+// scalar renders the n bit value v as type t.
+func scalar(t dwarf.Type, v uint64, n int) string {
+	switch t := underlying(t).(type) {
+	case *dwarf.BoolType:
+		return strconv.FormatBool(v != 0)
+	case *dwarf.IntType, *dwarf.CharType:
+		return strconv.FormatInt(signed(v, n), 10)
+	case *dwarf.UintType, *dwarf.UcharType:
+		return strconv.FormatUint(v, 10)
+	case *dwarf.FloatType:
+		switch n {
+		case 32:
+			return strconv.FormatFloat(float64(math.Float32frombits(uint32(v))), 'g', -1, 32)
+		case 64:
+			return strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64)
+		}
+	case *dwarf.EnumType:
+		for _, e := range t.Val {
+			if uint64(e.Val)&mask(n) == v {
+				return e.Name
+			}
+		}
+		return strconv.FormatInt(signed(v, n), 10)
+	}
+	return fmt.Sprintf("0x%x", v)
+}
+
+// TypeName is the name of t written the Go way: typedef and struct names, *T for pointers and [n]T for arrays.
+func TypeName(t dwarf.Type) string {
+	switch t := t.(type) {
+	case nil:
+		return "?"
+	case *dwarf.TypedefType:
+		return t.Name
+	case *dwarf.QualType:
+		return TypeName(t.Type)
+	case *dwarf.PtrType:
+		if _, ok := t.Type.(*dwarf.VoidType); ok {
+			return "unsafe.Pointer"
+		}
+		return "*" + TypeName(t.Type)
+	case *dwarf.ArrayType:
+		if t.Count < 0 {
+			return "[]" + TypeName(t.Type)
+		}
+		return fmt.Sprintf("[%d]%s", t.Count, TypeName(t.Type))
+	case *dwarf.StructType:
+		if t.StructName != "" {
+			return t.StructName
+		}
+		return t.Kind + "{...}"
+	case *dwarf.EnumType:
+		if t.EnumName != "" {
+			return t.EnumName
+		}
+		return "enum{...}"
+	case *dwarf.FuncType:
+		return "func"
+	}
+	return t.Common().Name
+}
+
+// bits reads the n bits from bit offset of the little endian b.
+func bits(b []byte, offset, n uint32) uint64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v >> offset & mask(int(n))
+}
+
+func mask(n int) uint64 {
+	if n >= 64 {
+		return math.MaxUint64
+	}
+	return 1<<n - 1
+}
+
+// signed sign extends the n bit value v.
+func signed(v uint64, n int) int64 {
+	if n >= 64 {
+		return int64(v)
+	}
+	return int64(v<<(64-n)) >> (64 - n)
+}
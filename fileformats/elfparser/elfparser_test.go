@@ -5,7 +5,7 @@ import (
 	"io"
 	"testing"
 
-	"github.com/goocd/goocd/fileformats"
+	"goocd/fileformats"
 )
 
 func TestELFParser(t *testing.T) {
@@ -41,3 +41,20 @@ func TestELFParser(t *testing.T) {
 	}
 
 }
+
+func TestSymbolTable_Lookup(t *testing.T) {
+	syms, err := LoadSymbols("../testdata/example1.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := syms.Lookup(0x08000600); !ok || s.Name != "main" || s.Addr != 0x08000594 {
+		t.Fatalf("expected main at 0x08000594, got %+v", s)
+	}
+	// Default_Handler and its weak aliases share an address
+	if s, ok := syms.Lookup(0x0800074C); !ok || s.Name != "Default_Handler" {
+		t.Fatalf("expected the global Default_Handler, got %+v", s)
+	}
+	if s, ok := syms.Find("AHBPrescTable"); !ok || s.Func || s.Size != 16 {
+		t.Fatalf("expected the AHBPrescTable object, got %+v", s)
+	}
+}
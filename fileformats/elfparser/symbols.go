@@ -0,0 +1,100 @@
+package elfparser
+
+import (
+	"debug/elf"
+	"sort"
+)
+
+// Symbol is a function or object symbol from the ELF symbol table.
+type Symbol struct {
+	Name   string
+	Addr   uint32 // without the Thumb bit for functions
+	Size   uint32
+	Func   bool
+	Global bool
+}
+
+// SymbolTable holds the function and object symbols of an ELF file sorted by address.
+type SymbolTable []Symbol
+
+// ReadSymbols reads the function and object symbols of f.
+func ReadSymbols(f *elf.File) (SymbolTable, error) {
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, objcopyError{"failed to read the ELF symbol table", err}
+	}
+
+	var t SymbolTable
+	for _, s := range syms {
+		typ := elf.ST_TYPE(s.Info)
+		bind := elf.ST_BIND(s.Info)
+		if s.Name == "" || s.Section == elf.SHN_UNDEF || int(s.Section) >= len(f.Sections) {
+			continue
+		}
+		// Assembly labels like Default_Handler carry no type, only take the global sized ones, not the $t and $d mapping symbols
+		if typ != elf.STT_FUNC && typ != elf.STT_OBJECT && (typ != elf.STT_NOTYPE || bind != elf.STB_GLOBAL || s.Size == 0) {
+			continue
+		}
+		sym := Symbol{
+			Name:   s.Name,
+			Addr:   uint32(s.Value),
+			Size:   uint32(s.Size),
+			Func:   typ == elf.STT_FUNC || typ == elf.STT_NOTYPE && f.Sections[s.Section].Flags&elf.SHF_EXECINSTR > 0,
+			Global: bind == elf.STB_GLOBAL,
+		}
+		if sym.Func {
+			sym.Addr &^= 1
+		}
+		t = append(t, sym)
+	}
+	// Aliases share an address, put the global and sized ones first so Lookup picks them
+	sort.SliceStable(t, func(i, j int) bool {
+		if t[i].Addr != t[j].Addr {
+			return t[i].Addr < t[j].Addr
+		}
+		if t[i].Global != t[j].Global {
+			return t[i].Global
+		}
+		return t[i].Size > t[j].Size
+	})
+	return t, nil
+}
+
+// LoadSymbols reads the function and object symbols of the ELF file at path.
+func LoadSymbols(path string) (SymbolTable, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, objcopyError{"failed to open ELF file to read symbols", err}
+	}
+	defer f.Close()
+	return ReadSymbols(f)
+}
+
+// Lookup finds the symbol addr falls in, symbols without a size only match their own address.
+func (t SymbolTable) Lookup(addr uint32) (Symbol, bool) {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Addr > addr })
+	for j := i - 1; j >= 0; j-- {
+		if t[j].covers(addr) {
+			// Aliases sort global and sized first
+			for j > 0 && t[j-1].Addr == t[j].Addr && t[j-1].covers(addr) {
+				j--
+			}
+			return t[j], true
+		}
+	}
+	return Symbol{}, false
+}
+
+func (s Symbol) covers(addr uint32) bool {
+	return addr == s.Addr || addr-s.Addr < s.Size
+}
+
+// Find looks a symbol up by name.
+func (t SymbolTable) Find(name string) (Symbol, bool) {
+	for _, s := range t {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Symbol{}, false
+}
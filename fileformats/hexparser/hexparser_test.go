@@ -5,7 +5,7 @@ import (
 	"io"
 	"testing"
 
-	"github.com/goocd/goocd/fileformats"
+	"goocd/fileformats"
 )
 
 func TestParseFromPath(t *testing.T) {
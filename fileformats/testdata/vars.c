@@ -0,0 +1,45 @@
+// Globals for the dwarfparser tests, a Cortex-M4 ELF with DWARF in the ARM
+// EABI data layout of arm-none-eabi-gcc -mcpu=cortex-m4: 8 byte aligned
+// doubles, short enums, unsigned char and long 32-bit stdint types.  The host
+// gcc compiles it with that layout and LLVM assembles and links it for Thumb:
+//
+//	gcc -m32 -malign-double -malign-data=abi -fshort-enums -funsigned-char \
+//	    -U__INT32_TYPE__ -D__INT32_TYPE__="long int" \
+//	    -U__UINT32_TYPE__ -D__UINT32_TYPE__="long unsigned int" \
+//	    -gdwarf-4 -O0 -ffreestanding -fno-asynchronous-unwind-tables \
+//	    -fdebug-prefix-map=$PWD=. -S -o - vars.c |
+//	  sed -e 's/@progbits/%progbits/; s/@object/%object/' \
+//	      -e 's/^\t\.align\s*\([0-9]*\)/\t.balign \1/; s/^\t\.value\t/\t.short\t/' > vars.s
+//	llvm-mc -triple=thumbv7em-none-eabi -mcpu=cortex-m4 -dwarf-version=4 -filetype=obj -o vars.o vars.s
+//	ld.lld -static -e 0 -Ttext=0 -Tdata=0x20000000 -o vars.elf vars.o
+#include <stdint.h>
+
+enum mode { MODE_IDLE, MODE_RUN, MODE_FAULT = 7 };
+
+struct uart {
+	uint32_t baud;
+	uint8_t parity;
+};
+
+struct state {
+	enum mode mode;
+	int16_t temperature;
+	float gain;
+	unsigned ready : 1;
+	int level : 3;
+	struct uart uart;
+	uint8_t history[4];
+	const char *name;
+};
+
+typedef struct {
+	struct uart uart;
+	int32_t samples[3];
+	double scale;
+	_Bool enabled;
+} config_t;
+
+volatile uint32_t counter = 42;
+struct state state = {MODE_RUN, -40, 1.5f, 1, -3, {115200, 2}, {1, 2, 3, 4}, "main"};
+config_t config = {{9600, 0}, {-1, 0, 1}, 0.25, 1};
+struct uart *console = &config.uart;
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"goocd/targets"
 )
@@ -18,16 +19,63 @@ func main() {
 	loadF := flag.String("load", "", "Load program file (.elf, .hex, .bin) to flash, base address implied from file or defaults based on target")
 	readmemu32 := flag.String("readmemu32", "", "uint32 memory address you wish to read followed by optional 32-bit word count, e.g. '0x20004000,5'")
 	writememu32 := flag.String("writememu32", "", "uint32 memory address and value you wish to write and optional 32-bit word count, comma separated, e.g. '0x20004000,0xF0E0D0C0,1'")
-	reset := flag.Bool("reset", false, "Issue Reset Command to target")
+	readmemu8 := flag.String("readmemu8", "", "memory address you wish to read bytes from followed by optional byte count, any alignment, e.g. '0x41002001,3'")
+	readmemu16 := flag.String("readmemu16", "", "uint16 memory address you wish to read followed by optional 16-bit halfword count, e.g. '0x41004018,1'")
+	writememu8 := flag.String("writememu8", "", "memory address and uint8 value you wish to write and optional byte count, comma separated, e.g. '0x41002001,0x1'")
+	writememu16 := flag.String("writememu16", "", "uint16 memory address and value you wish to write and optional 16-bit halfword count, comma separated, e.g. '0x41004018,0x4'")
+	regs := flag.Bool("regs", false, "Halt the core and dump all core registers")
+	setreg := flag.String("setreg", "", "Halt the core and write a core register, name and value comma separated, e.g. 'pc,0x20000000'")
+	halt := flag.Bool("halt", false, "Halt the core")
+	step := flag.Bool("step", false, "Single step one instruction on a halted core")
+	maskints := flag.Bool("maskints", false, "Mask interrupts while single stepping")
+	resume := flag.Bool("resume", false, "Resume a halted core")
+	status := flag.Bool("status", false, "Print whether the core is running, halted, sleeping, in lockup or was reset, along with the security state on TrustZone targets")
+	breakF := flag.String("break", "", "Reset the target, run to a hardware breakpoint at the address and print the registers, e.g. '0x412'")
+	watchpoint := flag.String("watchpoint", "", "Set a data watchpoint and run until it fires, address, size in bytes and access kind (rw, r, w) comma separated, e.g. '0x20000100,4,w'")
+	cyclesF := flag.String("cycles", "", "Reset the target and time the code from one address to another in core cycles with the DWT cycle counter, start and end comma separated, e.g. '0x412,0x430'")
+	iterations := flag.Int("iterations", 1, "How many times -cycles times the code, printing the statistics of the runs")
+	cpuClock := flag.Float64("cpuclock", 0, "Core clock in Hz, for -cycles to print times as well as cycles, e.g. '48e6'")
+	timeout := flag.Duration("timeout", 10*time.Second, "How long to wait for the core to halt when running to a breakpoint or watchpoint, or for the application to exit with -semihosting")
+	fault := flag.Bool("fault", false, "Halt the core, decode the fault status registers (CFSR, HFSR, DFSR, MMFAR, BFAR, AFSR, SFSR, SFAR) and unwind the exception frame to the faulting PC, LR and xPSR")
+	backtraceF := flag.Bool("backtrace", false, "Halt the core and print the call stack, symbolized and unwound through exception frames using the ELF file given with -elf")
+	elfF := flag.String("elf", "", "ELF file of the firmware running on the target, for its symbols and debug information")
+	coredumpF := flag.String("coredump", "", "Halt the core and write its registers and the target's RAM and peripheral regions to an ELF core file for gdb, e.g. 'out.elf'")
+	semihostingF := flag.Bool("semihosting", false, "Run the core servicing its semihosting calls (BKPT 0xAB) until it exits, console on stdin/stdout, exiting with the target's exit code")
+	semihostingDir := flag.String("semihostingdir", ".", "Directory the target's semihosting file operations are confined to")
+	rttF := flag.Bool("rtt", false, "Stream SEGGER RTT channel 0 to stdout and stdin to the target until interrupted, the control block is found with -elf or by scanning RAM")
+	profileF := flag.Duration("profile", 0, "Sample the PC of the running core through DWT PCSR for this long and print a flat profile by function with the symbols of -elf, e.g. '10s'")
+	pprofF := flag.String("pprof", "", "Also write the -profile samples to this file in the pprof format for 'go tool pprof', e.g. 'fw.pb.gz'")
+	printF := flag.String("print", "", "Read global variables, or members and array elements of them, and print them as typed values with their types from -elf, comma separated, e.g. 'config.uart.baud,state'")
+	watchF := flag.String("watch", "", "Poll global variables, or members of them, while the core runs until interrupted, with their addresses and types from -elf, comma separated, e.g. 'counter,state.mode'")
+	interval := flag.Duration("interval", 100*time.Millisecond, "How often -watch polls the variables")
+	csvF := flag.Bool("csv", false, "Print the -watch values as CSV, a row per poll, instead of a table")
+	portF := flag.Int("port", 3333, "TCP port the gdbserver command listens on, and the serve-api command instead of its 4444, the shell command is served over TCP when it's given")
+	bindF := flag.String("bind", "127.0.0.1", "Address the gdbserver and serve-api commands, and the shell command with -port, listen on, e.g. '0.0.0.0' to serve every interface")
+	loadDirF := flag.String("loaddir", ".", "Directory the shell command's load and verify, and the serve-api command's flash and verify, open files in")
+	socketF := flag.String("socket", "", "Unix socket the serve-api command listens on instead of -port")
+	reset := &resetFlag{}
+	flag.Var(reset, "reset", "Issue Reset Command to target, optionally picking the strategy and whether to halt at the reset vector, e.g. '-reset', '-reset=sys', '-reset=hw,halt' (strategies: hw, sys, vect)")
+	nonsecure := flag.Bool("nonsecure", false, "Do memory accesses as Non-secure (AHB-AP CSW HNONSEC) on TrustZone targets, seeing memory the way Non-secure firmware does")
 	stats := flag.Bool("stats", false, "dumps stats if applicable to the command")
 	// Todo: Change this to a "verbosity" level and add wrapper to the logging based on it
 
 	flag.Usage = func() {
 		// TODO: customize as needed
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s [command]:\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  gdbserver\n    \tServe gdb's Remote Serial Protocol on -bind and -port until interrupted\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  shell\n    \tInteractive console (halt, resume, mdw, mww, regs, reset, load, verify, ...), served over TCP with -port\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  serve-api\n    \tServe a JSON-RPC 2.0 control API over HTTP on -bind and -port (4444 by default) or -socket until interrupted\n")
 		flag.PrintDefaults()
 	}
-	flag.Parse()
+
+	// A command comes before the flags, e.g. 'goocd gdbserver -target=atsame51-atmelice -port=3333'
+	command := ""
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		command = os.Args[1]
+		_ = flag.CommandLine.Parse(os.Args[2:]) // exits on error
+	} else {
+		flag.Parse()
+	}
 
 	if *targetListF {
 		// TODO: sort and see if we want to implement any help info
@@ -42,6 +90,36 @@ func main() {
 
 	args := targets.Args{}
 
+	switch command {
+	case "":
+	case "gdbserver":
+		args.GDBServer = true
+		args.Bind = *bindF
+		args.Port = *portF
+	case "shell":
+		args.Shell = true
+		args.Bind = *bindF
+		args.LoadDir = *loadDirF
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "port" {
+				args.Port = *portF
+			}
+		})
+	case "serve-api":
+		args.ServeAPI = true
+		args.Bind = *bindF
+		args.Port = 4444
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "port" {
+				args.Port = *portF
+			}
+		})
+		args.LoadDir = *loadDirF
+		args.APISocket = *socketF
+	default:
+		log.Fatalf("Unknown command %q, the commands are: gdbserver, shell, serve-api", command)
+	}
+
 	if tgt.SupportsReadMemU32 && *readmemu32 != "" {
 		splitReadMem := strings.Split(*readmemu32, ",")
 		addr, err := strconv.ParseUint(splitReadMem[0], 0, 64) // supports hex, dec, oct, bin
@@ -93,8 +171,159 @@ func main() {
 		args.WriteMemU32Count = int(count)
 	}
 
-	if tgt.SupportsReset && *reset {
-		args.Reset = *reset
+	if *readmemu8 != "" {
+		args.ReadMemU8Addr, args.ReadMemU8Count = parseAddrCount(*readmemu8)
+	}
+
+	if *readmemu16 != "" {
+		args.ReadMemU16Addr, args.ReadMemU16Count = parseAddrCount(*readmemu16)
+	}
+
+	if *writememu8 != "" {
+		args.WriteMemU8Addr, args.WriteMemU8Value, args.WriteMemU8Count = parseAddrValueCount(*writememu8)
+	}
+
+	if *writememu16 != "" {
+		args.WriteMemU16Addr, args.WriteMemU16Value, args.WriteMemU16Count = parseAddrValueCount(*writememu16)
+	}
+
+	if *regs {
+		args.Regs = *regs
+	}
+
+	if *setreg != "" {
+		splitSetReg := strings.Split(*setreg, ",")
+		if len(splitSetReg) != 2 {
+			log.Fatalf("Unable to parse %q into a register name + value", *setreg)
+		}
+		value, err := strconv.ParseUint(splitSetReg[1], 0, 32)
+		if err != nil {
+			log.Fatalf("Unable to parse %q into a register name + value: %v", *setreg, err)
+		}
+		args.SetRegName = splitSetReg[0]
+		args.SetRegValue = value
+	}
+
+	if *halt {
+		args.Halt = *halt
+	}
+
+	if *step {
+		args.Step = *step
+		args.StepMaskInts = *maskints
+	}
+
+	if *resume {
+		args.Resume = *resume
+	}
+
+	if *status {
+		args.Status = *status
+	}
+
+	if *breakF != "" {
+		addr, err := strconv.ParseUint(*breakF, 0, 32)
+		if err != nil {
+			log.Fatalf("Unable to parse %q into a breakpoint address: %v", *breakF, err)
+		}
+		args.Break = true
+		args.BreakAddr = addr
+	}
+	args.Timeout = *timeout
+
+	if *cyclesF != "" {
+		start, end, ok := strings.Cut(*cyclesF, ",")
+		if !ok {
+			log.Fatalf("Unable to parse %q into a start + end address", *cyclesF)
+		}
+		startAddr, err := strconv.ParseUint(start, 0, 32)
+		if err != nil {
+			log.Fatalf("Unable to parse %q into a start + end address: %v", *cyclesF, err)
+		}
+		endAddr, err := strconv.ParseUint(end, 0, 32)
+		if err != nil {
+			log.Fatalf("Unable to parse %q into a start + end address: %v", *cyclesF, err)
+		}
+		if *iterations < 1 {
+			log.Fatalf("-iterations must be at least 1, got %d", *iterations)
+		}
+		args.Cycles = true
+		args.CyclesStart = uint32(startAddr)
+		args.CyclesEnd = uint32(endAddr)
+		args.Iterations = *iterations
+		args.CPUClock = *cpuClock
+	}
+
+	if *watchpoint != "" {
+		splitWatch := strings.Split(*watchpoint, ",")
+		if len(splitWatch) < 2 || len(splitWatch) > 3 {
+			log.Fatalf("Unable to parse %q into an address + size + kind", *watchpoint)
+		}
+		addr, err := strconv.ParseUint(splitWatch[0], 0, 32)
+		if err != nil {
+			log.Fatalf("Unable to parse %q into an address + size + kind: %v", *watchpoint, err)
+		}
+		size, err := strconv.ParseUint(splitWatch[1], 0, 32)
+		if err != nil {
+			log.Fatalf("Unable to parse %q into an address + size + kind: %v", *watchpoint, err)
+		}
+		args.Watchpoint = true
+		args.WatchpointAddr = addr
+		args.WatchpointSize = size
+		args.WatchpointKind = "rw"
+		if len(splitWatch) > 2 {
+			args.WatchpointKind = splitWatch[2]
+		}
+	}
+
+	if *fault {
+		args.Fault = *fault
+	}
+
+	if *backtraceF {
+		args.Backtrace = *backtraceF
+	}
+	args.ELF = *elfF
+
+	if *coredumpF != "" {
+		args.CoreDump = *coredumpF
+	}
+
+	if *semihostingF {
+		args.Semihosting = *semihostingF
+		args.SemihostingDir = *semihostingDir
+	}
+
+	if *rttF {
+		args.RTT = *rttF
+	}
+
+	if *profileF > 0 {
+		args.Profile = *profileF
+		args.Pprof = *pprofF
+	}
+
+	if *printF != "" {
+		args.Print = strings.Split(*printF, ",")
+	}
+
+	if *watchF != "" {
+		if *interval <= 0 {
+			log.Fatalf("-interval must be positive, got %v", *interval)
+		}
+		args.Watch = strings.Split(*watchF, ",")
+		args.Interval = *interval
+		args.CSV = *csvF
+	}
+
+	if *nonsecure {
+		args.NonSecure = *nonsecure
+	}
+
+	if tgt.SupportsReset && reset.set {
+		args.Reset = true
+		args.ResetStrategy = reset.strategy
+		args.ResetHalt = reset.halt
 	}
 
 	if tgt.SupportsLoad && *loadF != "" {
@@ -107,12 +336,87 @@ func main() {
 
 	//args.Load = *loadF
 	//args.ReadMem = *readmemu32
-	//args.Halt = *Halt
-	//args.Resume = *resume
 
 	err := tgt.Run(&args)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if args.ExitCode != 0 {
+		os.Exit(args.ExitCode)
+	}
+
+}
+
+// parseAddrCount parses an 'address,count' flag value, count is optional and defaults to 1.
+func parseAddrCount(s string) (addr uint64, count int) {
+	split := strings.Split(s, ",")
+	addr, err := strconv.ParseUint(split[0], 0, 64) // supports hex, dec, oct, bin
+	if err != nil {
+		log.Fatalf("Unable to parse %q into an address + count: %v", s, err)
+	}
+	count64 := int64(1)
+	if len(split) > 1 {
+		count64, err = strconv.ParseInt(split[1], 0, 64)
+		if err != nil {
+			log.Fatalf("Unable to parse %q into an address + count: %v", s, err)
+		}
+		if count64 < 0 {
+			log.Fatalf("Invalid count %d", count64)
+		}
+	}
+	return addr, int(count64)
+}
+
+// parseAddrValueCount parses an 'address,value,count' flag value, count is optional and defaults to 1.
+func parseAddrValueCount(s string) (addr, value uint64, count int) {
+	split := strings.Split(s, ",")
+	if len(split) < 2 {
+		log.Fatalf("Unable to parse %q into an address + value + count properly", s)
+	}
+	addrCount := split[0]
+	if len(split) > 2 {
+		addrCount += "," + split[2]
+	}
+	addr, count = parseAddrCount(addrCount)
+	value, err := strconv.ParseUint(split[1], 0, 64)
+	if err != nil {
+		log.Fatalf("Unable to parse %q into an address + value + count properly: %v", s, err)
+	}
+	return addr, value, count
+}
+
+// resetFlag is the -reset flag, which works as a plain bool flag or takes a
+// comma separated strategy and/or 'halt'.
+type resetFlag struct {
+	set      bool
+	strategy string
+	halt     bool
+}
+
+func (r *resetFlag) String() string {
+	if r == nil || !r.set {
+		return ""
+	}
+	return fmt.Sprintf("%s,halt=%t", r.strategy, r.halt)
+}
+
+func (r *resetFlag) Set(s string) error {
+	r.set = true
+	for _, opt := range strings.Split(s, ",") {
+		switch strings.ToLower(opt) {
+		case "true", "":
+		case "halt":
+			r.halt = true
+		case "hw", "sys", "vect":
+			r.strategy = strings.ToLower(opt)
+		default:
+			return fmt.Errorf("unknown reset option %q, expected hw, sys, vect or halt", opt)
+		}
+	}
+	return nil
+}
 
+// IsBoolFlag lets -reset be given without a value.
+func (r *resetFlag) IsBoolFlag() bool {
+	return true
 }
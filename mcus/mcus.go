@@ -4,3 +4,10 @@
 // tooling and resulting .go file output with relevant
 // constants for each chip.
 package mcus
+
+// Region is a named range of a microcontroller's address space.
+type Region struct {
+	Name  string
+	Start uint32
+	Size  uint32
+}
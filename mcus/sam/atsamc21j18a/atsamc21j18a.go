@@ -0,0 +1,111 @@
+// Hand written subset of the generated device file, holding only what goocd needs to program the flash.
+// Replace it with the full file generated by tools/gen-from-atpack.go from the Atmel.SAMC21_DFP atpack.
+
+// Microchip ATSAMC21J18A Microcontroller
+//
+
+package atsamc21j18a
+
+// Some information about this device.
+const (
+	Device       = "ATSAMC21J18A"
+	CPU          = "CM0+"
+	FPUPresent   = false
+	NVICPrioBits = 2
+)
+
+// Peripheral addresses
+const (
+	// Device Service Unit
+	DSU_Addr = 0x41002000
+
+	// Non-Volatile Memory Controller
+	NVMCTRL_Addr = 0x41004000
+)
+
+// Non-Volatile Memory Controller
+const ( // NVMCTRL
+	NVMCTRL_CTRLA_Offset    = 0x0 // 0
+	NVMCTRL_CTRLA_Size      = 2
+	NVMCTRL_CTRLB_Offset    = 0x4 // 4
+	NVMCTRL_CTRLB_Size      = 4
+	NVMCTRL_PARAM_Offset    = 0x8 // 8
+	NVMCTRL_PARAM_Size      = 4
+	NVMCTRL_INTENCLR_Offset = 0xC // 12
+	NVMCTRL_INTENCLR_Size   = 1
+	NVMCTRL_INTENSET_Offset = 0x10 // 16
+	NVMCTRL_INTENSET_Size   = 1
+	NVMCTRL_INTFLAG_Offset  = 0x14 // 20
+	NVMCTRL_INTFLAG_Size    = 1
+	NVMCTRL_STATUS_Offset   = 0x18 // 24
+	NVMCTRL_STATUS_Size     = 2
+	NVMCTRL_ADDR_Offset     = 0x1C // 28
+	NVMCTRL_ADDR_Size       = 4
+	NVMCTRL_LOCK_Offset     = 0x20 // 32
+	NVMCTRL_LOCK_Size       = 2
+)
+
+// Constants for NVMCTRL: Non-Volatile Memory Controller
+const (
+	// CTRLA: Control A
+	// Position of CMD field.
+	NVMCTRL_CTRLA_CMD_Pos = 0x0
+	// Bit mask of CMD field.
+	NVMCTRL_CTRLA_CMD_Msk = 0x7f
+	// Erase Row - Erases the row addressed by the ADDR register.
+	NVMCTRL_CTRLA_CMD_ER = 0x2
+	// Write Page - Writes the contents of the page buffer to the page addressed by the ADDR register.
+	NVMCTRL_CTRLA_CMD_WP = 0x4
+	// Lock Region - Locks the region containing the address location in the ADDR register.
+	NVMCTRL_CTRLA_CMD_LR = 0x40
+	// Unlock Region - Unlocks the region containing the address location in the ADDR register.
+	NVMCTRL_CTRLA_CMD_UR = 0x41
+	// Page Buffer Clear - Clears the page buffer.
+	NVMCTRL_CTRLA_CMD_PBC = 0x44
+	// Invalidate all cache lines.
+	NVMCTRL_CTRLA_CMD_INVALL = 0x46
+	// Position of CMDEX field.
+	NVMCTRL_CTRLA_CMDEX_Pos = 0x8
+	// Bit mask of CMDEX field.
+	NVMCTRL_CTRLA_CMDEX_Msk = 0xff00
+	// Execution Key
+	NVMCTRL_CTRLA_CMDEX_KEY = 0xa5
+
+	// CTRLB: Control B
+	// Position of MANW field.
+	NVMCTRL_CTRLB_MANW_Pos = 0x7
+	// Bit mask of MANW field.
+	NVMCTRL_CTRLB_MANW_Msk = 0x80
+	// Bit MANW.
+	NVMCTRL_CTRLB_MANW = 0x80
+
+	// PARAM: NVM Parameter
+	// Position of NVMP field.
+	NVMCTRL_PARAM_NVMP_Pos = 0x0
+	// Bit mask of NVMP field.
+	NVMCTRL_PARAM_NVMP_Msk = 0xffff
+	// Position of PSZ field.
+	NVMCTRL_PARAM_PSZ_Pos = 0x10
+	// Bit mask of PSZ field.
+	NVMCTRL_PARAM_PSZ_Msk = 0x70000
+
+	// INTFLAG: Interrupt Flag Status and Clear
+	// Position of READY field.
+	NVMCTRL_INTFLAG_READY_Pos = 0x0
+	// Bit mask of READY field.
+	NVMCTRL_INTFLAG_READY_Msk = 0x1
+	// Bit READY.
+	NVMCTRL_INTFLAG_READY = 0x1
+	// Position of ERROR field.
+	NVMCTRL_INTFLAG_ERROR_Pos = 0x1
+	// Bit mask of ERROR field.
+	NVMCTRL_INTFLAG_ERROR_Msk = 0x2
+	// Bit ERROR.
+	NVMCTRL_INTFLAG_ERROR = 0x2
+
+	// ADDR: Address
+	// Position of ADDR field, a 16 bit (halfword) address.
+	NVMCTRL_ADDR_ADDR_Pos = 0x0
+	// Bit mask of ADDR field.
+	NVMCTRL_ADDR_ADDR_Msk = 0x3fffff
+)
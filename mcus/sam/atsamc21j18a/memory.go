@@ -0,0 +1,12 @@
+// Hand written from the memory organization in the datasheet, the FLASH and HSRAM segments in the layout
+// tools/gen-from-atpack.go writes from the .atdf file.  Regenerate it from the atpack to replace it.
+
+package atsamc21j18a
+
+// Memory layout
+const (
+	FLASH_Addr = 0x0
+	FLASH_Size = 0x40000
+	HSRAM_Addr = 0x20000000
+	HSRAM_Size = 0x8000
+)
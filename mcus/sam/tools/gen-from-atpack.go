@@ -5,14 +5,17 @@ package main
 
 import (
 	"archive/zip"
+	"encoding/xml"
 	"flag"
 	"fmt"
+	"go/format"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -20,8 +23,8 @@ func main() {
 
 	keepF := flag.Bool("keep", false, "Keep temporary directory with svd files instead of deleting it upon exit")
 	flag.Usage = func() {
-		fmt.Fprintf(CommandLine.Output(), "Usage of gen-from-atpack: a tool to extract SVD files from an Atmel/Microchp .atpack (zip) file and run the result through gen-device-svd.  Use this to add support for additional Atmel/Microchip processors.\n", os.Args[0])
-		PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s: a tool to extract SVD files from an Atmel/Microchp .atpack (zip) file and run the result through gen-device-svd.  Use this to add support for additional Atmel/Microchip processors.\n", os.Args[0])
+		flag.PrintDefaults()
 	}
 	flag.Parse()
 
@@ -56,9 +59,13 @@ func main() {
 		defer os.RemoveAll(tmpDir)
 	}
 
-	// loop over everything, find all .svd files, write them to tmpDir
+	// loop over everything, find all .svd files, write them to tmpDir, and keep the .atdf files for their memory layout
+	var atdfs []*zip.File
 	for _, zf := range r.File {
 		ext := strings.ToLower(path.Ext(zf.Name))
+		if ext == ".atdf" {
+			atdfs = append(atdfs, zf)
+		}
 		if ext != ".svd" {
 			continue
 		}
@@ -98,4 +105,87 @@ func main() {
 	if err != nil {
 		log.Fatalf("gen-device-svd execution error: %v", err)
 	}
+
+	// the SVD files don't describe the memories, the ATDF files next to them do
+	for _, zf := range atdfs {
+		err := writeMemoryLayout(zf, filepath.Base(inPath), filepath.Join(wd, ".."))
+		if err != nil {
+			log.Fatalf("Error while writing the memory layout for entry %q: %v", zf.Name, err)
+		}
+	}
+}
+
+// atdf is the part of an .atdf file listing the memories of a device.
+type atdf struct {
+	Devices []struct {
+		Name          string `xml:"name,attr"`
+		AddressSpaces []struct {
+			Segments []struct {
+				Name  string `xml:"name,attr"`
+				Type  string `xml:"type,attr"`
+				Start string `xml:"start,attr"`
+				Size  string `xml:"size,attr"`
+			} `xml:"memory-segment"`
+		} `xml:"address-spaces>address-space"`
+	} `xml:"devices>device"`
+}
+
+// writeMemoryLayout writes memory.go with the address and size of each flash and RAM segment of the device in the
+// .atdf file zf, next to the files gen-device-svd wrote into outdir.
+func writeMemoryLayout(zf *zip.File, source, outdir string) error {
+	zff, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer zff.Close()
+	var a atdf
+	err = xml.NewDecoder(zff).Decode(&a)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range a.Devices {
+		nameLower := strings.ReplaceAll(strings.ToLower(device.Name), "-", "")
+		pkgDir := filepath.Join(outdir, nameLower)
+		if _, err := os.Stat(pkgDir); err != nil {
+			log.Printf("Skipping the memory layout of %s, no package generated from its svd", device.Name)
+			continue
+		}
+
+		b := &strings.Builder{}
+		fmt.Fprintf(b, "// Automatically generated file. DO NOT EDIT.\n")
+		fmt.Fprintf(b, "// Generated by gen-from-atpack.go from %s, see %s\n\n", path.Base(zf.Name), source)
+		fmt.Fprintf(b, "package %s\n\n", nameLower)
+		fmt.Fprintf(b, "// Memory layout\nconst (\n")
+		for _, space := range device.AddressSpaces {
+			for _, seg := range space.Segments {
+				if seg.Type != "flash" && seg.Type != "ram" {
+					continue
+				}
+				start, err := strconv.ParseUint(seg.Start, 0, 32)
+				if err != nil {
+					return err
+				}
+				size, err := strconv.ParseUint(seg.Size, 0, 33)
+				if err != nil {
+					return err
+				}
+				name := strings.ToUpper(strings.ReplaceAll(seg.Name, "-", "_"))
+				fmt.Fprintf(b, "\t%s_Addr = 0x%x\n\t%s_Size = 0x%x\n", name, start, name, size)
+			}
+		}
+		fmt.Fprintf(b, ")\n")
+
+		src, err := format.Source([]byte(b.String()))
+		if err != nil {
+			return err
+		}
+		wpath := filepath.Join(pkgDir, "memory.go")
+		err = os.WriteFile(wpath, src, 0644)
+		if err != nil {
+			return err
+		}
+		log.Printf("Wrote: %s", wpath)
+	}
+	return nil
 }
@@ -0,0 +1,150 @@
+package simprobe
+
+// Debug registers the simulated core answers itself.
+const (
+	dhcsrAddr = 0xE000EDF0
+	dcrsrAddr = 0xE000EDF4
+	dcrdrAddr = 0xE000EDF8
+	demcrAddr = 0xE000EDFC
+	aircrAddr = 0xE000ED0C
+)
+
+// DEMCR and AIRCR fields.
+const (
+	demcrVCCoreReset = 0x1
+	aircrKey         = 0x05FA0000
+	aircrKeyMask     = 0xFFFF0000
+	aircrVectReset   = 0x1
+	aircrSysResetReq = 0x4
+)
+
+// DHCSR fields.
+const (
+	dhcsrKey      = 0xA05F0000
+	dhcsrKeyMask  = 0xFFFF0000
+	dhcsrCtrlMask = 0xF
+	dhcsrCHalt    = 0x2
+	dhcsrCStep    = 0x4
+	dhcsrSRegRdy  = 0x10000
+	dhcsrSHalt    = 0x20000
+	dhcsrSSleep   = 0x40000
+	dhcsrSLockup  = 0x80000
+	dhcsrSResetSt = 0x2000000
+)
+
+// dcrsrWrite is the REGWnR bit of DCRSR.
+const dcrsrWrite = 0x10000
+
+// Core is the state of the simulated Cortex-M core as seen by the debugger.
+type Core struct {
+	// Registers holds every DCRSR selectable register, indexed by REGSEL.
+	Registers [0x80]uint32
+	Halted    bool
+	Sleeping  bool
+	Lockup    bool
+	// ResetSeen is reported once through DHCSR S_RESET_ST
+	ResetSeen bool
+	// Steps counts the instructions single stepped, each advancing PC by 2
+	Steps int
+
+	dhcsrCtrl uint32
+	dcrdr     uint32
+	demcr     uint32
+}
+
+// load reads a word from the core debug registers or from Memory.
+func (p *Probe) load(addr uint32) uint32 {
+	c := &p.Core
+	switch addr &^ 3 {
+	case dhcsrAddr:
+		v := c.dhcsrCtrl | dhcsrSRegRdy
+		if c.Halted {
+			v |= dhcsrSHalt
+		}
+		if c.Sleeping && !c.Halted {
+			v |= dhcsrSSleep
+		}
+		if c.Lockup {
+			v |= dhcsrSLockup
+		}
+		if c.ResetSeen {
+			v |= dhcsrSResetSt
+			c.ResetSeen = false
+		}
+		return v
+	case dcrdrAddr:
+		return c.dcrdr
+	case demcrAddr:
+		return c.demcr
+	}
+	return p.ReadWord(addr)
+}
+
+// storeWord writes a word to the core debug registers or to Memory.
+func (p *Probe) storeWord(addr, value uint32) {
+	c := &p.Core
+	switch addr &^ 3 {
+	case dhcsrAddr:
+		if value&dhcsrKeyMask != dhcsrKey {
+			return
+		}
+		c.dhcsrCtrl = value & dhcsrCtrlMask
+		switch {
+		case value&dhcsrCHalt > 0:
+			c.Halted = true
+		case value&dhcsrCStep > 0 && c.Halted:
+			c.Registers[15] += 2
+			c.Steps++
+		default:
+			wasHalted := c.Halted
+			c.Halted = false
+			if wasHalted && p.OnResume != nil {
+				p.OnResume(c)
+			}
+		}
+		return
+	case dcrsrAddr:
+		if !c.Halted {
+			return
+		}
+		sel := value & 0x7F
+		if value&dcrsrWrite > 0 {
+			c.Registers[sel] = c.dcrdr
+		} else {
+			c.dcrdr = c.Registers[sel]
+		}
+		return
+	case dcrdrAddr:
+		c.dcrdr = value
+		return
+	case demcrAddr:
+		c.demcr = value
+		return
+	case aircrAddr:
+		if value&aircrKeyMask == aircrKey && value&(aircrVectReset|aircrSysResetReq) > 0 {
+			p.resetCore()
+		}
+		return
+	}
+	p.WriteWord(addr, value)
+	if p.OnWrite != nil {
+		p.OnWrite(addr&^3, p.ReadWord(addr))
+	}
+}
+
+// Reset implements cortexm.PinResetter, resetting the simulated core as if nRESET was pulsed.
+func (p *Probe) Reset() error {
+	p.resetCore()
+	return nil
+}
+
+// resetCore loads SP and PC from the vector table at address 0 and halts when DEMCR VC_CORERESET is armed.
+func (p *Probe) resetCore() {
+	c := &p.Core
+	c.Registers[13] = p.ReadWord(0)
+	c.Registers[15] = p.ReadWord(4) &^ 1
+	c.Sleeping = false
+	c.Lockup = false
+	c.ResetSeen = true
+	c.Halted = c.demcr&demcrVCCoreReset > 0
+}
@@ -0,0 +1,299 @@
+// Package simprobe is a simulated debug probe and target.  It implements
+// the DAPTransferer interface the core packages use, modelling a SW-DP with
+// a single AHB-AP in front of a sparse memory, so core access code can be
+// exercised without any hardware attached.
+package simprobe
+
+import (
+	"encoding/binary"
+
+	"goocd/protocols/cmsisdap"
+)
+
+// Default identification values reported by the simulated ports.
+const (
+	DefaultIDCODE = 0x2BA01477 // ARM SW-DP v1
+	DefaultAPIDR  = 0x24770011 // ARM AHB-AP
+)
+
+// Debug Port CTRL/STAT power up request and acknowledge bits.
+const (
+	ctrlStatCSYSPWRUPACK = 0x80000000
+	ctrlStatCSYSPWRUPREQ = 0x40000000
+	ctrlStatCDBGPWRUPACK = 0x20000000
+	ctrlStatCDBGPWRUPREQ = 0x10000000
+	ctrlStatSTICKYERR    = 0x20
+)
+
+// Debug Port ABORT bit clearing STICKYERR.
+const abortSTKERRCLR = 0x4
+
+// Access Port CSW fields.
+const (
+	cswSizeMask    = 0x7
+	cswAddrIncMask = 0x30
+	cswAddrInc     = 0x10
+	cswSPIDEN      = 0x800000
+	cswHNONSEC     = 0x40000000
+)
+
+// tarWrap is the boundary the TAR auto increment is guaranteed to stay within.
+const tarWrap = 0x400
+
+// Probe is a simulated probe with its target attached.  The zero value is
+// ready to use.
+type Probe struct {
+	IDCODE uint32
+	APIDR  uint32
+
+	// Memory holds the target memory as word aligned addresses to values,
+	// anything not present reads back as zero.
+	Memory map[uint32]uint32
+
+	// Core is the simulated Cortex-M debug logic, answering accesses to its
+	// debug registers instead of Memory.
+	Core Core
+
+	// OnWrite is called after every AP write to Memory with the word address and
+	// the resulting word, letting tests model peripherals such as a flash controller.
+	OnWrite func(addr, value uint32)
+
+	// OnResume is called when the debugger resumes the halted core, letting tests
+	// model it running, e.g. into a breakpoint by moving PC and setting Halted.
+	OnResume func(c *Core)
+
+	// SecureMemory marks the addresses only Secure accesses reach, Non-secure accesses
+	// to them read as zero and ignore writes.  With SecureDebugDisabled the AP has
+	// SPIDEN low and every access is Non-secure, like an AHB5-AP.
+	SecureMemory        func(addr uint32) bool
+	SecureDebugDisabled bool
+
+	// Fault marks the addresses an AP access faults on, like reserved or unimplemented
+	// memory.  As on a real DP the transfer answers FAULT and STICKYERR stays set,
+	// failing every AP access after it, until it is cleared through ABORT.
+	Fault func(addr uint32) bool
+
+	// Transfers counts the DAPTransfer and DAPTransferBlock calls, useful to
+	// check that accesses are batched.
+	Transfers int
+
+	dpSelect uint32
+	ctrlStat uint32
+	csw      uint32
+	tar      uint32
+	rdBuff   uint32
+	sticky   bool
+
+	buffer [512]byte
+}
+
+// ReadWord returns the word at the word aligned address containing addr.
+func (p *Probe) ReadWord(addr uint32) uint32 {
+	return p.Memory[addr&^3]
+}
+
+// WriteWord stores value at the word aligned address containing addr.
+func (p *Probe) WriteWord(addr, value uint32) {
+	if p.Memory == nil {
+		p.Memory = make(map[uint32]uint32)
+	}
+	p.Memory[addr&^3] = value
+}
+
+// DAPTransfer implements adi.DAPTransferer and answers the same way a CMSIS-DAP probe does.
+func (p *Probe) DAPTransfer(dapidx uint8, count uint8, data []byte) ([]byte, error) {
+	p.Transfers++
+	p.zeroBuffer()
+	p.buffer[0] = cmsisdap.DAPTransferCMD
+
+	in, out := 0, 3
+	for i := 0; i < int(count); i++ {
+		req := data[in]
+		in++
+		if req&cmsisdap.AccessPort > 0 && p.sticky {
+			return nil, cmsisdap.ErrBadDAPResponseStatus{}
+		}
+		if req&cmsisdap.Read > 0 {
+			binary.LittleEndian.PutUint32(p.buffer[out:], p.read(req))
+			out += 4
+		} else {
+			p.write(req, binary.LittleEndian.Uint32(data[in:]))
+			in += 4
+		}
+		if p.sticky {
+			return nil, cmsisdap.ErrBadDAPResponseStatus{}
+		}
+	}
+
+	p.buffer[1] = count
+	p.buffer[2] = 0x1
+	return p.buffer[:], nil
+}
+
+// DAPTransferBlock implements adi.DAPTransferer and answers the same way a CMSIS-DAP probe does.
+func (p *Probe) DAPTransferBlock(dapidx uint8, count uint16, request byte, data []byte) ([]byte, error) {
+	p.Transfers++
+	p.zeroBuffer()
+	p.buffer[0] = cmsisdap.DAPTransferBlockCMD
+
+	for i := 0; i < int(count); i++ {
+		if request&cmsisdap.AccessPort > 0 && p.sticky {
+			return nil, cmsisdap.ErrBadDAPResponseStatus{}
+		}
+		if request&cmsisdap.Read > 0 {
+			binary.LittleEndian.PutUint32(p.buffer[4+i*4:], p.read(request))
+		} else {
+			p.write(request, binary.LittleEndian.Uint32(data[i*4:]))
+		}
+		if p.sticky {
+			return nil, cmsisdap.ErrBadDAPResponseStatus{}
+		}
+	}
+
+	binary.LittleEndian.PutUint16(p.buffer[1:], count)
+	p.buffer[3] = 0x1
+	return p.buffer[:], nil
+}
+
+func (p *Probe) read(req byte) uint32 {
+	reg := req & 0xC
+	if req&cmsisdap.AccessPort == 0 {
+		switch reg {
+		case cmsisdap.PortRegister0:
+			if p.IDCODE == 0 {
+				return DefaultIDCODE
+			}
+			return p.IDCODE
+		case cmsisdap.PortRegister4:
+			if p.sticky {
+				return p.ctrlStat | ctrlStatSTICKYERR
+			}
+			return p.ctrlStat
+		case cmsisdap.PortRegisterC:
+			return p.rdBuff
+		}
+		return 0
+	}
+
+	bank := (p.dpSelect >> 4) & 0xF
+	switch {
+	case bank == 0xF && reg == cmsisdap.PortRegisterC:
+		p.rdBuff = p.APIDR
+		if p.APIDR == 0 {
+			p.rdBuff = DefaultAPIDR
+		}
+	case bank == 0 && reg == cmsisdap.PortRegister0:
+		p.rdBuff = p.csw
+		if !p.SecureDebugDisabled {
+			p.rdBuff |= cswSPIDEN
+		}
+	case bank == 0 && reg == cmsisdap.PortRegister4:
+		p.rdBuff = p.tar
+	case bank == 0 && reg == cmsisdap.PortRegisterC:
+		p.rdBuff = 0
+		if !p.faults(p.tar) {
+			p.rdBuff = p.loadAP(p.tar)
+		}
+		p.increment()
+	case bank == 1:
+		p.rdBuff = 0
+		if !p.faults(p.tar&^0xF | uint32(reg)) {
+			p.rdBuff = p.loadAP(p.tar&^0xF | uint32(reg))
+		}
+	default:
+		p.rdBuff = 0
+	}
+	return p.rdBuff
+}
+
+func (p *Probe) write(req byte, value uint32) {
+	reg := req & 0xC
+	if req&cmsisdap.AccessPort == 0 {
+		switch reg {
+		case cmsisdap.PortRegister0:
+			if value&abortSTKERRCLR > 0 {
+				p.sticky = false
+			}
+		case cmsisdap.PortRegister4:
+			p.ctrlStat = value
+			if value&ctrlStatCSYSPWRUPREQ > 0 {
+				p.ctrlStat |= ctrlStatCSYSPWRUPACK
+			}
+			if value&ctrlStatCDBGPWRUPREQ > 0 {
+				p.ctrlStat |= ctrlStatCDBGPWRUPACK
+			}
+		case cmsisdap.PortRegister8:
+			p.dpSelect = value
+		}
+		return
+	}
+
+	bank := (p.dpSelect >> 4) & 0xF
+	switch {
+	case bank == 0 && reg == cmsisdap.PortRegister0:
+		p.csw = value
+	case bank == 0 && reg == cmsisdap.PortRegister4:
+		p.tar = value
+	case bank == 0 && reg == cmsisdap.PortRegisterC:
+		if !p.faults(p.tar) && p.accessible(p.tar) {
+			p.store(p.tar, value)
+		}
+		p.increment()
+	case bank == 1:
+		if !p.faults(p.tar&^0xF|uint32(reg)) && p.accessible(p.tar&^0xF) {
+			p.storeWord(p.tar&^0xF|uint32(reg), value)
+		}
+	}
+}
+
+// faults reports if an AP access to addr faults, setting STICKYERR when it does.
+func (p *Probe) faults(addr uint32) bool {
+	if p.Fault == nil || !p.Fault(addr) {
+		return false
+	}
+	p.sticky = true
+	return true
+}
+
+// accessible reports if the current AP access reaches addr, taking the security of the access into account.
+func (p *Probe) accessible(addr uint32) bool {
+	nonSecure := p.csw&cswHNONSEC > 0 || p.SecureDebugDisabled
+	return !nonSecure || p.SecureMemory == nil || !p.SecureMemory(addr)
+}
+
+// loadAP reads addr for an AP access, inaccessible memory reads as zero.
+func (p *Probe) loadAP(addr uint32) uint32 {
+	if !p.accessible(addr) {
+		return 0
+	}
+	return p.load(addr)
+}
+
+// store writes value to addr honoring the CSW size, the data is expected on the byte lanes matching the address.
+func (p *Probe) store(addr, value uint32) {
+	var mask uint32
+	switch p.csw & cswSizeMask {
+	case 0:
+		mask = 0xFF << ((addr & 3) * 8)
+	case 1:
+		mask = 0xFFFF << ((addr & 2) * 8)
+	default:
+		mask = 0xFFFFFFFF
+	}
+	p.storeWord(addr, p.load(addr)&^mask|value&mask)
+}
+
+// increment advances TAR by the access size when auto increment is enabled, wrapping inside the 1KB boundary like real hardware may.
+func (p *Probe) increment() {
+	if p.csw&cswAddrIncMask != cswAddrInc {
+		return
+	}
+	size := uint32(1) << (p.csw & cswSizeMask)
+	p.tar = p.tar&^(tarWrap-1) | (p.tar+size)&(tarWrap-1)
+}
+
+func (p *Probe) zeroBuffer() {
+	for i := range p.buffer {
+		p.buffer[i] = 0
+	}
+}
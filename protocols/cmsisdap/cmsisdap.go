@@ -249,7 +249,28 @@ func (c *CMSISDAP) DAPTransfer(dapidx uint8, count uint8, data []byte) ([]byte,
 		return nil, ErrBadDAPResponseStatus{}
 	}
 
-	return c.Buffer[:32], nil
+	return c.Buffer[:], nil
+}
+
+// DAPTransferBlock implements the DAP transfer block protocol to the spec, repeating a single request to the same register count times.
+// For reads the response data words start at index 4 of the returned buffer, for writes data holds count little endian words.
+func (c *CMSISDAP) DAPTransferBlock(dapidx uint8, count uint16, request byte, data []byte) ([]byte, error) {
+	c.zeroBuffer()
+	c.Buffer[1] = DAPTransferBlockCMD
+	c.Buffer[2] = dapidx // Note: Ignored when using SWD
+	binary.LittleEndian.PutUint16(c.Buffer[3:], count)
+	c.Buffer[5] = request
+	copy(c.Buffer[6:], data)
+	err := c.sendAndRead()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Buffer[3]&0x7 != 0x1 || binary.LittleEndian.Uint16(c.Buffer[1:]) != count {
+		return nil, ErrBadDAPResponseStatus{}
+	}
+
+	return c.Buffer[:], nil
 }
 
 func (c *CMSISDAP) zeroBuffer() {
@@ -274,15 +295,16 @@ func (c *CMSISDAP) sendAndRead() error {
 	return nil
 }
 
+// Reset pulses the nRESET pin, leaving the other pins alone.
 func (c *CMSISDAP) Reset() error {
 	// Set Reset Pin Low
-	_, err := c.DAPSWJPins(0xF, 0x8F, 0)
+	_, err := c.DAPSWJPins(0, PinMaskNReset, 0)
 	if err != nil {
 		return err
 	}
 
 	// Set Reset Pin High
-	_, err = c.DAPSWJPins(0x8F, 0x8F, 0)
+	_, err = c.DAPSWJPins(PinMaskNReset, PinMaskNReset, 0)
 	if err != nil {
 		return err
 	}
@@ -10,6 +10,7 @@ const (
 	DAPDisconnectCMD     = 0x3
 	DAPTransferConfigCMD = 0x4
 	DAPTransferCMD       = 0x5
+	DAPTransferBlockCMD  = 0x6
 	DAPWriteAbortCMD     = 0x8
 	DAPDelay             = 0x9
 	DAPResetTarget       = 0xA
@@ -0,0 +1,61 @@
+package targets
+
+import (
+	"goocd/actions/samflash"
+	"goocd/core/cortexm"
+	"goocd/mcus"
+	"goocd/mcus/sam/atsamc21j18a"
+)
+
+// atsamc21HSRAM is the SRAM, what -rtt scans for the control block
+var atsamc21HSRAM = mcus.Region{Name: "HSRAM", Start: atsamc21j18a.HSRAM_Addr, Size: atsamc21j18a.HSRAM_Size}
+
+// atsamc21CoreDumpRegions is what -coredump captures
+var atsamc21CoreDumpRegions = append([]mcus.Region{
+	atsamc21HSRAM,
+	{Name: "NVMCTRL", Start: atsamc21j18a.NVMCTRL_Addr, Size: 0x100},
+}, scsRegions...)
+
+// atsamc21 is the ATSAMC21J18A.  ARMv6-M has no VECTRESET, so it resets with SYSRESETREQ unless told otherwise.
+var atsamc21 = &samAtmelICE{
+	Part:          cortexm.PartCortexM0Plus,
+	ResetStrategy: cortexm.ResetSystem,
+	NVM: samflash.NVMFlash{
+		EraseMultiplyer:          4, // Not easily Parsable, but in the Data sheet for the chip in the memory organization  section of NVMController
+		NVMControllerAddress:     atsamc21j18a.NVMCTRL_Addr,
+		NVMSetWriteAddressOffset: atsamc21j18a.NVMCTRL_ADDR_Offset,
+		NVMAddressShift:          1, // ADDR takes a halfword address
+		NVMManualWriteOffSet:     atsamc21j18a.NVMCTRL_CTRLB_Offset,
+		NVMManualWriteMask:       atsamc21j18a.NVMCTRL_CTRLB_MANW,
+		NVMPARAMOffset:           atsamc21j18a.NVMCTRL_PARAM_Offset,
+		NVMPageSizeMask:          atsamc21j18a.NVMCTRL_PARAM_PSZ_Msk,
+		NVMPageSizePos:           atsamc21j18a.NVMCTRL_PARAM_PSZ_Pos,
+		NVMPageCountMask:         atsamc21j18a.NVMCTRL_PARAM_NVMP_Msk,
+		NVMPageCountPos:          atsamc21j18a.NVMCTRL_PARAM_NVMP_Pos,
+
+		NVMClearReady:  false,
+		NVMReadyOffSet: atsamc21j18a.NVMCTRL_INTFLAG_Offset,
+		NVMReadyMask:   atsamc21j18a.NVMCTRL_INTFLAG_READY_Msk,
+		NVMReadyVal:    atsamc21j18a.NVMCTRL_INTFLAG_READY,
+
+		NVMCMDOffSet: atsamc21j18a.NVMCTRL_CTRLA_Offset,
+		NVMCMDKey:    atsamc21j18a.NVMCTRL_CTRLA_CMDEX_KEY,
+		NVMCMDKeyPos: atsamc21j18a.NVMCTRL_CTRLA_CMDEX_Pos,
+		NVMEraseCMD:  atsamc21j18a.NVMCTRL_CTRLA_CMD_ER,
+		NVMWriteCMD:  atsamc21j18a.NVMCTRL_CTRLA_CMD_WP,
+	},
+	RAM:             atsamc21HSRAM,
+	CoreDumpRegions: atsamc21CoreDumpRegions,
+}
+
+func init() {
+	addTarget(&Target{
+		Name:                "atsamc21-atmelice",
+		Description:         "Atsamc21 using AtmelIce over cmsisdap-dap",
+		SupportsReadMemU32:  true,
+		SupportsWriteMemU32: true,
+		SupportsReset:       true,
+		SupportsLoad:        true,
+		Run:                 atsamc21.Run,
+	})
+}
@@ -0,0 +1,61 @@
+package targets
+
+import (
+	"goocd/actions/samflash"
+	"goocd/core/cortexm"
+	"goocd/mcus"
+	"goocd/mcus/sam/atsamd21g18a"
+)
+
+// atsamd21HSRAM is the SRAM, what -rtt scans for the control block
+var atsamd21HSRAM = mcus.Region{Name: "HSRAM", Start: atsamd21g18a.HSRAM_Addr, Size: atsamd21g18a.HSRAM_Size}
+
+// atsamd21CoreDumpRegions is what -coredump captures
+var atsamd21CoreDumpRegions = append([]mcus.Region{
+	atsamd21HSRAM,
+	{Name: "NVMCTRL", Start: atsamd21g18a.NVMCTRL_Addr, Size: 0x100},
+}, scsRegions...)
+
+// atsamd21 is the ATSAMD21G18A.  ARMv6-M has no VECTRESET, so it resets with SYSRESETREQ unless told otherwise.
+var atsamd21 = &samAtmelICE{
+	Part:          cortexm.PartCortexM0Plus,
+	ResetStrategy: cortexm.ResetSystem,
+	NVM: samflash.NVMFlash{
+		EraseMultiplyer:          4, // Not easily Parsable, but in the Data sheet for the chip in the memory organization  section of NVMController
+		NVMControllerAddress:     atsamd21g18a.NVMCTRL_Addr,
+		NVMSetWriteAddressOffset: atsamd21g18a.NVMCTRL_ADDR_Offset,
+		NVMAddressShift:          1, // ADDR takes a halfword address
+		NVMManualWriteOffSet:     atsamd21g18a.NVMCTRL_CTRLB_Offset,
+		NVMManualWriteMask:       atsamd21g18a.NVMCTRL_CTRLB_MANW,
+		NVMPARAMOffset:           atsamd21g18a.NVMCTRL_PARAM_Offset,
+		NVMPageSizeMask:          atsamd21g18a.NVMCTRL_PARAM_PSZ_Msk,
+		NVMPageSizePos:           atsamd21g18a.NVMCTRL_PARAM_PSZ_Pos,
+		NVMPageCountMask:         atsamd21g18a.NVMCTRL_PARAM_NVMP_Msk,
+		NVMPageCountPos:          atsamd21g18a.NVMCTRL_PARAM_NVMP_Pos,
+
+		NVMClearReady:  false,
+		NVMReadyOffSet: atsamd21g18a.NVMCTRL_INTFLAG_Offset,
+		NVMReadyMask:   atsamd21g18a.NVMCTRL_INTFLAG_READY_Msk,
+		NVMReadyVal:    atsamd21g18a.NVMCTRL_INTFLAG_READY,
+
+		NVMCMDOffSet: atsamd21g18a.NVMCTRL_CTRLA_Offset,
+		NVMCMDKey:    atsamd21g18a.NVMCTRL_CTRLA_CMDEX_KEY,
+		NVMCMDKeyPos: atsamd21g18a.NVMCTRL_CTRLA_CMDEX_Pos,
+		NVMEraseCMD:  atsamd21g18a.NVMCTRL_CTRLA_CMD_ER,
+		NVMWriteCMD:  atsamd21g18a.NVMCTRL_CTRLA_CMD_WP,
+	},
+	RAM:             atsamd21HSRAM,
+	CoreDumpRegions: atsamd21CoreDumpRegions,
+}
+
+func init() {
+	addTarget(&Target{
+		Name:                "atsamd21-atmelice",
+		Description:         "Atsamd21 using AtmelIce over cmsisdap-dap",
+		SupportsReadMemU32:  true,
+		SupportsWriteMemU32: true,
+		SupportsReset:       true,
+		SupportsLoad:        true,
+		Run:                 atsamd21.Run,
+	})
+}
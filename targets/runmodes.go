@@ -0,0 +1,813 @@
+package targets
+
+import (
+	"context"
+	"fmt"
+	"goocd/actions/api"
+	"goocd/actions/backtrace"
+	"goocd/actions/coredump"
+	"goocd/actions/cycles"
+	"goocd/actions/gdbserver"
+	"goocd/actions/profile"
+	"goocd/actions/rtt"
+	"goocd/actions/samflash"
+	"goocd/actions/semihosting"
+	"goocd/actions/shell"
+	"goocd/actions/watch"
+	"goocd/core"
+	"goocd/core/cortexm"
+	"goocd/fileformats/autoparser"
+	"goocd/fileformats/dwarfparser"
+	"goocd/fileformats/elfparser"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+)
+
+// session is a samAtmelICE once connected, what the run modes work with.
+type session struct {
+	*samAtmelICE
+	Core core.Core
+	// Flash is NVM talking to Core
+	Flash *samflash.NVMFlash
+}
+
+// runMode is something a target can be asked to do, it does nothing unless args ask for it.
+type runMode func(s *session, args *Args) error
+
+// coreMode is a runMode only needing the core.
+func coreMode(f func(core core.Core, args *Args) error) runMode {
+	return func(s *session, args *Args) error {
+		return f(s.Core, args)
+	}
+}
+
+// runModes are in the order they run: memory access and flashing first, then the modes halting the core, then the ones
+// running until interrupted.  What the core can't do is up to its core package, like -nonsecure without the
+// Security Extension.
+var runModes = []runMode{
+	runMemU32,
+	coreMode(runMemU8U16),
+	runLoad,
+	runReset,
+	coreMode(runSemihosting),
+	runBreak,
+	runCycles,
+	coreMode(runWatchpoint),
+	coreMode(runHaltStep),
+	coreMode(runFault),
+	coreMode(runBacktrace),
+	runCoreDump,
+	coreMode(runCoreRegs),
+	coreMode(runPrint),
+	coreMode(runResumeStatus),
+	coreMode(runProfile),
+	coreMode(runWatch),
+	runGDBServer,
+	runShell,
+	runServeAPI,
+	runRTT,
+}
+
+// runMemU32 handles the word memory access args, the write first so it can be read back.
+func runMemU32(s *session, args *Args) error {
+	if args.WriteMemU32Count > 0 && !skipWriteMemU32(s.SkipWriteMemU32, args.WriteMemU32Addr) {
+		err := s.Core.WriteAddr32(uint32(args.WriteMemU32Addr), uint32(args.WriteMemU32Value))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("WriteAddr32[Address: 0x%x, Value: 0x%x]\n", args.WriteMemU32Addr, args.WriteMemU32Value)
+	}
+
+	if args.ReadMemU32Count > 0 {
+		vals, err := s.Core.ReadAddr32(uint32(args.ReadMemU32Addr), args.ReadMemU32Count)
+		if err != nil {
+			return err
+		}
+		printMemU32(os.Stdout, uint32(args.ReadMemU32Addr), vals)
+	}
+	return nil
+}
+
+func skipWriteMemU32(skip []uint64, addr uint64) bool {
+	for _, a := range skip {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// runMemU8U16 handles the byte and halfword memory access args, writes first so they can be read back.
+func runMemU8U16(core core.Core, args *Args) error {
+	for i := 0; i < args.WriteMemU8Count; i++ {
+		err := core.WriteMem8(uint32(args.WriteMemU8Addr)+uint32(i), uint8(args.WriteMemU8Value))
+		if err != nil {
+			return err
+		}
+	}
+	if args.WriteMemU8Count > 0 {
+		fmt.Printf("WriteMem8[Address: 0x%x, Value: 0x%x, Count: %d]\n", args.WriteMemU8Addr, args.WriteMemU8Value, args.WriteMemU8Count)
+	}
+
+	for i := 0; i < args.WriteMemU16Count; i++ {
+		err := core.WriteMem16(uint32(args.WriteMemU16Addr)+uint32(i*2), uint16(args.WriteMemU16Value))
+		if err != nil {
+			return err
+		}
+	}
+	if args.WriteMemU16Count > 0 {
+		fmt.Printf("WriteMem16[Address: 0x%x, Value: 0x%x, Count: %d]\n", args.WriteMemU16Addr, args.WriteMemU16Value, args.WriteMemU16Count)
+	}
+
+	if args.ReadMemU8Count > 0 {
+		b, err := core.ReadMem(uint32(args.ReadMemU8Addr), args.ReadMemU8Count)
+		if err != nil {
+			return err
+		}
+		printMem(os.Stdout, uint32(args.ReadMemU8Addr), b, 1)
+	}
+
+	if args.ReadMemU16Count > 0 {
+		vals, err := core.ReadMem16(uint32(args.ReadMemU16Addr), args.ReadMemU16Count)
+		if err != nil {
+			return err
+		}
+		printMemU16(os.Stdout, uint32(args.ReadMemU16Addr), vals)
+	}
+	return nil
+}
+
+// runLoad handles the load arg, programming the file into the flash.
+func runLoad(s *session, args *Args) error {
+	if args.Load == "" {
+		return nil
+	}
+
+	programReader, err := autoparser.ParseFromPath(args.Load, 0x0)
+	if err != nil {
+		return err
+	}
+	program, err := programReader.NextProgram()
+	if err != nil {
+		return err
+	}
+	s.Flash.WriteAddress = uint32(program.StartAddr())
+	err = s.Flash.LoadProgram(program.Bytes())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Successfully Flashed Rom\n")
+	return nil
+}
+
+// runReset handles the reset args, using the strategy from the command line or else the target's default.
+func runReset(s *session, args *Args) error {
+	if !args.Reset {
+		return nil
+	}
+
+	strategy, err := resetStrategy(args, s.ResetStrategy)
+	if err != nil {
+		return err
+	}
+
+	err = s.Core.ResetTarget(strategy, args.ResetHalt)
+	if err != nil {
+		return err
+	}
+	if args.ResetHalt {
+		fmt.Printf("Successfully Reset (%s) and Halted\n", strategy)
+		return nil
+	}
+	fmt.Printf("Successfully Reset (%s)\n", strategy)
+	return nil
+}
+
+// resetStrategy is the strategy picked on the command line or else the target's default.
+func resetStrategy(args *Args, defaultStrategy cortexm.ResetStrategy) (cortexm.ResetStrategy, error) {
+	if args.ResetStrategy == "" {
+		return defaultStrategy, nil
+	}
+	return cortexm.ParseResetStrategy(args.ResetStrategy)
+}
+
+// runBreak handles the break args: reset halted at the reset vector, set a hardware breakpoint, run to it and print the registers.
+func runBreak(s *session, args *Args) error {
+	if !args.Break {
+		return nil
+	}
+
+	strategy, err := resetStrategy(args, s.ResetStrategy)
+	if err != nil {
+		return err
+	}
+	err = s.Core.ResetTarget(strategy, true)
+	if err != nil {
+		return err
+	}
+
+	fpb := &cortexm.FPB{MemoryAccess: s.Core}
+	err = fpb.Configure()
+	if err != nil {
+		return err
+	}
+	err = fpb.SetBreakpoint(uint32(args.BreakAddr))
+	if err != nil {
+		return err
+	}
+	// Clear out the vector catch so only the breakpoint shows up
+	_, err = s.Core.DebugFaultStatus()
+	if err != nil {
+		return err
+	}
+
+	err = s.Core.Resume()
+	if err != nil {
+		return err
+	}
+	err = s.Core.WaitForHalt(args.Timeout)
+	if err != nil {
+		return err
+	}
+	err = fpb.ClearBreakpoint(uint32(args.BreakAddr))
+	if err != nil {
+		return err
+	}
+
+	dfsr, err := s.Core.DebugFaultStatus()
+	if err != nil {
+		return err
+	}
+	pc, err := s.Core.ReadCoreRegister(cortexm.PC)
+	if err != nil {
+		return err
+	}
+	if dfsr&cortexm.DebugFaultStatusBKPT == 0 || pc != uint32(args.BreakAddr) {
+		fmt.Printf("Halted at 0x%08x without hitting the breakpoint (DFSR: 0x%x)\n", pc, dfsr)
+	} else {
+		fmt.Printf("Hit breakpoint at 0x%08x\n", pc)
+	}
+	return printCoreRegs(s.Core)
+}
+
+// runCycles handles the cycles args: reset halted at the reset vector, then time the code from one address to another
+// with the DWT cycle counter for the iterations and print the cycles, and times at the -cpuclock given.
+func runCycles(s *session, args *Args) error {
+	if !args.Cycles {
+		return nil
+	}
+
+	strategy, err := resetStrategy(args, s.ResetStrategy)
+	if err != nil {
+		return err
+	}
+	err = s.Core.ResetTarget(strategy, true)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Timing 0x%08x to 0x%08x\n", args.CyclesStart, args.CyclesEnd)
+	counts, err := cycles.Measure(s.Core, args.CyclesStart, args.CyclesEnd, args.Iterations, args.Timeout)
+	cycles.Print(os.Stdout, counts, args.CPUClock)
+	return err
+}
+
+// runSemihosting handles the semihosting arg: run the core servicing its semihosting calls until the application exits,
+// its exit code becomes ours.  Combine with -reset=halt to catch the calls from the very first instruction.
+func runSemihosting(core core.Core, args *Args) error {
+	if !args.Semihosting {
+		return nil
+	}
+
+	host := semihosting.NewHost(args.SemihostingDir)
+	defer host.Close()
+	code, err := host.Run(core, args.Timeout)
+	if err != nil {
+		return err
+	}
+	args.ExitCode = code
+	return nil
+}
+
+// runWatchpoint handles the watchpoint args: set a DWT watchpoint, let the core run until it fires and print the registers.
+// Combine with -reset=halt to watch from the very first instruction.
+func runWatchpoint(core core.Core, args *Args) error {
+	if !args.Watchpoint {
+		return nil
+	}
+
+	kind, err := cortexm.ParseWatchpointKind(args.WatchpointKind)
+	if err != nil {
+		return err
+	}
+	dwt := &cortexm.DWT{MemoryAccess: core}
+	err = dwt.Configure()
+	if err != nil {
+		return err
+	}
+	wp, err := dwt.SetWatchpoint(uint32(args.WatchpointAddr), uint32(args.WatchpointSize), kind)
+	if err != nil {
+		return err
+	}
+	_, err = core.DebugFaultStatus()
+	if err != nil {
+		return err
+	}
+
+	err = core.Resume()
+	if err != nil {
+		return err
+	}
+	err = core.WaitForHalt(args.Timeout)
+	if err != nil {
+		return err
+	}
+
+	fired, err := dwt.Fired()
+	if err != nil {
+		return err
+	}
+	err = dwt.ClearWatchpoint(wp)
+	if err != nil {
+		return err
+	}
+	pc, err := core.ReadCoreRegister(cortexm.PC)
+	if err != nil {
+		return err
+	}
+	for _, f := range fired {
+		fmt.Printf("Watchpoint on DWT comparator %d fired (%s access to 0x%08x, %d bytes), halted at 0x%08x\n", f.Comparator, f.Kind, f.Addr, f.Size, pc)
+	}
+	if len(fired) == 0 {
+		fmt.Printf("Halted at 0x%08x without the watchpoint firing\n", pc)
+	}
+	return printCoreRegs(core)
+}
+
+// runHaltStep handles halting and single stepping, done before the register args so those see the result.
+func runHaltStep(core core.Core, args *Args) error {
+	if args.Halt {
+		err := core.Halt()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Successfully Halted\n")
+	}
+
+	if args.Step {
+		err := core.Step(args.StepMaskInts)
+		if err != nil {
+			return err
+		}
+		pc, err := core.ReadCoreRegister(cortexm.PC)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Stepped to PC: 0x%08x\n", pc)
+	}
+	return nil
+}
+
+// runFault handles the fault arg: halt, decode the fault status registers and unwind the exception frame of the handler the core is in.
+func runFault(core core.Core, args *Args) error {
+	if !args.Fault {
+		return nil
+	}
+
+	err := core.Halt()
+	if err != nil {
+		return err
+	}
+	status, err := cortexm.ReadFaultStatus(core)
+	if err != nil {
+		return err
+	}
+	if status.Mainline {
+		fmt.Printf("CFSR: 0x%08x  HFSR: 0x%08x  DFSR: 0x%08x  MMFAR: 0x%08x  BFAR: 0x%08x  AFSR: 0x%08x\n", status.CFSR, status.HFSR, status.DFSR, status.MMFAR, status.BFAR, status.AFSR)
+	} else {
+		fmt.Printf("DFSR: 0x%08x (ARMv6-M and ARMv8-M Baseline have no fault status registers)\n", status.DFSR)
+	}
+	if status.Mainline && status.SecurityExtension {
+		fmt.Printf("SFSR: 0x%08x  SFAR: 0x%08x\n", status.SFSR, status.SFAR)
+	}
+	for _, cause := range status.Causes() {
+		fmt.Printf("  %s\n", cause)
+	}
+
+	frame, err := cortexm.UnwindExceptionFrame(core)
+	if err != nil {
+		pc, pcErr := core.ReadCoreRegister(cortexm.PC)
+		if pcErr != nil {
+			return pcErr
+		}
+		fmt.Printf("No exception frame to unwind, halted at 0x%08x: %v\n", pc, err)
+		return nil
+	}
+	fmt.Printf("Halted in %s, EXC_RETURN 0x%08x, frame on %s at 0x%08x\n", cortexm.ExceptionName(frame.Exception), frame.ExcReturn, frame.Stack, frame.Addr)
+	fmt.Printf("Faulting PC: 0x%08x  LR: 0x%08x  xPSR: 0x%08x (%s)\n", frame.PC, frame.LR, frame.XPSR, cortexm.ExceptionName(frame.XPSR&cortexm.IPSRMask))
+	printRegisters(os.Stdout,
+		[]cortexm.Register{cortexm.R0, cortexm.R1, cortexm.R2, cortexm.R3, cortexm.R12, cortexm.LR, cortexm.PC, cortexm.XPSR},
+		[]uint32{frame.R0, frame.R1, frame.R2, frame.R3, frame.R12, frame.LR, frame.PC, frame.XPSR})
+	return nil
+}
+
+// runBacktrace handles the backtrace arg: halt and print the call stack, symbolized and unwound with the ELF file.
+func runBacktrace(core core.Core, args *Args) error {
+	if !args.Backtrace {
+		return nil
+	}
+	if args.ELF == "" {
+		return fmt.Errorf("error: -backtrace needs the firmware's ELF file, pass it with -elf")
+	}
+
+	unwinder, err := backtrace.Load(args.ELF)
+	if err != nil {
+		return err
+	}
+	err = core.Halt()
+	if err != nil {
+		return err
+	}
+	frames, err := unwinder.Backtrace(core)
+	backtrace.Print(os.Stdout, frames)
+	if err != nil {
+		fmt.Printf("Backtrace stopped: %v\n", err)
+	}
+	return nil
+}
+
+// runCoreDump handles the coredump arg: halt and write the core registers and the target's memory regions to an ELF core file,
+// a region that could only be read in part is shown next to what was asked for.
+func runCoreDump(s *session, args *Args) error {
+	if args.CoreDump == "" {
+		return nil
+	}
+
+	err := s.Core.Halt()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(args.CoreDump)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	captured, err := coredump.Write(f, s.Core, s.CoreDumpRegions)
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote core dump %s (", args.CoreDump)
+	for i, r := range captured {
+		if i > 0 {
+			fmt.Printf(", ")
+		}
+		fmt.Printf("%s 0x%08x-0x%08x", r.Name, r.Start, r.Start+r.Size)
+		if want := s.CoreDumpRegions[i]; r.Size < want.Size {
+			fmt.Printf(" of 0x%08x-0x%08x", want.Start, want.Start+want.Size)
+		}
+	}
+	fmt.Printf(")\n")
+	return nil
+}
+
+// runResumeStatus handles resuming and reporting the core state, done after everything else that needs a halted core.
+func runResumeStatus(core core.Core, args *Args) error {
+	if args.Resume {
+		err := core.Resume()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Successfully Resumed\n")
+	}
+
+	if args.Status {
+		state, err := core.Status()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Core Status: %s\n", state)
+		if state != cortexm.StateHalted {
+			return nil
+		}
+		return printSecurityState(core)
+	}
+	return nil
+}
+
+// runProfile handles the profile arg: sample the PC of the running core for args.Profile, or until interrupted, print
+// the flat profile by function with the -elf symbols and write it for pprof with -pprof.
+func runProfile(core core.Core, args *Args) error {
+	if args.Profile == 0 {
+		return nil
+	}
+
+	var symbols elfparser.SymbolTable
+	if args.ELF != "" {
+		var err error
+		symbols, err = elfparser.LoadSymbols(args.ELF)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Sampling the PC for %v, Ctrl-C to stop early\n", args.Profile)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	p, err := profile.Sample(ctx, core, args.Profile)
+	if err != nil {
+		return err
+	}
+	p.Print(os.Stdout, symbols)
+
+	if args.Pprof == "" {
+		return nil
+	}
+	f, err := os.Create(args.Pprof)
+	if err != nil {
+		return err
+	}
+	err = p.WritePprof(f, symbols)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s, view it with 'go tool pprof -top %s'\n", args.Pprof, args.Pprof)
+	return nil
+}
+
+// runPrint handles the print args: resolve the variables with -elf, read them and print their values the way Go writes
+// them.  The core isn't halted, a running core's variables may change between reads.
+func runPrint(core core.Core, args *Args) error {
+	if len(args.Print) == 0 {
+		return nil
+	}
+	if args.ELF == "" {
+		return fmt.Errorf("error: -print needs the firmware's ELF file, pass it with -elf")
+	}
+
+	info, err := dwarfparser.Load(args.ELF)
+	if err != nil {
+		return err
+	}
+	for _, name := range args.Print {
+		v, err := info.Lookup(name)
+		if err != nil {
+			return err
+		}
+		b, err := core.ReadMem(v.Addr, int(v.Size))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %s at 0x%08x = %s\n", v.Name, dwarfparser.TypeName(v.Type), v.Addr, v.Render(b))
+	}
+	return nil
+}
+
+// runWatch handles the watch args: resolve the variables with -elf and poll them while the core runs until interrupted,
+// as a table rewritten in place on a terminal, or a CSV stream with -csv.
+func runWatch(core core.Core, args *Args) error {
+	if len(args.Watch) == 0 {
+		return nil
+	}
+	if args.ELF == "" {
+		return fmt.Errorf("error: -watch needs the firmware's ELF file, pass it with -elf")
+	}
+
+	info, err := dwarfparser.Load(args.ELF)
+	if err != nil {
+		return err
+	}
+	w := &watch.Watcher{Core: core, Interval: args.Interval, CSV: args.CSV}
+	for _, name := range args.Watch {
+		v, err := info.Lookup(name)
+		if err != nil {
+			return err
+		}
+		w.Vars = append(w.Vars, v)
+	}
+	if stat, err := os.Stdout.Stat(); err == nil && stat.Mode()&os.ModeCharDevice > 0 {
+		w.Redraw = !args.CSV
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return w.Run(ctx, os.Stdout)
+}
+
+// runGDBServer handles the gdbserver command: serve gdb on args.Bind and args.Port until interrupted, gdb's load programs the flash
+// through s.Flash.  The -reset strategy, or the target's default, is what 'monitor reset' uses.
+func runGDBServer(s *session, args *Args) error {
+	if !args.GDBServer {
+		return nil
+	}
+
+	strategy, err := resetStrategy(args, s.ResetStrategy)
+	if err != nil {
+		return err
+	}
+	err = s.Flash.Configure()
+	if err != nil {
+		return err
+	}
+	server := &gdbserver.Server{
+		Core: s.Core,
+		// The flash of the SAM parts starts at 0
+		Flash:         []gdbserver.FlashRegion{{Start: 0, Size: s.Flash.FlashSize, BlockSize: s.Flash.EraseSize}},
+		Program:       nvmProgram(s.Flash),
+		ResetStrategy: strategy,
+	}
+	addr := net.JoinHostPort(args.Bind, strconv.Itoa(args.Port))
+	fmt.Printf("Listening for gdb on %s, flash 0x%x bytes in 0x%x byte blocks\n", addr, s.Flash.FlashSize, s.Flash.EraseSize)
+	return server.ListenAndServe(addr)
+}
+
+// runShell handles the shell command: an interactive console on stdin/stdout, or served over TCP on args.Bind and
+// args.Port when a port is given, until quit or interrupted.  load programs the flash through s.Flash with the files
+// in args.LoadDir.
+func runShell(s *session, args *Args) error {
+	if !args.Shell {
+		return nil
+	}
+
+	strategy, err := resetStrategy(args, s.ResetStrategy)
+	if err != nil {
+		return err
+	}
+	err = s.Flash.Configure()
+	if err != nil {
+		return err
+	}
+	server := &shell.Shell{
+		Core:          s.Core,
+		Program:       nvmProgram(s.Flash),
+		ResetStrategy: strategy,
+		Dir:           args.LoadDir,
+	}
+	if args.Port != 0 {
+		addr := net.JoinHostPort(args.Bind, strconv.Itoa(args.Port))
+		fmt.Printf("Listening for shell connections on %s\n", addr)
+		return server.ListenAndServe(addr)
+	}
+	return server.Run(os.Stdin, os.Stdout)
+}
+
+// runServeAPI handles the serve-api command: serve the JSON-RPC control API on args.Bind and args.Port, or the Unix
+// socket at args.APISocket, until interrupted.  flash programs the flash through s.Flash with the files in
+// args.LoadDir.
+func runServeAPI(s *session, args *Args) error {
+	if !args.ServeAPI {
+		return nil
+	}
+
+	strategy, err := resetStrategy(args, s.ResetStrategy)
+	if err != nil {
+		return err
+	}
+	err = s.Flash.Configure()
+	if err != nil {
+		return err
+	}
+	server := &api.Server{
+		Core:          s.Core,
+		Program:       nvmProgram(s.Flash),
+		ResetStrategy: strategy,
+		Dir:           args.LoadDir,
+	}
+	if args.APISocket != "" {
+		fmt.Printf("Serving the API on %s\n", args.APISocket)
+		return server.ListenAndServe("unix", args.APISocket)
+	}
+	addr := net.JoinHostPort(args.Bind, strconv.Itoa(args.Port))
+	fmt.Printf("Serving the API on %s\n", addr)
+	return server.ListenAndServe("tcp", addr)
+}
+
+// nvmProgram returns a function programming data into the flash at addr through nvm.
+func nvmProgram(nvm *samflash.NVMFlash) func(addr uint32, data []byte) error {
+	return func(addr uint32, data []byte) error {
+		nvm.WriteAddress = addr
+		return nvm.LoadProgram(data)
+	}
+}
+
+// runRTT handles the rtt arg: find the RTT control block, by its symbol with -elf or by scanning s.RAM, and stream up
+// buffer 0 to stdout and stdin to down buffer 0 until interrupted.
+func runRTT(s *session, args *Args) error {
+	if !args.RTT {
+		return nil
+	}
+
+	var addr uint32
+	if args.ELF != "" {
+		symbols, err := elfparser.LoadSymbols(args.ELF)
+		if err != nil {
+			return err
+		}
+		sym, ok := symbols.Find(rtt.SymbolName)
+		if !ok {
+			return fmt.Errorf("error: runRTT() no %s symbol in %s", rtt.SymbolName, args.ELF)
+		}
+		addr = sym.Addr
+	} else {
+		var err error
+		addr, err = rtt.Find(s.Core, s.RAM.Start, s.RAM.Size)
+		if err != nil {
+			return err
+		}
+	}
+	r, err := rtt.Open(s.Core, addr)
+	if err != nil {
+		return err
+	}
+	if len(r.Up) == 0 {
+		return fmt.Errorf("error: runRTT() the control block at 0x%08x has no up buffers", addr)
+	}
+	fmt.Fprintf(os.Stderr, "RTT control block at 0x%08x, streaming %q, Ctrl-C to stop\n", addr, r.Up[0].Name)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return r.Stream(ctx, 0, os.Stdin, os.Stdout, 10*time.Millisecond)
+}
+
+// printSecurityState prints the security state of a halted core and what the debug authentication allows, nothing without the Security Extension.
+func printSecurityState(core core.Core) error {
+	security, err := core.SecurityState()
+	if err != nil || security == cortexm.SecurityNone {
+		return err
+	}
+	auth, err := core.DebugAuthentication()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Security State: %s (secure debug: %t, non-secure debug: %t)\n", security, auth.SecureInvasive, auth.NonSecureInvasive)
+	return nil
+}
+
+// runCoreRegs handles the core register args, the core is halted first since core registers are only accessible while halted.
+func runCoreRegs(core core.Core, args *Args) error {
+	if !args.Regs && args.SetRegName == "" {
+		return nil
+	}
+
+	err := core.Halt()
+	if err != nil {
+		return err
+	}
+
+	if args.SetRegName != "" {
+		reg, err := cortexm.ParseRegister(args.SetRegName)
+		if err != nil {
+			return err
+		}
+		err = core.WriteCoreRegister(reg, uint32(args.SetRegValue))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("WriteCoreRegister[%s: 0x%x]\n", reg, args.SetRegValue)
+	}
+
+	if args.Regs {
+		return printCoreRegs(core)
+	}
+	return nil
+}
+
+// printCoreRegs reads and prints every core register of a halted core, as listed by the core package.
+func printCoreRegs(core core.Core) error {
+	regs, err := core.CoreRegisters()
+	if err != nil {
+		return err
+	}
+
+	values := make([]uint32, len(regs))
+	for i, reg := range regs {
+		values[i], err = core.ReadCoreRegister(reg)
+		if err != nil {
+			return err
+		}
+	}
+	printRegisters(os.Stdout, regs, values)
+	return nil
+}
+
+// printRegisters prints register names and values as a table, four registers per row.
+func printRegisters(w io.Writer, regs []cortexm.Register, values []uint32) {
+	for i, reg := range regs {
+		fmt.Fprintf(w, "%-10s0x%08x", reg, values[i])
+		if i%4 == 3 || i == len(regs)-1 {
+			fmt.Fprintln(w)
+			continue
+		}
+		fmt.Fprint(w, "    ")
+	}
+}
@@ -1,7 +1,22 @@
 // Package targets contains the "wiring" for each supported target.
 package targets
 
-import "log"
+import (
+	"encoding/binary"
+	"fmt"
+	"goocd/actions/samflash"
+	"goocd/core"
+	"goocd/core/adi"
+	"goocd/core/cortexm"
+	"goocd/mcus"
+	"goocd/probes/samatmelice"
+	"goocd/protocols/cmsisdap"
+	"goocd/protocols/usbhid"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
 
 // TargetMap is where each target registers itself.
 var TargetMap = make(map[string]*Target)
@@ -10,8 +25,12 @@ var TargetMap = make(map[string]*Target)
 // and tell a target what to do.
 type Args struct {
 	Load  string // file path to load (elfparser, hex, bin)
-	Reset bool
 	Stats bool
+	// -reset
+	// -reset=sys,halt (empty strategy means the target's default)
+	Reset         bool
+	ResetStrategy string
+	ResetHalt     bool
 	// -readmemu32=0xF0000000,5
 	// -readmemu32=0xF0000000 (count=1 implied)
 	ReadMemU32Addr   uint64
@@ -19,6 +38,80 @@ type Args struct {
 	WriteMemU32Addr  uint64
 	WriteMemU32Value uint64
 	WriteMemU32Count int
+	// -readmemu8=0x41002001,3 (any alignment)
+	// -readmemu16=0x41004018
+	ReadMemU8Addr    uint64
+	ReadMemU8Count   int
+	ReadMemU16Addr   uint64
+	ReadMemU16Count  int
+	WriteMemU8Addr   uint64
+	WriteMemU8Value  uint64
+	WriteMemU8Count  int
+	WriteMemU16Addr  uint64
+	WriteMemU16Value uint64
+	WriteMemU16Count int
+	// -regs
+	// -setreg=pc,0x20000000
+	Regs        bool
+	SetRegName  string
+	SetRegValue uint64
+	// -halt -step -maskints -resume -status
+	Halt         bool
+	Step         bool
+	StepMaskInts bool
+	Resume       bool
+	Status       bool
+	// -break=0x412 -timeout=10s
+	Break     bool
+	BreakAddr uint64
+	Timeout   time.Duration
+	// -cycles=0x412,0x430 -iterations=10 -cpuclock=48e6
+	Cycles      bool
+	CyclesStart uint32
+	CyclesEnd   uint32
+	Iterations  int
+	CPUClock    float64
+	// -watchpoint=0x20000100,4,w
+	Watchpoint     bool
+	WatchpointAddr uint64
+	WatchpointSize uint64
+	WatchpointKind string
+	// -nonsecure
+	NonSecure bool
+	// -fault
+	Fault bool
+	// -backtrace -elf=firmware.elf
+	Backtrace bool
+	ELF       string
+	// -coredump=out.elf
+	CoreDump string
+	// -semihosting -semihostingdir=testdata
+	Semihosting    bool
+	SemihostingDir string
+	// -rtt, the control block found with -elf or by scanning RAM
+	RTT bool
+	// -print=config.uart.baud,state -elf=firmware.elf
+	Print []string
+	// -watch=counter,state.mode -elf=firmware.elf -interval=100ms -csv
+	Watch    []string
+	Interval time.Duration
+	CSV      bool
+	// -profile=10s -elf=firmware.elf -pprof=fw.pb.gz
+	Profile time.Duration
+	Pprof   string
+	// goocd gdbserver -bind=127.0.0.1 -port=3333
+	GDBServer bool
+	Bind      string
+	Port      int
+	// goocd shell -loaddir=fw, served over TCP on args.Bind and args.Port when a port is given
+	Shell   bool
+	LoadDir string
+	// goocd serve-api, on args.Bind and args.Port or the Unix socket at APISocket, with the files in args.LoadDir
+	ServeAPI  bool
+	APISocket string
+
+	// ExitCode is set by the run modes that report one, like the target's own with -semihosting
+	ExitCode int
 }
 
 // Target is anything that can be "Run" as a target.
@@ -40,6 +133,77 @@ func (f TargetFunc) Run(args *Args) error {
 	return f(args)
 }
 
+// selectCore detects the core behind t and returns its core package, configured.  A core other than expected is a warning,
+// except when flashing: the flash settings belong to the part the target was written for.
+func selectCore(t adi.DAPTransferer, args *Args, expected cortexm.PartNumber) (core.Core, error) {
+	c, id, err := core.Detect(t, args.NonSecure)
+	if err != nil {
+		return nil, err
+	}
+	if id.PartNo == expected {
+		return c, nil
+	}
+	if args.Load != "" {
+		return nil, fmt.Errorf("error: selectCore() found a %s, this target expects a %s", id, expected)
+	}
+	fmt.Printf("Warning: found a %s, this target expects a %s, continuing with the %s driver\n", id, expected, id.PartNo)
+	return c, nil
+}
+
+// scsRegions are the System Control Space blocks every Cortex-M core defines, the reserved addresses between them may
+// fault.  A block is cut short where a core implements less of it, like the NVIC of ARMv6-M.
+var scsRegions = []mcus.Region{
+	{Name: "SysTick", Start: 0xE000E010, Size: 0x10},
+	{Name: "NVIC_ISER", Start: 0xE000E100, Size: 0x40},
+	{Name: "NVIC_ISPR", Start: 0xE000E200, Size: 0x40},
+	{Name: "NVIC_IPR", Start: 0xE000E400, Size: 0x1F0},
+	{Name: "SCB", Start: 0xE000ED00, Size: 0x40},
+	{Name: "DEBUG", Start: 0xE000EDF0, Size: 0x10},
+}
+
+// samAtmelICE is a SAM part debugged through an Atmel-ICE, the targets only differ in the data below.
+type samAtmelICE struct {
+	// Part is the core the target was written for, see selectCore
+	Part cortexm.PartNumber
+	// ResetStrategy is the default of -reset, -break, -cycles and the servers
+	ResetStrategy cortexm.ResetStrategy
+	// NVM is the flash controller, its CMSISDAP and Cortex are filled in once connected
+	NVM samflash.NVMFlash
+	// RAM is where -rtt scans for the control block
+	RAM mcus.Region
+	// CoreDumpRegions is what -coredump captures, peripherals with read side effects like the SERCOM DATA registers are left out
+	CoreDumpRegions []mcus.Region
+	// SkipWriteMemU32 are addresses -writememu32 leaves alone
+	SkipWriteMemU32 []uint64
+}
+
+// Run connects to the part through the first Atmel-ICE found and does what args ask.
+func (t *samAtmelICE) Run(args *Args) error {
+	d, err := usbhid.OpenFirstHid(samatmelice.VendorID, samatmelice.ProductID)
+	checkErr(err)
+	defer d.CleanUp()
+	// Pass CMSIS the USBHID Device
+	cms := &cmsisdap.CMSISDAP{ReadWriter: d}
+
+	// Configure CMSIS, then let the Cortex Driver match the core found
+	checkErr(cms.Configure(cmsisdap.ClockSpeed2Mhz, samatmelice.IceParamaters))
+	core, err := selectCore(cms, args, t.Part)
+	checkErr(err)
+
+	// The flash controller, programmed by -load and by gdb's load
+	nvm := t.NVM
+	nvm.CMSISDAP = cms
+	nvm.Cortex = core
+
+	s := &session{samAtmelICE: t, Core: core, Flash: &nvm}
+	for _, mode := range runModes {
+		checkErr(mode(s, args))
+	}
+
+	_ = cms.DAPDisconnect()
+	return nil
+}
+
 func addTarget(tar *Target) {
 	TargetMap[tar.Name] = tar
 }
@@ -49,3 +213,48 @@ func checkErr(err error) {
 		log.Fatalf("err: %+v", err)
 	}
 }
+
+// printMem prints memory read from addr as a hexdump style table, 16 bytes per row shown as little endian values of width bytes, followed by their ASCII representation.
+func printMem(w io.Writer, addr uint32, b []byte, width int) {
+	const bytesPerRow = 16
+	for row := 0; row < len(b); row += bytesPerRow {
+		var hexCol, asciiCol strings.Builder
+		for i := row; i < row+bytesPerRow; i += width {
+			if i >= len(b) {
+				hexCol.WriteString(strings.Repeat(" ", 1+width*2))
+				continue
+			}
+			value := uint64(0)
+			for j := width - 1; j >= 0; j-- {
+				value = value<<8 | uint64(b[i+j])
+			}
+			fmt.Fprintf(&hexCol, " %0*x", width*2, value)
+		}
+		for i := row; i < row+bytesPerRow && i < len(b); i++ {
+			c := b[i]
+			if c < 0x20 || c > 0x7E {
+				c = '.'
+			}
+			asciiCol.WriteByte(c)
+		}
+		fmt.Fprintf(w, "0x%08x:%s  |%s|\n", addr+uint32(row), hexCol.String(), asciiCol.String())
+	}
+}
+
+// printMemU32 prints words read from addr, see printMem.
+func printMemU32(w io.Writer, addr uint32, values []uint32) {
+	b := make([]byte, 0, len(values)*4)
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint32(b, v)
+	}
+	printMem(w, addr, b, 4)
+}
+
+// printMemU16 prints halfwords read from addr, see printMem.
+func printMemU16(w io.Writer, addr uint32, values []uint16) {
+	b := make([]byte, 0, len(values)*2)
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint16(b, v)
+	}
+	printMem(w, addr, b, 2)
+}